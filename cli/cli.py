@@ -92,6 +92,7 @@ class API:
 class FilestorageAPI(API):
 	BLOCK_SIZE = 4096
 	DATA_ALLOWANCE_PER_BLOCK = 3914
+	RETENTION_DATA_ALLOWANCE_PER_BLOCK = 3890
 
 	def __init__(self, host, bc_id, block_timeout=None):
 		if block_timeout is None: block_timeout = 5
@@ -120,6 +121,71 @@ class FilestorageAPI(API):
 		})
 		return out['result']
 	
+	def get_inclusion_proof(self, block_id, chunk=False):
+		""" returns a proof that a block's transaction, or its chunk, is in the block """
+		out = self._call_bc('getInclusionProof', {
+			'blockID': block_id,
+			'chunk': chunk
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def _merkle_proof_root(self, node, index, count, hashes):
+		""" returns the root a Merkle proof leads to from a leaf, or None if it's malformed """
+		hashes = list(hashes)
+		node = hashlib.sha256(b'\x00' + node).digest()
+		while count > 1:
+			if index ^ 1 < count:
+				if not hashes:
+					return None
+				sibling = hashes.pop(0)
+				node = hashlib.sha256(b'\x01' + (node + sibling if index % 2 == 0 else sibling + node)).digest()
+			count = (count + 1) // 2
+			index //= 2
+		return None if hashes else node
+
+	def verify_inclusion_proof(self, block_id, proof):
+		""" returns True iff a proof from get_inclusion_proof holds for the block ID """
+		header = bytes.fromhex(proof['header'])
+		if cb58ref.cb58encode(hashlib.sha256(header).digest()) != block_id:
+			return False
+		hashes = [bytes.fromhex(h) for h in proof['proof']]
+		# every tx root has 2 leaves
+		root = self._merkle_proof_root(bytes.fromhex(proof['leaf']), int(proof['index']), 2, hashes)
+		return root == header[-32:]
+	
+	def get_account_proof(self, account=None, block_id=None):
+		""" returns a proof of an account's balance, not counting stakes, as of a block """
+		if account is None: account = self.keypair[0]
+		params = {'account': account}
+		if block_id is not None: params['blockID'] = block_id
+		out = self._call_bc('getAccountProof', params)
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def verify_account_proof(self, block_id, proof, account=None):
+		""" returns True iff a proof from get_account_proof of an account's balance holds for the block ID """
+		if account is None: account = self.keypair[0]
+		header = bytes.fromhex(proof['header'])
+		if cb58ref.cb58encode(hashlib.sha256(header).digest()) != block_id:
+			return False
+		key, value = bytes.fromhex(proof['key']), bytes.fromhex(proof['value'])
+		if key != b'a' + account.encode('utf8') or len(value) != 8:
+			return False
+		if int.from_bytes(value, 'big') != int(proof['balance']) or hashlib.sha256(key).digest()[0] != int(proof['bucket']):
+			return False
+		hashes = [bytes.fromhex(h) for h in proof['proof']]
+		count = int(proof['count'])
+		leaves_root = self._merkle_proof_root(hashlib.sha256(key + value).digest(), int(proof['index']), count, hashes)
+		if leaves_root is None:
+			return False
+		# the bucket's root commits to its number of leaves
+		bucket_root = hashlib.sha256(count.to_bytes(8, 'big') + leaves_root).digest()
+		hashes = [bytes.fromhex(h) for h in proof['bucketProof']]
+		return self._merkle_proof_root(bucket_root, int(proof['bucket']), 256, hashes) == header[-64:-32]
+
 	def get_block_id_from_data(self, data, after_block_id):
 		timeout = self.block_timeout
 		iterations = 0
@@ -135,24 +201,76 @@ class FilestorageAPI(API):
 			if iterations > timeout:
 				raise Exception('Timeout, block probably was not accepted')
 
-	def get_balance(self, account=None):
+	def _at_block(self, params, block_id=None, height=None):
+		""" adds the block a query is answered as of to its params, by ID or height """
+		if block_id is not None: params['blockID'] = block_id
+		if height is not None: params['height'] = str(height)
+		return params
+
+	def get_balance(self, account=None, block_id=None, height=None):
 		if account is None: account = self.keypair[0]
-		out = self._call_bc('getBalance', {
+		out = self._call_bc('getBalance', self._at_block({
 			'account': account
-		})
+		}, block_id, height))
+		if 'error' in out:
+			raise Exception(out['error']['message'])
 		return out['result']['balance']
+
+	def get_stakes(self, account=None, block_id=None, height=None):
+		""" returns the stakes whose rewards go to an account, oldest first """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getStakes', self._at_block({
+			'account': account
+		}, block_id, height))
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']['stakes']
 	
-	def get_storage_cost(self):
-		""" returns the price to store one upload block """
-		out = self._call_bc('getStorageCost', {})
+	def get_account_history(self, account=None, types=None):
+		""" returns every accepted transaction involving an account, oldest first """
+		if account is None: account = self.keypair[0]
+		entries = []
+		cursor = ''
+		while True:
+			out = self._call_bc('getAccountHistory', {
+				'address': account,
+				'cursor': cursor,
+				'types': types or []
+			})
+			if 'error' in out:
+				raise Exception(out['error']['message'])
+			entries += out['result']['entries']
+			cursor = out['result']['nextCursor']
+			if cursor == '':
+				return entries
+
+	def get_storage_cost(self, size=0):
+		""" returns the price to upload size bytes, or one full upload block if size is 0 """
+		out = self._call_bc('getStorageCost', {
+			'size': str(size)
+		})
 		return out['result']['cost']
 	
+	def estimate_fee(self, size=0, days=0):
+		""" returns the next block's base fee, the pricing parameters, and what uploading size bytes would cost, with the rent for keeping them days days """
+		out = self._call_bc('estimateFee', {
+			'size': str(size),
+			'days': str(days)
+		})
+		return out['result']
+	
 	def pack_block(self, block_type, block_data):
 		block_types = [
 			0, # data chunk
 			1, # balance transfer
 			2, # stake
+			5, # chunk reference
+			6, # renew file
+			7, # delete file
+			8, # transfer file
 			9, # faucet
+			'a', # file access
+			'e', # upload with retention
 		]
 		if block_type not in block_types:
 			raise Exception('no, bad coder, do it right.')
@@ -182,6 +300,14 @@ class FilestorageAPI(API):
 		output = [file_id, chunk_number, chunk]
 		return output
 	
+	def unpack_retention_block(self, data):
+		file_id = data[0:16]
+		chunk_number = int(data[16:24])
+		days = int(data[24:32])
+		rent = int(data[32:48])
+		chunk = data[48:]
+		return [file_id, chunk_number, days, rent, chunk]
+	
 	def unpack_block(self, block):
 		data = self.decode(block)
 		pubkey = data[:50]
@@ -193,8 +319,11 @@ class FilestorageAPI(API):
 		block_data = data[5:5 + block_length]
 
 		output = [block_type]
-		if block_type == '0':
+		if block_type == '0' or block_type == '5':
+			# for a chunk reference, the "chunk" is the hash of the data
 			output += self.unpack_data_block(block_data)
+		elif block_type == 'e':
+			output += self.unpack_retention_block(block_data)
 		elif block_type == '9':
 			output += self.unpack_faucet_block(block_data)
 		else:
@@ -215,28 +344,257 @@ class FilestorageAPI(API):
 		payload = self.pack_block(0, data)
 		return self.upload_block(payload)
 	
-	def upload_data(self, data, force=None):
-		file_id = secrets.token_hex(8)
+	def get_upload_rent(self, file_id, chunk_size, days=0, account=None):
+		""" returns the rent an upload with retention of chunk_size bytes to a file pays, and the days it declares """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getUploadRent', {
+			'owner': account,
+			'fileID': file_id,
+			'chunkSize': str(chunk_size),
+			'days': str(days)
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def upload_retention_chunk(self, file_id, chunk_number, chunk, days):
+		""" uploads a chunk that pays rent; the file's first one keeps it days days """
+		quote = self.get_upload_rent(file_id, len(chunk.encode('utf8')), days)
+		data = file_id + str(chunk_number).zfill(8) + str(quote['days']).zfill(8) + str(quote['rent']).zfill(16) + chunk
+		payload = self.pack_block('e', data)
+		return self.upload_block(payload)
+	
+	def reference_data_chunk(self, file_id, chunk_number, chunk_hash):
+		chk_num = str(chunk_number)
+		while len(chk_num) < 8:
+			chk_num = '0' + chk_num
+		data = ''.join([file_id, chk_num, chunk_hash])
+		payload = self.pack_block(5, data)
+		return self.upload_block(payload)
+	
+	def get_chunk(self, chunk_hash):
+		""" returns the data of the chunk with that SHA-256, or None if it isn't stored """
+		out = self._call_bc('getChunk', {
+			'hash': chunk_hash
+		})
+		if 'error' in out:
+			return None
+		return cb58ref.cb58decode(out['result']['content']).decode('utf8')
+	
+	def get_chunk_data(self, block_id):
+		""" returns the data of an upload block, following chunk references """
+		sections = self.unpack_block(self.get_block(block_id)['data'])
+		if sections[0] == '5':
+			return self.get_chunk(sections[3])
+		if sections[0] == 'e':
+			return sections[5]
+		return sections[3]
+	
+	def get_rent(self, file_id, days, account=None):
+		""" returns the rent for keeping a file days more days, its size and when it expires now """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getRent', {
+			'owner': account,
+			'fileID': file_id,
+			'days': str(days)
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def renew_file(self, file_id, days):
+		""" keeps one of your files days more days, paying the rent for them """
+		rent = int(self.get_rent(file_id, days)['rent'])
+		data = file_id + str(days).zfill(8) + str(rent).zfill(16)
+		payload = self.pack_block(6, data)
+		return self.upload_block(payload)
+	
+	def get_refund(self, file_id, account=None):
+		""" returns the rent refunded by deleting a file, and the fee for deleting it """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getRefund', {
+			'owner': account,
+			'fileID': file_id
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def delete_file(self, file_id):
+		""" deletes one of your files, claiming back whatever rent can be refunded """
+		refund = int(self.get_refund(file_id)['refund'])
+		data = file_id + str(refund).zfill(16)
+		payload = self.pack_block(7, data)
+		return self.upload_block(payload)
+	
+	def transfer_file(self, file_id, recipient):
+		""" gives one of your files to another account """
+		data = file_id + recipient
+		payload = self.pack_block(8, data)
+		return self.upload_block(payload)
+	
+	def set_file_access(self, file_id, account, role):
+		""" sets another account's role on a file: 'none', 'write' or 'admin' """
+		roles = { 'none': '0', 'write': '1', 'admin': '2' }
+		data = file_id + account + roles[role]
+		payload = self.pack_block('a', data)
+		return self.upload_block(payload)
+	
+	def get_file_access(self, file_id, account=None):
+		""" returns the accounts that were given access to a file, and their roles """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getFileAccess', {
+			'owner': account,
+			'fileID': file_id
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def list_versions(self, file_id, account=None, block_id=None, height=None):
+		""" returns every version of a file, oldest first """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('listVersions', self._at_block({
+			'owner': account,
+			'fileID': file_id
+		}, block_id, height))
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']['versions']
+	
+	def rename_file(self, file_id, path):
+		""" moves a file to a new path in its owner's directory tree """
+		data = file_id + str(len(path)).zfill(3) + path
+		payload = self.pack_block('c', data)
+		return self.upload_block(payload)
+	
+	def list_directory(self, path='/', account=None):
+		""" returns the files and directories in a directory """
+		if account is None: account = self.keypair[0]
+		entries = []
+		cursor = ''
+		while True:
+			out = self._call_bc('listDirectory', {
+				'owner': account,
+				'path': path,
+				'cursor': cursor
+			})
+			if 'error' in out:
+				raise Exception(out['error']['message'])
+			entries += out['result']['entries']
+			cursor = out['result']['nextCursor']
+			if cursor == '':
+				return entries
+	
+	def anchor(self, data_hash):
+		""" records the hex encoded SHA-256 of a document on the chain """
+		payload = self.pack_block('d', data_hash.lower())
+		return self.upload_block(payload)
+	
+	def anchor_batch(self, hashes):
+		""" anchors the Merkle root of a list of hex encoded hashes, and returns the proof of each """
+		level = [hashlib.sha256(b'\x00' + bytes.fromhex(h)).digest() for h in hashes]
+		proofs = [[] for h in hashes]
+		positions = list(range(len(hashes)))
+		while len(level) > 1:
+			for i, position in enumerate(positions):
+				sibling = position ^ 1
+				if sibling < len(level):
+					proofs[i].append(level[sibling].hex())
+				positions[i] = position // 2
+			level = [hashlib.sha256(b'\x01' + level[j] + level[j + 1]).digest() if j + 1 < len(level) else level[j] for j in range(0, len(level), 2)]
+		block_id = self.anchor(level[0].hex())
+		return block_id, [{ 'hash': h, 'index': i, 'count': len(hashes), 'proof': proofs[i] } for i, h in enumerate(hashes)]
+	
+	def verify_anchor(self, data_hash, index=0, count=0, proof=None):
+		""" returns the block, time and signer of the first anchor of a hash, or of the batch it's in """
+		out = self._call_bc('verifyAnchor', {
+			'hash': data_hash,
+			'index': index,
+			'count': count,
+			'proof': proof or []
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def get_upload_progress(self, file_id, account=None, cursor=''):
+		""" returns the chunk numbers of a file that are uploaded, and a page of the ones missing after cursor """
+		if account is None: account = self.keypair[0]
+		out = self._call_bc('getUploadProgress', {
+			'owner': account,
+			'fileID': file_id,
+			'cursor': cursor
+		})
+		if 'error' in out:
+			raise Exception(out['error']['message'])
+		return out['result']
+	
+	def get_uploaded_chunk_ids(self, file_id, account=None):
+		""" returns a dict of chunk number => block ID for a file's accepted chunks """
+		if account is None: account = self.keypair[0]
+		progress = self.get_upload_progress(file_id, account)
+		if len(progress['uploaded']) == 0:
+			return {}
+		out = self._call_bc('getFile', {
+			'owner': account,
+			'fileID': file_id
+		})
+		chunks = out['result']['chunks']
+		return {int(c['chunkNumber']): c['blockID'] for c in chunks}
+	
+	def upload_data(self, data, force=None, file_id=None, retention_days=None):
+		""" pass the file_id of an interrupted upload to resume it, and retention_days to keep it that many days """
+		uploaded = {}
+		if file_id is None:
+			file_id = secrets.token_hex(8)
+		else:
+			uploaded = self.get_uploaded_chunk_ids(file_id)
+		print(f'file id: {file_id}')
 		number_of_chunks = math.ceil(len(data) / FilestorageAPI.DATA_ALLOWANCE_PER_BLOCK)
-		upload_cost = self.get_storage_cost()
+		offset_size = FilestorageAPI.DATA_ALLOWANCE_PER_BLOCK
+		if retention_days is not None:
+			# uploads with retention carry the days and the rent too
+			offset_size = FilestorageAPI.RETENTION_DATA_ALLOWANCE_PER_BLOCK
+			number_of_chunks = math.ceil(len(data) / offset_size)
+		missing_size = 0
+		for n in range(number_of_chunks):
+			if n not in uploaded:
+				missing_size += len(data[n * offset_size : (n + 1) * offset_size].encode('utf8'))
+		upload_cost = self.get_storage_cost(missing_size) if missing_size > 0 else 0
+		if retention_days is not None and missing_size > 0:
+			estimate = self.estimate_fee(missing_size, retention_days)
+			upload_cost = int(estimate['uploadFee']) + int(estimate['rent'])
 		balance = self.get_balance()
-		if number_of_chunks * upload_cost > balance:
+		if upload_cost > balance:
 			if force is None:
 				print('Balance not enough to upload data. Quitting. Pass force=True to bypass.')
 				raise Exception('BALANCE_NOT_ENOUGH')
 			else:
 				print('Balance is not enough to upload data, but we are bypassing because you passed force=True')
 		chunk_num = 0
-		offset_size = FilestorageAPI.DATA_ALLOWANCE_PER_BLOCK
 		block_ids = []
 		chunks = []
 		uploaded_chunks = []
 		while True:
-			print(f'uploading chunk {chunk_num + 1}/{number_of_chunks}')
 			chunk = data[chunk_num * offset_size : (chunk_num + 1) * offset_size]
 			chunks.append(chunk)
-			block_id = self.upload_data_chunk(file_id, chunk_num, chunk)
-			uploaded_chunk = self.unpack_block(self.get_block(block_id)['data'])[3]
+			if chunk_num in uploaded:
+				print(f'chunk {chunk_num + 1}/{number_of_chunks} was already uploaded')
+				block_id = uploaded[chunk_num]
+			elif retention_days is not None:
+				# the chunks of a file with retention all pay rent, so none are references
+				print(f'uploading chunk {chunk_num + 1}/{number_of_chunks}')
+				block_id = self.upload_retention_chunk(file_id, chunk_num, chunk, retention_days)
+			else:
+				chunk_hash = hashlib.sha256(chunk.encode('utf8')).hexdigest()
+				if self.get_chunk(chunk_hash) is not None:
+					print(f'referencing chunk {chunk_num + 1}/{number_of_chunks}, its data is already stored')
+					block_id = self.reference_data_chunk(file_id, chunk_num, chunk_hash)
+				else:
+					print(f'uploading chunk {chunk_num + 1}/{number_of_chunks}')
+					block_id = self.upload_data_chunk(file_id, chunk_num, chunk)
+			uploaded_chunk = self.get_chunk_data(block_id)
 			assert uploaded_chunk == chunk
 			uploaded_chunks.append(uploaded_chunk)
 			assert ''.join(uploaded_chunks) == data[:(chunk_num + 1) * offset_size]
@@ -250,9 +608,7 @@ class FilestorageAPI(API):
 	def download_data(self, block_ids):
 		data = ''
 		for block_id in block_ids:
-			block = self.get_block(block_id)
-			sections = self.unpack_block(block['data'])
-			data += sections[3]
+			data += self.get_chunk_data(block_id)
 		return data
 	
 	def upload_file(self, filename):
@@ -306,8 +662,8 @@ class FilestorageAPI(API):
 		payload = self.pack_block(9, data)
 		return self.upload_block(payload)
 	
-	def get_unallocated_balance(self):
-		return self._call_bc('getUnallocatedFunds', {})
+	def get_unallocated_balance(self, block_id=None, height=None):
+		return self._call_bc('getUnallocatedFunds', self._at_block({}, block_id, height))
 	
 	def transfer(self, amount, recipient):
 		sender = self.keypair[0]
@@ -0,0 +1,195 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+var (
+	accountHistoryIndexPrefix = []byte("accountHistory")
+
+	// Key in the account history index marking that it was backfilled. Its
+	// keys are otherwise longer, so it can't be one.
+	accountHistoryIndexedKey = []byte("indexed")
+
+	errBadHistoryType   = errors.New("unknown account history entry type")
+	errBadHistoryCursor = errors.New("malformed account history cursor")
+)
+
+// The account history index has an entry for each way an accepted block
+// involves an account, keyed by (account, height, entry type) and holding
+// the block's ID. A block can involve an account more than once, like a
+// transfer to oneself, so the entry type is part of the key.
+type accountHistoryType byte
+
+const (
+	// An upload, chunk reference or manifest the account paid for
+	historyUpload accountHistoryType = 'u'
+
+	// Funds the account sent or received
+	historyTransferOut accountHistoryType = 'o'
+	historyTransferIn  accountHistoryType = 'i'
+
+	// A faucet drip to the account
+	historyFaucet accountHistoryType = 'f'
+
+	// A stake whose rewards go to the account. Rewards aren't paid by a
+	// transaction of their own, but against the stake once it ends.
+	historyStake accountHistoryType = 's'
+
+	// Any other transaction the account paid a fee for, like a renewal,
+	// deletion, rename, file transfer, access change or anchor
+	historyFee accountHistoryType = 'p'
+)
+
+// accountHistoryTypes are the names of the entry types in the API
+var accountHistoryTypes = map[string]accountHistoryType{
+	"upload":      historyUpload,
+	"transferOut": historyTransferOut,
+	"transferIn":  historyTransferIn,
+	"faucet":      historyFaucet,
+	"stake":       historyStake,
+	"fee":         historyFee,
+}
+
+func (t accountHistoryType) String() string {
+	for name, historyType := range accountHistoryTypes {
+		if historyType == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// accountHistoryEntry is an entry in an account's history
+type accountHistoryEntry struct {
+	height      uint64
+	historyType accountHistoryType
+	block       *Block
+}
+
+// cursor returns the cursor to pass to listAccountHistory to start after
+// this entry
+func (e *accountHistoryEntry) cursor() string {
+	return hex.EncodeToString(append(database.PackUInt64(e.height), byte(e.historyType)))
+}
+
+// getAccountHistoryEntries returns how the transaction in this block
+// involves each account it involves
+func (b *Block) getAccountHistoryEntries() map[string][]accountHistoryType {
+	entries := map[string][]accountHistoryType{}
+	switch {
+	case b.isFaucetBlock():
+		entries[b.getFaucetRecipient()] = []accountHistoryType{historyFaucet}
+	case b.isTransferBlock():
+		entries[b.getTransferSender()] = append(entries[b.getTransferSender()], historyTransferOut)
+		entries[b.getTransferRecipient()] = append(entries[b.getTransferRecipient()], historyTransferIn)
+	case b.isStakeBlock():
+		entries[b.getStakeRewardAddress()] = []accountHistoryType{historyStake}
+	case b.isUploadBlock() || b.isManifestBlock():
+		entries[b.getSigner()] = []accountHistoryType{historyUpload}
+	case b.isRenewBlock() || b.isDeleteBlock() || b.isFileTransferBlock() || b.isFileAccessBlock() || b.isRenameBlock() || b.isAnchorBlock():
+		entries[b.getSigner()] = []accountHistoryType{historyFee}
+	}
+	return entries
+}
+
+// accountHistoryKey returns the key of [account]'s entry of type
+// [historyType] at [height]
+func accountHistoryKey(account string, height uint64, historyType accountHistoryType) []byte {
+	key := make([]byte, 0, addressLen+9)
+	key = append(key, account...)
+	key = append(key, database.PackUInt64(height)...)
+	return append(key, byte(historyType))
+}
+
+// indexAccountHistory adds the accepted block [b] to the history of each
+// account it involves
+func (vm *VM) indexAccountHistory(b *Block) error {
+	id := b.ID()
+	for account, historyTypes := range b.getAccountHistoryEntries() {
+		for _, historyType := range historyTypes {
+			if err := vm.accountHistoryDB.Put(accountHistoryKey(account, b.Height(), historyType), id[:]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reindexAccountHistory adds the blocks accepted before the account
+// history index existed to it
+func (vm *VM) reindexAccountHistory() error {
+	indexed, err := vm.accountHistoryDB.Has(accountHistoryIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	blocks, err := vm.findAccepted(func(b *Block) bool {
+		return len(b.getAccountHistoryEntries()) > 0
+	})
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := vm.indexAccountHistory(block); err != nil {
+			return err
+		}
+	}
+	if err := vm.accountHistoryDB.Put(accountHistoryIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
+
+// listAccountHistory returns up to [limit] of [account]'s history entries
+// after [cursor], oldest first. If [historyTypes] isn't empty, only entries
+// of those types are returned.
+func (vm *VM) listAccountHistory(account string, cursor string, limit int, historyTypes map[accountHistoryType]bool) ([]*accountHistoryEntry, error) {
+	start := []byte(account)
+	if cursor != "" {
+		after, err := hex.DecodeString(cursor)
+		if err != nil || len(after) != 9 {
+			return nil, errBadHistoryCursor
+		}
+		// The smallest key after the cursor's
+		start = append(append(start, after...), 0)
+	}
+	iter := vm.accountHistoryDB.NewIteratorWithStartAndPrefix(start, []byte(account))
+	defer iter.Release()
+
+	entries := []*accountHistoryEntry{}
+	for len(entries) < limit && iter.Next() {
+		key := iter.Key()
+		if len(key) != addressLen+9 {
+			continue
+		}
+		historyType := accountHistoryType(key[len(key)-1])
+		if len(historyTypes) > 0 && !historyTypes[historyType] {
+			continue
+		}
+		height, err := database.ParseUInt64(key[addressLen : addressLen+8])
+		if err != nil {
+			return nil, err
+		}
+		id, err := ids.ToID(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		block, err := vm.getBlock(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &accountHistoryEntry{
+			height:      height,
+			historyType: historyType,
+			block:       block,
+		})
+	}
+	return entries, iter.Error()
+}
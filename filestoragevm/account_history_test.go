@@ -0,0 +1,114 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/utils/json"
+)
+
+// getTestAccountHistory returns every page of [address]'s history of
+// [types], [limit] entries at a time
+func getTestAccountHistory(t *testing.T, vm *VM, address string, limit uint32, types ...string) []APIAccountHistoryEntry {
+	entries := []APIAccountHistoryEntry{}
+	cursor := ""
+	for {
+		reply := &GetAccountHistoryReply{}
+		args := &GetAccountHistoryArgs{Address: address, Cursor: cursor, Limit: json.Uint32(limit), Types: types}
+		if err := (&Service{vm}).GetAccountHistory(nil, args, reply); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, reply.Entries...)
+		if reply.NextCursor == "" {
+			return entries
+		}
+		cursor = reply.NextCursor
+	}
+}
+
+func TestAccountHistory(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	faucet := acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 20, alice.address))
+	upload := acceptTestPayload(t, vm, newTestUploadPayload(t, alice, "file000000000001", 0, "hello"))
+	transfer := acceptTestPayload(t, vm, newTestPayload(t, alice, '1', fmt.Sprintf("%016d%s%s", 5, alice.address, bob.address)))
+	self := acceptTestPayload(t, vm, newTestPayload(t, alice, '1', fmt.Sprintf("%016d%s%s", 2, alice.address, alice.address)))
+	rename := acceptTestPayload(t, vm, newTestRenamePayload(t, alice, "file000000000001", "/a.txt"))
+	start := time.Now().Add(time.Minute).Unix()
+	stake := acceptTestPayload(t, vm, newTestStakePayload(t, alice, 3, start, start+600))
+
+	expected := []struct {
+		historyType string
+		block       *Block
+		amount      uint64
+		credit      bool
+	}{
+		{"faucet", faucet, 20, true},
+		{"upload", upload, uint64(upload.getStorageFee()), false},
+		{"transferOut", transfer, 5, false},
+		{"transferIn", self, 2, true},
+		{"transferOut", self, 2, false},
+		{"fee", rename, uint64(rename.getStorageFee()), false},
+		{"stake", stake, 0, false},
+	}
+	// Every page size gives the same history
+	for _, limit := range []uint32{0, 1, 2, 4} {
+		entries := getTestAccountHistory(t, vm, alice.address, limit)
+		if len(entries) != len(expected) {
+			t.Fatalf("expected %d entries with a limit of %d but got %+v", len(expected), limit, entries)
+		}
+		for i, entry := range entries {
+			if entry.Type != expected[i].historyType || entry.BlockID != expected[i].block.ID().String() ||
+				uint64(entry.Amount) != expected[i].amount || entry.Credit != expected[i].credit {
+				t.Fatalf("expected entry %d to be %+v but got %+v", i, expected[i], entry)
+			}
+		}
+		if entries[6].Stake == nil || entries[6].Stake.Amount != 3 {
+			t.Fatalf("expected alice's stake but got %+v", entries[6])
+		}
+	}
+	if entries := getTestAccountHistory(t, vm, bob.address, 0); len(entries) != 1 || entries[0].Type != "transferIn" || entries[0].Amount != 5 {
+		t.Fatalf("expected bob's transfer from alice but got %+v", entries)
+	}
+
+	// Entries can be filtered by type
+	entries := getTestAccountHistory(t, vm, alice.address, 1, "transferIn", "faucet")
+	if len(entries) != 2 || entries[0].BlockID != faucet.ID().String() || entries[1].BlockID != self.ID().String() {
+		t.Fatalf("expected alice's faucet drip and transfer in but got %+v", entries)
+	}
+	for name, test := range map[string]struct {
+		args     *GetAccountHistoryArgs
+		expected error
+	}{
+		"bad address": {&GetAccountHistoryArgs{Address: "alice"}, errBadAddress},
+		"bad type":    {&GetAccountHistoryArgs{Address: alice.address, Types: []string{"reward"}}, errBadHistoryType},
+		"bad cursor":  {&GetAccountHistoryArgs{Address: alice.address, Cursor: "00"}, errBadHistoryCursor},
+	} {
+		if err := (&Service{vm}).GetAccountHistory(nil, test.args, &GetAccountHistoryReply{}); err != test.expected {
+			t.Fatalf("expected %s for %s but got %v", test.expected, name, err)
+		}
+	}
+
+	// Nodes that accepted the blocks before the index existed have the
+	// same history
+	iter := vm.accountHistoryDB.NewIterator()
+	for iter.Next() {
+		if err := vm.accountHistoryDB.Delete(iter.Key()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	iter.Release()
+	if err := vm.reindexAccountHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := getTestAccountHistory(t, vm, alice.address, 0); len(entries) != len(expected) {
+		t.Fatalf("expected %d entries after reindexing but got %+v", len(expected), entries)
+	}
+}
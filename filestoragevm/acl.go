@@ -0,0 +1,254 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+// Length of a file access change's content: the file ID, the account and
+// the role it's given
+const fileAccessLen = fileIDLen + addressLen + 1
+
+// fileRole is what an account can do to a file. Roles are ordered, so each
+// one can do everything the ones below it can.
+type fileRole byte
+
+const (
+	// No access. Setting an account's role to this revokes its access.
+	roleNone fileRole = '0'
+
+	// Can add chunks and manifests to the file, and renew it
+	roleWrite fileRole = '1'
+
+	// Can also delete the file, and change other accounts' access to it
+	roleAdmin fileRole = '2'
+
+	// The file's owner, who can also transfer it. This isn't in the access
+	// list, and can't be given in a file access change.
+	roleOwner fileRole = '3'
+)
+
+var (
+	fileAccessIndexPrefix = []byte("access")
+	sharedIndexPrefix     = []byte("shared")
+
+	errMalformedFileAccess = errors.New("file access change is malformed")
+	errNoFileAccess        = errors.New("signer doesn't have the access to the file this needs")
+	errSelfFileAccess      = errors.New("can't change your own access to a file")
+	errNotGranted          = errors.New("account has no access to the file to revoke")
+)
+
+// The owner of a file can give other accounts access to it. An account that
+// is given access names the file by the same file ID as the owner, so it
+// can't already have a file with that ID. Its transactions about the file
+// are checked against its role, and pay fees from its own balance.
+
+// A file access change sets an account's role on one of the signer's files,
+// or on a file the signer is an admin of.
+func (b *Block) isFileAccessBlock() bool {
+	return b.getBlockType() == "a"
+}
+
+func (b *Block) getFileAccessFileID() string {
+	return string(b.Data[contentOffset : contentOffset+fileIDLen])
+}
+
+func (b *Block) getFileAccessAccount() string {
+	return string(b.Data[contentOffset+fileIDLen : contentOffset+fileIDLen+addressLen])
+}
+
+func (b *Block) getFileAccessRole() fileRole {
+	return fileRole(b.Data[contentOffset+fileIDLen+addressLen])
+}
+
+// String returns the name of the role, as the API shows it
+func (r fileRole) String() string {
+	switch r {
+	case roleNone:
+		return "none"
+	case roleWrite:
+		return "write"
+	case roleAdmin:
+		return "admin"
+	case roleOwner:
+		return "owner"
+	}
+	return "unknown"
+}
+
+// fileAccessKey returns the file access index key of [account]'s role on
+// [creator]'s file [fileID]. Keys start with the file key, so a file's
+// access list can be iterated.
+func fileAccessKey(creator string, fileID string, account string) []byte {
+	return append(fileKey(creator, fileID), account...)
+}
+
+// getFileRole returns [account]'s role on [creator]'s file [fileID] as of
+// this block, or roleNone if it's not in the file's access list
+func (b *Block) getFileRole(account string, creator string, fileID string) (fileRole, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return roleNone, err
+	}
+	for _, block := range ancestry {
+		if !block.isFileAccessBlock() || block.getFileAccessAccount() != account {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return roleNone, err
+		} else if isOfFile {
+			return block.getFileAccessRole(), nil
+		}
+	}
+	return b.vm.getAcceptedFileRole(account, creator, fileID)
+}
+
+// getAcceptedFileRole returns [account]'s role on [creator]'s file
+// [fileID] as of the last accepted block, like getFileRole
+func (vm *VM) getAcceptedFileRole(account string, creator string, fileID string) (fileRole, error) {
+	role, err := vm.fileAccessDB.Get(fileAccessKey(creator, fileID, account))
+	if err == database.ErrNotFound {
+		return roleNone, nil
+	}
+	if err != nil {
+		return roleNone, err
+	}
+	return fileRole(role[0]), nil
+}
+
+// verifyFileRole returns nil iff the signer's role on the file the
+// transaction in this block is about, as of [parent], is at least [role].
+// Accounts not in the file's access list that can name it are its owner.
+func (b *Block) verifyFileRole(parent *Block, role fileRole) error {
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	signerRole, err := parent.getFileRole(b.getSigner(), creator, b.getFileID())
+	if err != nil {
+		return err
+	}
+	if signerRole == roleNone {
+		signerRole = roleOwner
+	}
+	if signerRole < role {
+		return errNoFileAccess
+	}
+	return nil
+}
+
+// verifyFileAccess returns nil iff the signer is an admin of the file this
+// block changes the access to, which hasn't expired or been deleted, and
+// the account whose access changes can take it
+func (b *Block) verifyFileAccess(parent *Block) error {
+	if b.getContentLength() != fileAccessLen {
+		return errMalformedFileAccess
+	}
+	role := b.getFileAccessRole()
+	if role < roleNone || role > roleAdmin {
+		return errMalformedFileAccess
+	}
+	fileID, account := b.getFileAccessFileID(), b.getFileAccessAccount()
+	if account == b.getSigner() {
+		return errSelfFileAccess
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	hasFile, err := parent.hasFile(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if !hasFile {
+		return errNoSuchFile
+	}
+	if err := b.verifyFileRole(parent, roleAdmin); err != nil {
+		return err
+	}
+	if err := b.verifyNotExpired(parent, creator, fileID); err != nil {
+		return err
+	}
+	if err := b.verifyNotDeleted(parent, creator, fileID); err != nil {
+		return err
+	}
+
+	// Accounts with access can have their role changed or revoked.
+	// Otherwise, the account can't have used the file ID.
+	accountRole, err := parent.getFileRole(account, creator, fileID)
+	if err != nil {
+		return err
+	}
+	if accountRole != roleNone {
+		return nil
+	}
+	if role == roleNone {
+		return errNotGranted
+	}
+	accountCreator, err := parent.getFileCreatorOf(account, fileID)
+	if err == errFileTransferred {
+		return errFileExists
+	}
+	if err != nil {
+		return err
+	}
+	if accountCreator != account {
+		return errFileExists
+	}
+	accountHasFile, err := parent.hasFile(account, fileID)
+	if err != nil {
+		return err
+	}
+	if accountHasFile {
+		return errFileExists
+	}
+	return nil
+}
+
+// indexFileAccess updates the file access index with the accepted block [b]
+func (vm *VM) indexFileAccess(b *Block) error {
+	if !b.isFileAccessBlock() {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID, account := b.getFileAccessFileID(), b.getFileAccessAccount()
+	key := fileAccessKey(creator, fileID, account)
+	if role := b.getFileAccessRole(); role == roleNone {
+		if err := vm.fileAccessDB.Delete(key); err != nil {
+			return err
+		}
+		return vm.sharedDB.Delete(fileKey(account, fileID))
+	} else if err := vm.fileAccessDB.Put(key, []byte{byte(role)}); err != nil {
+		return err
+	}
+	return vm.sharedDB.Put(fileKey(account, fileID), []byte(creator))
+}
+
+// fileAccess is an entry in a file's access list
+type fileAccess struct {
+	account string
+	role    fileRole
+}
+
+// getFileAccessList returns the access list of [creator]'s file [fileID]
+// as of the last accepted block, ordered by account
+func (vm *VM) getFileAccessList(creator string, fileID string) ([]fileAccess, error) {
+	iter := vm.fileAccessDB.NewIteratorWithPrefix(fileKey(creator, fileID))
+	defer iter.Release()
+
+	list := []fileAccess{}
+	for iter.Next() {
+		list = append(list, fileAccess{
+			account: string(iter.Key()[addressLen+fileIDLen:]),
+			role:    fileRole(iter.Value()[0]),
+		})
+	}
+	return list, iter.Error()
+}
@@ -0,0 +1,94 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+// newTestFileAccessPayload returns a signed change of [account]'s role on
+// [fileID] to [role]
+func newTestFileAccessPayload(t *testing.T, key *testKey, fileID string, account string, role fileRole) [dataLen]byte {
+	return newTestPayload(t, key, 'a', fileID+account+string(role))
+}
+
+func TestFileAccess(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	for _, key := range []*testKey{alice, bob, carol} {
+		acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	}
+	hello := acceptTestPayload(t, vm, newTestUploadPayload(t, alice, fileID, 0, "hello"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, carol, fileID, 0, "carol"))
+
+	for name, test := range map[string]struct {
+		data     [dataLen]byte
+		expected error
+	}{
+		"self":          {newTestFileAccessPayload(t, alice, fileID, alice.address, roleWrite), errSelfFileAccess},
+		"owner role":    {newTestFileAccessPayload(t, alice, fileID, bob.address, roleOwner), errMalformedFileAccess},
+		"file ID taken": {newTestFileAccessPayload(t, alice, fileID, carol.address, roleWrite), errFileExists},
+		"not granted":   {newTestFileAccessPayload(t, alice, fileID, bob.address, roleNone), errNotGranted},
+	} {
+		if err := newTestBlockAt(t, vm, test.data, time.Now()).Verify(); err != test.expected {
+			t.Fatalf("expected %s for %s but got %v", test.expected, name, err)
+		}
+	}
+
+	acceptTestPayload(t, vm, newTestFileAccessPayload(t, alice, fileID, bob.address, roleWrite))
+	world := acceptTestPayload(t, vm, newTestUploadPayload(t, bob, fileID, 1, "world"))
+	if creator, err := world.getFileCreator(); err != nil || creator != alice.address {
+		t.Fatalf("expected creator %s but got %s (%v)", alice.address, creator, err)
+	}
+	acceptTestPayload(t, vm, newTestManifestPayload(t, bob, fileID, "hello.txt", "text/plain", "helloworld", []ids.ID{hello.ID(), world.ID()}))
+
+	// Writers can't delete the file or change who has access to it
+	for name, data := range map[string][dataLen]byte{
+		"deletion":      newTestDeletePayload(t, bob, fileID, 0),
+		"access change": newTestFileAccessPayload(t, bob, fileID, carol.address, roleWrite),
+	} {
+		if err := newTestBlockAt(t, vm, data, time.Now()).Verify(); err != errNoFileAccess {
+			t.Fatalf("expected %s for the %s but got %v", errNoFileAccess, name, err)
+		}
+	}
+
+	// Admins can, but only owners can transfer it
+	acceptTestPayload(t, vm, newTestFileAccessPayload(t, alice, fileID, bob.address, roleAdmin))
+	if err := newTestBlockAt(t, vm, newTestFileTransferPayload(t, bob, fileID, carol.address), time.Now()).Verify(); err != errNoFileAccess {
+		t.Fatalf("expected %s but got %v", errNoFileAccess, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestDeletePayload(t, bob, fileID, 0), time.Now()).Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := Service{vm}
+	accessReply := &GetFileAccessReply{}
+	if err := service.GetFileAccess(nil, &GetFileAccessArgs{Owner: alice.address, FileID: fileID}, accessReply); err != nil {
+		t.Fatal(err)
+	}
+	if len(accessReply.Access) != 1 || accessReply.Access[0].Account != bob.address || accessReply.Access[0].Role != "admin" {
+		t.Fatalf("unexpected access list %+v", accessReply.Access)
+	}
+	fileReply := &GetFileReply{}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: bob.address, FileID: fileID, IncludeContent: true}, fileReply); err != nil {
+		t.Fatal(err)
+	}
+	if fileReply.File.Creator != alice.address || fileReply.File.Role != "admin" {
+		t.Fatalf("unexpected file %+v", fileReply.File)
+	}
+
+	// Once access is revoked, the file ID is free for the account's own file
+	acceptTestPayload(t, vm, newTestFileAccessPayload(t, alice, fileID, bob.address, roleNone))
+	own := acceptTestPayload(t, vm, newTestUploadPayload(t, bob, fileID, 0, "bob"))
+	if creator, err := own.getFileCreator(); err != nil || creator != bob.address {
+		t.Fatalf("expected creator %s but got %s (%v)", bob.address, creator, err)
+	}
+}
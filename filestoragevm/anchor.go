@@ -0,0 +1,85 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+// Length of an anchor's content: the hex encoded hash it records
+const anchorLen = chunkHashLen
+
+var (
+	anchorIndexPrefix = []byte("anchor")
+
+	errMalformedAnchor = errors.New("anchor is malformed")
+	errNoSuchAnchor    = errors.New("hash hasn't been anchored")
+)
+
+// An anchor records a 32 byte hash on the chain, proving that whatever
+// hashes to it existed at the block's time without storing it. The hash
+// can be the Merkle root of a batch of hashes, so one anchor covers many
+// documents. Anyone can anchor a hash again, but the first anchor is the
+// one that proves when it existed.
+func (b *Block) isAnchorBlock() bool {
+	return b.getBlockType() == "d"
+}
+
+// returns the hex encoded hash an anchor records
+func (b *Block) getAnchorHash() string {
+	return string(b.Data[contentOffset : contentOffset+anchorLen])
+}
+
+// verifyAnchor returns nil iff this anchor records a well formed hash
+func (b *Block) verifyAnchor() error {
+	if b.getContentLength() != anchorLen {
+		return errMalformedAnchor
+	}
+	if _, err := parseChunkHash(b.getAnchorHash()); err != nil {
+		return errMalformedAnchor
+	}
+	return nil
+}
+
+// indexAnchor updates the anchor index with the accepted block [b], unless
+// its hash was anchored before
+func (vm *VM) indexAnchor(b *Block) error {
+	if !b.isAnchorBlock() {
+		return nil
+	}
+	hash, err := parseChunkHash(b.getAnchorHash())
+	if err != nil {
+		return err
+	}
+	if anchored, err := vm.anchorDB.Has(hash[:]); err != nil || anchored {
+		return err
+	}
+	return database.PutID(vm.anchorDB, hash[:], b.ID())
+}
+
+// getAnchor returns the first accepted anchor of [hash]
+func (vm *VM) getAnchor(hash [32]byte) (*Block, error) {
+	anchorID, err := database.GetID(vm.anchorDB, hash[:])
+	if err == database.ErrNotFound {
+		return nil, errNoSuchAnchor
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vm.getBlock(anchorID)
+}
+
+// getBatchAnchor returns the first accepted anchor of the Merkle root of a
+// batch of [count] hashes that [proof] shows has [hash] as hash [index],
+// and the root
+func (vm *VM) getBatchAnchor(hash [32]byte, index int, count int, proof [][32]byte) (*Block, [32]byte, error) {
+	root, ok := merkleProofRoot(hash, index, count, proof)
+	if !ok {
+		return nil, root, errNoSuchAnchor
+	}
+	anchor, err := vm.getAnchor(root)
+	return anchor, root, err
+}
@@ -0,0 +1,74 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestAnchorPayload returns a signed anchor of [hash]
+func newTestAnchorPayload(t *testing.T, key *testKey, hash [32]byte) [dataLen]byte {
+	return newTestPayload(t, key, 'd', fmt.Sprintf("%x", hash))
+}
+
+func TestAnchors(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	for _, key := range []*testKey{alice, bob} {
+		acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	}
+	if err := newTestBlockAt(t, vm, newTestPayload(t, alice, 'd', "not a hash"), time.Now()).Verify(); err != errMalformedAnchor {
+		t.Fatalf("expected %s but got %v", errMalformedAnchor, err)
+	}
+
+	document := sha256.Sum256([]byte("contract"))
+	anchor := acceptTestPayload(t, vm, newTestAnchorPayload(t, alice, document))
+	if balance := getTestBalance(t, anchor, alice.address); balance != 10-anchor.getStorageFee() {
+		t.Fatalf("expected the anchor's fee to be paid but the balance is %d", balance)
+	}
+
+	// Anchoring it again doesn't change when it was first anchored
+	acceptTestPayload(t, vm, newTestAnchorPayload(t, bob, document))
+	service := Service{vm}
+	reply := &VerifyAnchorReply{}
+	if err := service.VerifyAnchor(nil, &VerifyAnchorArgs{Hash: hex.EncodeToString(document[:])}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.BlockID != anchor.ID().String() || reply.Signer != alice.address || uint64(reply.Height) != anchor.Height() {
+		t.Fatalf("unexpected anchor %+v", reply)
+	}
+
+	// A batch is anchored by its root, and each hash in it by its proof
+	batch := make([][32]byte, 5)
+	for i := range batch {
+		batch[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	root := merkleRoot(batch)
+	batchAnchor := acceptTestPayload(t, vm, newTestAnchorPayload(t, bob, root))
+	args := &VerifyAnchorArgs{Hash: hex.EncodeToString(batch[3][:]), Index: 3, Count: 5}
+	for _, hash := range merkleProof(batch, 3) {
+		args.Proof = append(args.Proof, hex.EncodeToString(hash[:]))
+	}
+	if err := service.VerifyAnchor(nil, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.BlockID != batchAnchor.ID().String() || reply.AnchoredHash != hex.EncodeToString(root[:]) {
+		t.Fatalf("unexpected batch anchor %+v", reply)
+	}
+	args.Index = 2
+	if err := service.VerifyAnchor(nil, args, reply); err != errNoSuchAnchor {
+		t.Fatalf("expected %s for the wrong index but got %v", errNoSuchAnchor, err)
+	}
+	other := sha256.Sum256([]byte("other"))
+	if err := service.VerifyAnchor(nil, &VerifyAnchorArgs{Hash: hex.EncodeToString(other[:])}, reply); err != errNoSuchAnchor {
+		t.Fatalf("expected %s but got %v", errNoSuchAnchor, err)
+	}
+}
@@ -10,9 +10,10 @@ import (
 
 	"github.com/ava-labs/avalanchego/indexer"
 	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
 	"github.com/ava-labs/avalanchego/snow/consensus/snowman"
-	"github.com/ava-labs/avalanchego/utils/crypto"
 	"github.com/ava-labs/avalanchego/utils/formatting"
+	"github.com/ava-labs/avalanchego/utils/hashing"
 	"github.com/ava-labs/avalanchego/utils/json"
 	"github.com/ava-labs/avalanchego/utils/rpc"
 	"github.com/ava-labs/avalanchego/vms/components/core"
@@ -29,6 +30,7 @@ var (
 	errFaucetEmpty       = errors.New("faucet is out of funds sorry bud")
 	errInsufficientBalance = errors.New("insufficient balance for transfer")
 	errStakingPeriodInvalid = errors.New("staking period must start at least 30 seconds from now and last at least 1 minute")
+	errDuplicateChunk       = errors.New("chunk was already uploaded")
 
 	_ snowman.Block = &Block{}
 )
@@ -40,6 +42,57 @@ var (
 type Block struct {
 	*core.Block `serialize:"true"`
 	Data        [dataLen]byte `serialize:"true"`
+
+	// The fee every upload, chunk reference and manifest in this block
+	// pays, on top of the fee per byte. It's set by the parent block.
+	BaseFee uint64 `serialize:"true"`
+
+	// Merkle root of the state as of the block. See state.go.
+	StateRoot [32]byte `serialize:"true"`
+
+	// Merkle root of the block's transactions. See txroot.go.
+	TxRoot [32]byte `serialize:"true"`
+
+	// The VM this block belongs to. core.Block only knows about the
+	// embedded SnowmanVM, which doesn't have our indexes.
+	vm *VM
+
+	// True if this block is from before base fees were in the header
+	legacy bool
+
+	// True if this block is from before tx roots were in the header, in
+	// which case its ID is the hash of its bytes. Legacy blocks are too.
+	rootless bool
+
+	// The block's bytes, if it has a tx root. See Bytes.
+	bytes []byte
+
+	// True if this block is from before state roots were in the header.
+	// Rootless blocks are too.
+	stateless bool
+
+	// The state leaves this block changes, once they've been worked out.
+	// See getStateChanges.
+	stateChanges map[string][]byte
+
+	// True if this is an upload whose data was pruned, in which case its
+	// ID is [prunedID] rather than the hash of its bytes
+	pruned   bool
+	prunedID ids.ID
+
+	// Creator of the file this block's transaction is about, once it's
+	// been worked out. See getFileCreator.
+	fileCreator string
+
+	// The check of the block's signature, if it was queued when the block
+	// was parsed. See signatures.go.
+	signature *signatureCheck
+}
+
+// legacyBlock is how blocks were serialized before they had a base fee
+type legacyBlock struct {
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
 }
 
 func (b *Block) getBlockType() string {
@@ -47,8 +100,47 @@ func (b *Block) getBlockType() string {
 	return string(blockTypeBytes)
 }
 
+// getTxType returns the name of the type of the transaction in this block,
+// as the API and metrics call it
+func (b *Block) getTxType() string {
+	switch {
+	case b.isSessionUploadBlock():
+		return "sessionUpload"
+	case b.isReferenceBlock():
+		return "chunkReference"
+	case b.isRetentionUploadBlock():
+		return "retentionUpload"
+	case b.isUploadBlock():
+		return "upload"
+	case b.isManifestBlock():
+		return "manifest"
+	case b.isRenewBlock():
+		return "renew"
+	case b.isDeleteBlock():
+		return "delete"
+	case b.isFileTransferBlock():
+		return "fileTransfer"
+	case b.isFileAccessBlock():
+		return "fileAccess"
+	case b.isRenameBlock():
+		return "rename"
+	case b.isAnchorBlock():
+		return "anchor"
+	case b.isTransferBlock():
+		return "transfer"
+	case b.isStakeBlock():
+		return "stake"
+	case b.isFaucetBlock():
+		return "faucet"
+	}
+	return "unknown"
+}
+
+// session uploads, chunk references and uploads with retention are uploads
+// too. Session uploads are just authorized differently, references point
+// at their data instead of carrying it, and uploads with retention pay rent.
 func (b *Block) isUploadBlock() bool {
-	return b.getBlockType() == "0"
+	return b.getBlockType() == "0" || b.isSessionUploadBlock() || b.isReferenceBlock() || b.isRetentionUploadBlock()
 }
 
 func (b *Block) isFaucetBlock() bool {
@@ -118,9 +210,13 @@ func (b *Block) convertBytesToInt(bytes []byte) int64 {
 }
 
 
+// pChainURI is where the validator lookups for staking find the P-chain's
+// API
+const pChainURI = "http://localhost:9658"
+
 func wasNodeValidatingAtTime(nodeID string, timestamp int64) bool {
 	// get node host? it must be possible
-	uri := "http://localhost:9658"
+	uri := pChainURI
 	// get subnet ID, uhoh another thing!!! FK
 	subnetID, _ := ids.FromString("2PYeJUhPiTrhe6Yq73okTzayR15U5WUD2R2idfsCLfqahEi5uo")
 	timeout := 10 * time.Second
@@ -165,8 +261,10 @@ func wasNodeValidatingAtTime(nodeID string, timestamp int64) bool {
 	return wasValidating
 }
 
-func (b *Block) getStakeReward() uint64 {
-	if time.Now().Unix() <= b.getStakeEnd() {
+// getStakeReward returns the reward this stake pays out at unix time [at]:
+// nothing until it ends, then the reward its node earned
+func (b *Block) getStakeReward(at int64) uint64 {
+	if at <= b.getStakeEnd() {
 		return 0
 	}
 
@@ -184,12 +282,18 @@ func (b *Block) getStakeReward() uint64 {
 	// node was really online and securing hte network
 
 	// we should also consider the validators stake, b.getStakeAmount(), in this equation
+	return b.getMaxStakeReward()
+}
+
+// getMaxStakeReward returns the reward this stake earns if its node
+// validates throughout it
+func (b *Block) getMaxStakeReward() uint64 {
 	return uint64(b.getRewardPerSecond() * uint64(b.getStakeEnd() - b.getStakeStart()))
 }
 
-func (b *Block) getLockedStake() uint64 {
-	currTime := time.Now().Unix()
-	if currTime >= b.Timestamp().Unix() && currTime <= b.getStakeEnd() {
+// getLockedStake returns how much of this stake is locked at unix time [at]
+func (b *Block) getLockedStake(at int64) uint64 {
+	if at >= b.Timestamp().Unix() && at <= b.getStakeEnd() {
 		return uint64(b.getStakeAmount())
 	}
 	return 0
@@ -199,28 +303,117 @@ func (b *Block) getLockedStake() uint64 {
 // returns the unallocated balance from the original funds on the blockchain
 // these get allocated via faucet or by validators earning rewards
 func (b *Block) getUnallocatedBalance() int64 {
+	return b.getUnallocatedBalanceAt(b.Timestamp().Unix())
+}
+
+// getUnallocatedBalanceAt returns the unallocated balance as of this block,
+// with the staking rewards paid out by unix time [at]
+func (b *Block) getUnallocatedBalanceAt(at int64) int64 {
 	var balance int64
 	if b.Parent().String() == "11111111111111111111111111111111LpoYY" {
-		balance = 5000000000000000
+		balance = genesisFunds
 	} else {
-		parentBlock, _ := b.VM.GetBlock(b.Parent())
+		parentBlock, _ := b.vm.GetBlock(b.Parent())
 		parent, _ := parentBlock.(*Block)
-		balance = parent.getUnallocatedBalance()
+		balance = parent.getUnallocatedBalanceAt(at)
 	}
-	if b.isFaucetBlock() {
-		balance -= b.getFaucetAmount()
-	} else if b.isUploadBlock() {
-		// upload fees get paid back to the unallocated account
-		balance += b.getCostPerUploadBlock()
-	} else if b.isStakeBlock() {
-		balance -= int64(b.getStakeReward())
+	balance += b.getUnallocatedChange()
+	if b.isStakeBlock() {
+		balance -= int64(b.getStakeReward(at))
 	}
 	return balance
 }
 
+// getUnallocatedChange returns how much the transaction in this block
+// changes the unallocated balance, not counting staking rewards
+func (b *Block) getUnallocatedChange() int64 {
+	if b.isFaucetBlock() {
+		return -b.getFaucetAmount()
+	} else if b.isUploadBlock() || b.isManifestBlock() {
+		// upload fees get paid back to the unallocated account, along with
+		// the rent of uploads with retention
+		return b.getStorageFee() + b.getTxRent()
+	} else if b.isRenewBlock() {
+		// and so do renewals, along with the rent
+		return b.getStorageFee() + b.getTxRent()
+	} else if b.isDeleteBlock() {
+		// deletions pay a fee, but can get some rent back
+		return b.getStorageFee() - b.getDeleteRefund()
+	} else if b.isFileTransferBlock() || b.isFileAccessBlock() || b.isRenameBlock() || b.isAnchorBlock() {
+		return b.getStorageFee()
+	}
+	return 0
+}
+
 func (b *Block) getUploadSender() string {
-	pubkeyBytes := b.Data[0:50]
-	return string(pubkeyBytes)
+	return b.getSigner()
+}
+
+func (b *Block) getUploadFileID() string {
+	return string(b.Data[158 : 158+16])
+}
+
+func (b *Block) getUploadChunkNumber() int64 {
+	chunkNumberBytes := b.Data[158+16 : 158+16+8]
+	if b.isSessionUploadBlock() {
+		chunkNumberBytes = b.Data[158+sessionLen : 158+sessionLen+8]
+	}
+	return b.convertBytesToInt(chunkNumberBytes)
+}
+
+// returns the public key (CB58 encoded) that signed this block
+func (b *Block) getSigner() string {
+	return string(b.Data[0:50])
+}
+
+// returns the length of the message content, which excludes the
+// null bytes padding the end of the block
+func (b *Block) getContentLength() int64 {
+	return computeContentLength(b.Data)
+}
+
+// computeContentLength returns the content length of the transaction
+// whose data is [data]
+func computeContentLength(data [dataLen]byte) int64 {
+	length, _ := strconv.ParseUint(string(data[154:158]), 10, 64)
+	return int64(length)
+}
+
+// returns the ID of the transaction in this block, which is the hash of
+// the block's data. Unlike the block ID, it's known before the block is built.
+func (b *Block) txID() ids.ID {
+	return computeTxID(b.Data)
+}
+
+// computeTxID returns the ID of the transaction whose data is [data]
+func computeTxID(data [dataLen]byte) ids.ID {
+	return hashing.ComputeHash256Array(data[:])
+}
+
+// returns the bytes the signer signed. That's the message (bytes
+// 153:<end>), except for session uploads where it's the session.
+func (b *Block) getSignedMessage() []byte {
+	if b.isSessionUploadBlock() {
+		return b.getSessionMessage()
+	}
+	return b.Data[153:]
+}
+
+// hasValidSignature returns true iff the signed message was signed by the
+// public key in bytes 0:50
+func (b *Block) hasValidSignature() bool {
+	if b.signature != nil {
+		<-b.signature.done
+		return b.signature.valid
+	}
+	return b.vm.signatures.verify(newSignatureCheck(b))
+}
+
+// verifySignature returns true iff [sig] (CB58 encoded) is a signature of
+// [message] by [address] (a CB58 encoded public key). It checks signatures
+// the same way as blocks' are checked, see signatures.go.
+func verifySignature(address string, message []byte, sig string) bool {
+	return isSigner(recoverSigner(message, sig), address)
 }
 
 func (b *Block) getRewardPerSecond() uint64 {
@@ -228,46 +421,127 @@ func (b *Block) getRewardPerSecond() uint64 {
 	return 1
 }
 
-func (b *Block) getCostPerUploadBlock() int64 {
-	return 1 // this is just fixed for demo purposes
+// getBalance returns [account]'s balance as of this block. Its stakes are
+// counted as they stand at this block's timestamp.
+func (b *Block) getBalance(account string) (int64, error) {
+	entry, err := b.getBalanceEntry(account)
+	if err != nil {
+		return 0, err
+	}
+	at := b.Timestamp().Unix()
+	balance := entry.ledger
+	for _, stake := range entry.stakes {
+		// distribution of staking rewards
+		balance += int64(stake.getStakeReward(at)) // should be 0 if staking
+		balance -= int64(stake.getLockedStake(at)) // should be stake amount if staking, 0 otherwise
+	}
+	return balance, nil
 }
 
-func (b *Block) getBalance(account string) int64 {
-	var balance int64
-	if b.Parent().String() == "11111111111111111111111111111111LpoYY" {
-		balance = 0
-	} else {
-		parentBlock, _ := b.VM.GetBlock(b.Parent())
-		parent, _ := parentBlock.(*Block)
-		balance = parent.getBalance(account)
+// verifyBalance returns nil iff [account] has at least [amount] as of this
+// block
+func (b *Block) verifyBalance(account string, amount int64) error {
+	balance, err := b.getBalance(account)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return errInsufficientBalance
 	}
+	return nil
+}
+
+// getLedgerChange returns how much the transaction in this block changes
+// [account]'s balance, not counting stakes. A stake's lock and reward
+// depend on the timestamp of the block the balance is looked at as of.
+func (b *Block) getLedgerChange(account string) int64 {
 	if b.isFaucetBlock() && b.getFaucetRecipient() == account {
 		// faucet distributions
-		balance += b.getFaucetAmount()
+		return b.getFaucetAmount()
 	} else if b.isTransferBlock() {
 		// transfers between wallets
+		var change int64
 		if b.getTransferSender() == account {
-			balance -= b.getTransferAmount()
+			change -= b.getTransferAmount()
 		}
 		if b.getTransferRecipient() == account {
-			balance += b.getTransferAmount()
+			change += b.getTransferAmount()
 		}
-	} else if b.isUploadBlock() && b.getUploadSender() == account {
-		// actual file uploads
-		balance -= b.getCostPerUploadBlock()
-	} else if b.isStakeBlock() && b.getStakeRewardAddress() == account {
-		// distribution of staking rewards
-		balance += int64(b.getStakeReward()) // should be 0 if staking
-		balance -= int64(b.getLockedStake()) // should be stake amount if staking, 0 otherwise
+		return change
+	} else if (b.isUploadBlock() || b.isManifestBlock()) && b.getSigner() == account {
+		// actual file uploads, and the manifests describing them. Uploads
+		// with retention pay rent too.
+		return -(b.getStorageFee() + b.getTxRent())
+	} else if b.isRenewBlock() && b.getSigner() == account {
+		// renewals pay rent for keeping a file longer
+		return -(b.getStorageFee() + b.getTxRent())
+	} else if b.isDeleteBlock() && b.getSigner() == account {
+		// deletions refund some of the rent that was paid
+		return b.getDeleteRefund() - b.getStorageFee()
+	} else if (b.isFileTransferBlock() || b.isFileAccessBlock() || b.isRenameBlock() || b.isAnchorBlock()) && b.getSigner() == account {
+		// giving a file away, or access to it, moving it, or anchoring a
+		// hash, costs a fee
+		return -b.getStorageFee()
+	}
+	return 0
+}
 
+// getLedgerAccounts returns the accounts whose balances the transaction in
+// this block can change, not counting stakes
+func (b *Block) getLedgerAccounts() []string {
+	if b.isFaucetBlock() {
+		return []string{b.getFaucetRecipient()}
+	} else if b.isTransferBlock() {
+		return []string{b.getTransferSender(), b.getTransferRecipient()}
 	}
-	return balance
+	return []string{b.getSigner()}
+}
+
+// getParent returns this block's parent
+func (b *Block) getParent() (*Block, error) {
+	return b.vm.getBlock(b.Parent())
+}
+
+// hasAncestor returns true iff [other] is a strict ancestor of this block.
+// Once the walk back reaches an accepted block, the rest of the chain is
+// looked up in the height index instead of walking it.
+func (b *Block) hasAncestor(other *Block) bool {
+	ancestor := b
+	for ancestor.Height() > other.Height() {
+		if ancestor.Status() == choices.Accepted {
+			id, err := b.vm.getBlockIDAtHeight(other.Height())
+			return err == nil && id == other.ID()
+		}
+		parent, err := ancestor.getParent()
+		if err != nil {
+			return false
+		}
+		ancestor = parent
+	}
+	return ancestor != b && ancestor.ID() == other.ID()
+}
+
+// unacceptedAncestry returns this block and its ancestors, newest first,
+// back to but not including the first accepted one. The VM's indexes are
+// as of that accepted block, so state as of this block is the indexes plus
+// the changes made by these blocks.
+func (b *Block) unacceptedAncestry() ([]*Block, error) {
+	ancestry := []*Block{}
+	for block := b; block.Status() != choices.Accepted; {
+		ancestry = append(ancestry, block)
+		parent, err := block.getParent()
+		if err != nil {
+			return nil, err
+		}
+		block = parent
+	}
+	return ancestry, nil
 }
 
 // Verify returns nil iff this block is valid.
 // To be valid, it must be that:
 // b.parent.Timestamp < b.Timestamp <= [local time] + 1 hour
-func (b *Block) Verify() error {
+func (b *Block) Verify() (err error) {
 	// Check to see if this block has already been verified by calling Verify on the
 	// embedded *core.Block.
 	// If there is an error while checking, return an error.
@@ -275,10 +549,12 @@ func (b *Block) Verify() error {
 	if accepted, err := b.Block.Verify(); err != nil || accepted {
 		return err
 	}
+	start := time.Now()
+	defer func() { b.vm.metrics.observeVerify(time.Since(start), err) }()
 
 	// Get [b]'s parent
 	parentID := b.Parent()
-	parentIntf, err := b.VM.GetBlock(parentID)
+	parentIntf, err := b.vm.GetBlock(parentID)
 	if err != nil {
 		return errDatabaseGet
 	}
@@ -299,27 +575,135 @@ func (b *Block) Verify() error {
 	}
 
 	// check signatures on the block
-	factory := crypto.FactorySECP256K1R{}
-	pubkeyBytes := b.Data[0:50]
-	pubkeyDecoded, _ := formatting.Decode(formatting.CB58, string(pubkeyBytes))
-	pubkey, _ := factory.ToPublicKey(pubkeyDecoded)
-	sigLenBytes := b.Data[50:53]
-	sigLenStr := string(sigLenBytes)
-        sigLenNum, _ := strconv.ParseUint(sigLenStr, 10, 32)
-        sigLen := int(sigLenNum)
-	sigBytes := b.Data[53:53 + sigLen]
-	sigStr := string(sigBytes)
-	sigDecoded, _ := formatting.Decode(formatting.CB58, sigStr)
-	dataBytes := b.Data[153:]
-	messageBytes := dataBytes
-	if pubkey.Verify(messageBytes, sigDecoded) == false {
+	if !b.hasValidSignature() {
 		return errInvalidSignature
 	}
 
+	if err := b.verifyContentLength(); err != nil {
+		return err
+	}
+	if err := b.verifyBaseFee(parent); err != nil {
+		return err
+	}
+	if err := b.verifyTxRoot(parent); err != nil {
+		return err
+	}
+
 	// validate different types of blocks
 	if b.isUploadBlock() {
-		if parent.getBalance(b.getUploadSender()) < b.getCostPerUploadBlock() {
-			return errInsufficientBalance
+		if err := parent.verifyBalance(b.getUploadSender(), b.getStorageFee()+b.getTxRent()); err != nil {
+			return err
+		}
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		if err := b.verifyNewChunk(parent, creator); err != nil {
+			return err
+		}
+		if err := b.verifyUploadRent(parent, creator); err != nil {
+			return err
+		}
+		if err := b.verifyFileRole(parent, roleWrite); err != nil {
+			return err
+		}
+		if err := b.verifyNotExpired(parent, creator, b.getUploadFileID()); err != nil {
+			return err
+		}
+		if err := b.verifyNotDeleted(parent, creator, b.getUploadFileID()); err != nil {
+			return err
+		}
+		if b.isSessionUploadBlock() {
+			if err := b.verifySessionUpload(); err != nil {
+				return err
+			}
+		} else if b.isReferenceBlock() {
+			if err := b.verifyReference(parent); err != nil {
+				return err
+			}
+		}
+	} else if b.isManifestBlock() {
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
+		}
+		if b.isVersionBlock() {
+			if err := b.verifyVersion(parent); err != nil {
+				return err
+			}
+		} else if err := b.verifyFirstManifest(parent); err != nil {
+			return err
+		}
+		if err := b.verifyManifest(); err != nil {
+			return err
+		}
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		if err := b.verifyFileRole(parent, roleWrite); err != nil {
+			return err
+		}
+		if err := b.verifyNotExpired(parent, creator, b.getManifestFileID()); err != nil {
+			return err
+		}
+		if err := b.verifyNotDeleted(parent, creator, b.getManifestFileID()); err != nil {
+			return err
+		}
+		if err := b.verifySetPath(parent); err != nil {
+			return err
+		}
+	} else if b.isRenewBlock() {
+		if err := b.verifyRenew(parent); err != nil {
+			return err
+		}
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		if err := b.verifyNotDeleted(parent, creator, b.getRenewFileID()); err != nil {
+			return err
+		}
+		if err := b.verifyFileRole(parent, roleWrite); err != nil {
+			return err
+		}
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()+b.getTxRent()); err != nil {
+			return err
+		}
+	} else if b.isDeleteBlock() {
+		if err := b.verifyDelete(parent); err != nil {
+			return err
+		}
+		// The fee is paid before the refund
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
+		}
+	} else if b.isFileTransferBlock() {
+		if err := b.verifyFileTransfer(parent); err != nil {
+			return err
+		}
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
+		}
+	} else if b.isFileAccessBlock() {
+		if err := b.verifyFileAccess(parent); err != nil {
+			return err
+		}
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
+		}
+	} else if b.isRenameBlock() {
+		if err := b.verifyRename(parent); err != nil {
+			return err
+		}
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
+		}
+	} else if b.isAnchorBlock() {
+		if err := b.verifyAnchor(); err != nil {
+			return err
+		}
+		if err := parent.verifyBalance(b.getSigner(), b.getStorageFee()); err != nil {
+			return err
 		}
 	} else if b.isFaucetBlock() {
 		// faucet, only error is if faucet is empty
@@ -327,19 +711,25 @@ func (b *Block) Verify() error {
 			return errFaucetEmpty
 		}
 	} else if b.isTransferBlock() {
-		if b.getTransferAmount() > parent.getBalance(b.getTransferSender()) {
-			return errInsufficientBalance
+		if err := parent.verifyBalance(b.getTransferSender(), b.getTransferAmount()); err != nil {
+			return err
 		}
 	} else if b.isStakeBlock() {
 		if b.getStakeStart() < time.Now().Add(10 * time.Second).Unix() {
 			return errStakingPeriodInvalid
 		} else if b.getStakeEnd() - b.getStakeStart() < 10 {
 			return errStakingPeriodInvalid
-		} else if b.getStakeAmount() > parent.getBalance(b.getStakeRewardAddress()) {
-			return errInsufficientBalance
+		} else if err := parent.verifyBalance(b.getStakeRewardAddress(), b.getStakeAmount()); err != nil {
+			return err
 		}
 	}
 
+	// The state root is checked last, since the state as of an invalid
+	// block may not be worked out
+	if err := b.verifyStateRoot(parent); err != nil {
+		return err
+	}
+
 	// Our block inherits VM from *core.Block.
 	// It holds the database we read/write, b.VM.DB
 	// We persist this block to that database using VM's SaveBlock method.
@@ -350,3 +740,28 @@ func (b *Block) Verify() error {
 	// Then we flush the database's contents
 	return b.VM.DB.Commit()
 }
+
+// Accept sets this block's status to Accepted and records it in the
+// VM's indexes, then flushes the database.
+func (b *Block) Accept() error {
+	if err := b.Block.Accept(); err != nil {
+		return err
+	}
+	if err := b.vm.indexAccepted(b); err != nil {
+		return err
+	}
+	if err := b.VM.DB.Commit(); err != nil {
+		return err
+	}
+	b.vm.metrics.observeAccept(b)
+	return nil
+}
+
+// Reject sets this block's status to Rejected
+func (b *Block) Reject() error {
+	if err := b.Block.Reject(); err != nil {
+		return err
+	}
+	b.vm.metrics.blocksRejected.Inc()
+	return nil
+}
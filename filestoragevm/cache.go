@@ -0,0 +1,165 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ava-labs/avalanchego/cache"
+	"github.com/ava-labs/avalanchego/cache/metercacher"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
+)
+
+const (
+	// Default sizes of the caches, in entries, unless the config says
+	// otherwise
+	defaultBlockCacheSize     = 2048
+	defaultStateDiffCacheSize = 1024
+	defaultBalanceCacheSize   = 8192
+
+	// A balance lookup caches the entries as of the blocks it walks back
+	// through whose height is a multiple of this, so the next cold lookup
+	// doesn't walk further back than the last of them
+	balanceCheckpointInterval = 64
+)
+
+// Blocks are stored one by one, and a balance is worked out by walking back
+// to genesis, so the same blocks get parsed over and over. The caches keep:
+//   - parsed blocks that have been decided, by ID. Their status can't
+//     change, so every lookup can share them. Blocks being processed aren't
+//     cached, since the engine accepts or rejects its own copy of them.
+//   - the state changes of blocks whose state root was verified, by ID, so
+//     the blocks being processed don't have them worked out again each
+//     time they're parsed
+//   - account balances as of the blocks they were looked up at, by block
+//     ID and account
+
+// balanceKey is the key of [account]'s balance as of the block [blockID] in
+// the balance cache
+type balanceKey struct {
+	blockID ids.ID
+	account string
+}
+
+// balanceEntry is what the balance cache holds about an account as of a
+// block. A stake's lock and reward depend on the time, so rather than
+// caching them, the account's stakes are kept to work them out.
+type balanceEntry struct {
+	// The account's balance, not counting stakes
+	ledger int64
+
+	// The stakes whose rewards go to the account, oldest first
+	stakes []*Block
+}
+
+// cacheSize returns [configured], or [defaultSize] if it isn't set
+func cacheSize(configured int, defaultSize int) int {
+	if configured <= 0 {
+		return defaultSize
+	}
+	return configured
+}
+
+// initializeCaches creates the caches, sized by the config, and registers
+// their hit and miss metrics on [registerer]
+func (vm *VM) initializeCaches(namespace string, registerer prometheus.Registerer) error {
+	var err error
+	vm.blockCache, err = metercacher.New(
+		fmt.Sprintf("%s_block_cache", namespace),
+		registerer,
+		&cache.LRU{Size: cacheSize(vm.config.BlockCacheSize, defaultBlockCacheSize)},
+	)
+	if err != nil {
+		return err
+	}
+	vm.stateDiffCache, err = metercacher.New(
+		fmt.Sprintf("%s_state_diff_cache", namespace),
+		registerer,
+		&cache.LRU{Size: cacheSize(vm.config.StateDiffCacheSize, defaultStateDiffCacheSize)},
+	)
+	if err != nil {
+		return err
+	}
+	vm.balanceCache, err = metercacher.New(
+		fmt.Sprintf("%s_balance_cache", namespace),
+		registerer,
+		&cache.LRU{Size: cacheSize(vm.config.BalanceCacheSize, defaultBalanceCacheSize)},
+	)
+	return err
+}
+
+// hasID returns false while NewBlock is still working out this block's
+// state root, before the block has its real ID. Nothing about it can be
+// cached by ID until then.
+func (b *Block) hasID() bool {
+	return len(b.Bytes()) > 0
+}
+
+// getCachedStateChanges returns the state changes of this block if they've
+// been worked out, by this copy of it or another, or nil otherwise
+func (b *Block) getCachedStateChanges() map[string][]byte {
+	if b.stateChanges == nil && b.hasID() {
+		if changes, ok := b.vm.stateDiffCache.Get(b.ID()); ok {
+			b.stateChanges = changes.(map[string][]byte)
+		}
+	}
+	return b.stateChanges
+}
+
+// getBalanceEntry returns [account]'s balance, not counting stakes, and its
+// stakes, as of this block. It walks back to the closest block with the
+// entry cached, or to genesis, and caches the entry as of this block and
+// every [balanceCheckpointInterval]th block it walked, so a lookup doesn't
+// fill the cache with all the ancestors it walked. Rejected blocks' entries
+// aren't cached, since nothing builds on them.
+func (b *Block) getBalanceEntry(account string) (balanceEntry, error) {
+	entry := balanceEntry{}
+	walked := []*Block{}
+	for block := b; ; {
+		if block.hasID() {
+			if cached, ok := b.vm.balanceCache.Get(balanceKey{blockID: block.ID(), account: account}); ok {
+				entry = cached.(balanceEntry)
+				break
+			}
+		}
+		walked = append(walked, block)
+		if block.Parent() == ids.Empty {
+			break
+		}
+		parent, err := block.getParent()
+		if err != nil {
+			return balanceEntry{}, err
+		}
+		block = parent
+	}
+	// Copied so the cached entries keep their own stakes
+	entry.stakes = append([]*Block(nil), entry.stakes...)
+	for i := len(walked) - 1; i >= 0; i-- {
+		block := walked[i]
+		entry.ledger += block.getLedgerChange(account)
+		if block.isStakeBlock() && block.getStakeRewardAddress() == account {
+			entry.stakes = append(entry.stakes, block)
+		}
+		if i == 0 || block.Height()%balanceCheckpointInterval == 0 {
+			block.cacheBalanceEntry(account, entry)
+		}
+	}
+	return entry, nil
+}
+
+// cacheBalanceEntry caches [entry] as [account]'s as of this block, unless
+// this block was rejected or isn't built yet
+func (b *Block) cacheBalanceEntry(account string, entry balanceEntry) {
+	if !b.hasID() {
+		return
+	}
+	if status := b.Status(); status == choices.Accepted || status == choices.Processing {
+		// Capped so appending to the stakes later doesn't touch the cached ones
+		entry.stakes = entry.stakes[:len(entry.stakes):len(entry.stakes)]
+		b.vm.balanceCache.Put(balanceKey{blockID: b.ID(), account: account}, entry)
+	}
+}
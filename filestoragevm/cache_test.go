@@ -0,0 +1,162 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+// getTestCacheCount returns the value of the counter [name] that [ctx]'s
+// caches registered, like "_balance_cache_hit"
+func getTestCacheCount(t *testing.T, ctx *snow.Context, name string) float64 {
+	families, err := ctx.Metrics.(prometheus.Gatherer).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("no metric %s", name)
+	return 0
+}
+
+func TestCaches(t *testing.T) {
+	vm, ctx, _ := newTestVMWithConfig(t, []byte{0, 0, 0, 0, 0}, []byte(`{"blockCacheSize": 64, "balanceCacheSize": 64}`))
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	if vm.config.BlockCacheSize != 64 || vm.config.BalanceCacheSize != 64 {
+		t.Fatalf("expected the cache sizes from the config but got %+v", vm.config)
+	}
+	faucet := acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 20, alice.address))
+	transfer := acceptTestPayload(t, vm, newTestPayload(t, alice, '1', fmt.Sprintf("%016d%s%s", 5, alice.address, bob.address)))
+
+	// Decided blocks are shared, but each lookup of a block being processed
+	// gets its own copy
+	first, err := vm.getBlock(transfer.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := vm.getBlock(transfer.ID()); err != nil || second != first {
+		t.Fatalf("expected the cached copy of %s but got %p, %v", transfer.ID(), second, err)
+	}
+	processing := newTestBlockAt(t, vm, newTestFaucetPayload(t, bob, 1, bob.address), time.Now())
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	first, err = vm.getBlock(processing.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := vm.getBlock(processing.ID()); err != nil || second == first {
+		t.Fatalf("expected a new copy of %s but got %p, %v", processing.ID(), second, err)
+	}
+
+	// Balances are looked up in the cache the second time
+	hits := getTestCacheCount(t, ctx, "_balance_cache_hit")
+	if balance := getTestBalance(t, transfer, bob.address); balance != 5 {
+		t.Fatalf("expected bob to have 5 but got %d", balance)
+	}
+	if balance := getTestBalance(t, transfer, bob.address); balance != 5 {
+		t.Fatalf("expected bob to have 5 but got %d", balance)
+	}
+	if getTestCacheCount(t, ctx, "_balance_cache_hit") != hits+1 {
+		t.Fatal("expected bob's balance to be cached")
+	}
+	// but only as of some of the blocks walked back through to work it out
+	if _, ok := vm.balanceCache.Get(balanceKey{blockID: faucet.ID(), account: bob.address}); ok {
+		t.Fatal("expected bob's balance as of an ancestor not to be cached")
+	}
+
+	// A balance that can't be worked out is an error, and isn't cached
+	orphan := &Block{
+		Block:   core.NewBlock(ids.GenerateTestID(), transfer.Height()+1, time.Now().Unix()),
+		Data:    newTestFaucetPayload(t, bob, 1, bob.address),
+		BaseFee: transfer.getNextBaseFee(),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, orphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.(*Block).getBalance(bob.address); err == nil {
+		t.Fatal("expected an error for a block whose parent is unknown")
+	}
+	if _, ok := vm.balanceCache.Get(balanceKey{blockID: parsed.ID(), account: bob.address}); ok {
+		t.Fatal("expected no balance to be cached for a block whose parent is unknown")
+	}
+
+	// Stakes are cached, but not their locks, which depend on the time
+	if err := vm.SetPreference(transfer.ID()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(time.Minute).Unix()
+	stake := acceptTestPayload(t, vm, newTestStakePayload(t, bob, 3, start, start+600))
+	for i := 0; i < 2; i++ {
+		if balance := getTestBalance(t, stake, bob.address); balance != 2 {
+			t.Fatalf("expected bob to have 2 left after staking 3 but got %d", balance)
+		}
+	}
+
+	// The state changes of blocks being processed are only worked out once
+	hits = getTestCacheCount(t, ctx, "_state_diff_cache_hit")
+	next := newTestBlockAt(t, vm, newTestFaucetPayload(t, alice, 1, alice.address), time.Now())
+	if err := next.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = vm.ParseBlock(next.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.(*Block).getStateChanges(); err != nil {
+		t.Fatal(err)
+	}
+	if getTestCacheCount(t, ctx, "_state_diff_cache_hit") != hits+1 {
+		t.Fatal("expected the parsed block's state changes to be cached")
+	}
+}
+
+func TestBalanceCheckpoints(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	blocks := []*Block{}
+	for i := 0; i < balanceCheckpointInterval+1; i++ {
+		blocks = append(blocks, acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 1, bob.address)))
+	}
+	tip := blocks[len(blocks)-1]
+	if balance := getTestBalance(t, tip, bob.address); balance != balanceCheckpointInterval+1 {
+		t.Fatalf("expected bob to have %d but got %d", balanceCheckpointInterval+1, balance)
+	}
+
+	// The lookup cached bob's balance as of the checkpoint it walked through,
+	// so the next lookup stops there
+	checkpoint := blocks[balanceCheckpointInterval-1]
+	if checkpoint.Height() != balanceCheckpointInterval {
+		t.Fatalf("expected the checkpoint at height %d but got %d", balanceCheckpointInterval, checkpoint.Height())
+	}
+	cached, ok := vm.balanceCache.Get(balanceKey{blockID: checkpoint.ID(), account: bob.address})
+	if !ok || cached.(balanceEntry).ledger != balanceCheckpointInterval {
+		t.Fatalf("expected bob's balance as of the checkpoint to be cached but got %v", cached)
+	}
+	if _, ok := vm.balanceCache.Get(balanceKey{blockID: blocks[0].ID(), account: bob.address}); ok {
+		t.Fatal("expected bob's balance as of other ancestors not to be cached")
+	}
+}
@@ -0,0 +1,70 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// vmConfig is this node's configuration of the VM, from the chain's config
+// file. Unlike the genesis parameters, nodes can have different configs.
+type vmConfig struct {
+	// If true, the data of files is deleted from this node once they expire.
+	// The blocks are kept, but this node can't serve the pruned ones to
+	// nodes that are bootstrapping.
+	PruneExpired bool `json:"pruneExpired"`
+
+	// If true, the data of files is deleted from this node once their
+	// owners delete them, with the same caveat
+	PruneDeleted bool `json:"pruneDeleted"`
+
+	// Seconds the chain can go without accepting a block, while it has
+	// data waiting to be put in one, before the health check reports it as
+	// stalled. Defaults to [defaultStallTimeout].
+	StallTimeout int64 `json:"stallTimeout"`
+
+	// Number of entries in the caches of decided blocks, blocks' state
+	// changes and account balances. See cache.go for their defaults.
+	BlockCacheSize     int `json:"blockCacheSize"`
+	StateDiffCacheSize int `json:"stateDiffCacheSize"`
+	BalanceCacheSize   int `json:"balanceCacheSize"`
+
+	// Number of workers checking the signatures of the blocks the engine
+	// parses, and of public keys recovered from signatures to cache.
+	// Default to the number of CPUs and [defaultSignatureCacheSize].
+	SignatureWorkers   int `json:"signatureWorkers"`
+	SignatureCacheSize int `json:"signatureCacheSize"`
+}
+
+// getStallTimeout returns how long the chain can go without accepting a
+// block, while it has data waiting, before it's stalled
+func (c vmConfig) getStallTimeout() time.Duration {
+	if c.StallTimeout <= 0 {
+		return defaultStallTimeout
+	}
+	return time.Duration(c.StallTimeout) * time.Second
+}
+
+// getSignatureWorkers returns the number of workers to check signatures on
+func (c vmConfig) getSignatureWorkers() int {
+	if c.SignatureWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return c.SignatureWorkers
+}
+
+// parseConfig returns the config set by [data], which is empty or JSON
+func parseConfig(data []byte) (vmConfig, error) {
+	config := vmConfig{}
+	if len(data) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("couldn't parse config: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,160 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+const (
+	// Length of a hex encoded chunk hash
+	chunkHashLen = 64
+
+	// Length of a chunk reference's content: the upload header and the
+	// hash of the referenced chunk
+	referenceLen = uploadHeaderLen + chunkHashLen
+)
+
+var (
+	contentIndexPrefix = []byte("content")
+
+	errMalformedReference = errors.New("chunk reference is malformed")
+	errNoSuchChunk        = errors.New("there is no chunk with that hash")
+	errBadChunkHash       = errors.New("chunk hash must be 64 hex characters")
+)
+
+// A chunk reference is an upload that, instead of carrying the chunk's
+// data, names an existing chunk by the SHA-256 of its data. The data is
+// stored once and every file that has that chunk points at it.
+func (b *Block) isReferenceBlock() bool {
+	return b.getBlockType() == "5"
+}
+
+// returns the hex encoded hash of the chunk a reference points at
+func (b *Block) getReferenceHash() string {
+	return string(b.Data[contentOffset+uploadHeaderLen : contentOffset+referenceLen])
+}
+
+// getChunkHash returns the SHA-256 of an upload's chunk data. For a chunk
+// reference that's the hash it names, without looking up the chunk.
+func (b *Block) getChunkHash() ([32]byte, error) {
+	if b.isReferenceBlock() {
+		return parseChunkHash(b.getReferenceHash())
+	}
+	return sha256.Sum256(b.getUploadChunk()), nil
+}
+
+// parseChunkHash decodes the hex encoded chunk hash [hash]
+func parseChunkHash(hash string) ([32]byte, error) {
+	var out [32]byte
+	if len(hash) != chunkHashLen {
+		return out, errBadChunkHash
+	}
+	if _, err := hex.Decode(out[:], []byte(hash)); err != nil {
+		return out, errBadChunkHash
+	}
+	return out, nil
+}
+
+// getContentBlock returns the upload holding the data whose SHA-256 is
+// [hash], as of this block. Chunk references never hold data, so the
+// result is always a data chunk or a session data chunk.
+func (b *Block) getContentBlock(hash [32]byte) (*Block, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return nil, err
+	}
+	// Oldest first, so a chunk uploaded twice resolves to the first one,
+	// like it does in the content index
+	for i := len(ancestry) - 1; i >= 0; i-- {
+		block := ancestry[i]
+		if !block.isUploadBlock() || block.isReferenceBlock() {
+			continue
+		}
+		if blockHash, err := block.getChunkHash(); err == nil && blockHash == hash {
+			return block, nil
+		}
+	}
+	return b.vm.getContentBlock(hash)
+}
+
+// getContentBlock returns the accepted upload holding the data whose
+// SHA-256 is [hash]
+func (vm *VM) getContentBlock(hash [32]byte) (*Block, error) {
+	id, err := database.GetID(vm.contentDB, hash[:])
+	if err == database.ErrNotFound {
+		return nil, errNoSuchChunk
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vm.getBlock(id)
+}
+
+// getReferencedChunk returns the data a chunk reference points at, or nil
+// if it doesn't point at anything
+func (b *Block) getReferencedChunk() []byte {
+	hash, err := b.getChunkHash()
+	if err != nil {
+		return nil
+	}
+	block, err := b.getContentBlock(hash)
+	if err != nil {
+		return nil
+	}
+	return block.getUploadChunk()
+}
+
+// verifyReference returns nil iff this chunk reference is well formed and
+// points at a chunk that's on this block's chain. The data must be held by
+// a file that hasn't expired, since nodes may have deleted it otherwise.
+func (b *Block) verifyReference(parent *Block) error {
+	if b.getContentLength() != referenceLen {
+		return errMalformedReference
+	}
+	hash, err := b.getChunkHash()
+	if err != nil {
+		return errMalformedReference
+	}
+	if _, err := b.getContentBlock(hash); err != nil {
+		return err
+	}
+	live, err := parent.isContentLive(hash, b.Timestamp().Unix())
+	if err != nil {
+		return err
+	}
+	if !live {
+		return errChunkExpired
+	}
+	return nil
+}
+
+// indexContent adds the accepted block [b] to the content index if it's an
+// upload holding data that isn't indexed yet. The first upload of some
+// data is the one that gets referenced.
+func (vm *VM) indexContent(b *Block) error {
+	if !b.isUploadBlock() || b.isReferenceBlock() {
+		return nil
+	}
+	hash, err := b.getChunkHash()
+	if err != nil {
+		return err
+	}
+	has, err := vm.contentDB.Has(hash[:])
+	if err != nil || has {
+		return err
+	}
+	return database.PutID(vm.contentDB, hash[:], b.ID())
+}
+
+// hashChunk returns the hex encoded SHA-256 of [chunk], which is how
+// chunk references and GetChunk name it
+func hashChunk(chunk []byte) string {
+	hash := sha256.Sum256(chunk)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,122 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+)
+
+func TestChunkReference(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	other := newTestKey(t)
+	hash := hashChunk([]byte("hello "))
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, other, 10, other.address))
+
+	// Nothing to reference yet
+	lastAccepted, err := vm.getLastAcceptedBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := vm.NewBlock(lastAccepted.ID(), lastAccepted.Height()+1, newTestReferencePayload(t, other, "file000000000002", 0, hash), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := missing.Verify(); err != errNoSuchChunk {
+		t.Fatalf("expected %s but got %v", errNoSuchChunk, err)
+	}
+
+	// A reference can point at a chunk that's verified but not accepted yet
+	upload, err := vm.NewBlock(lastAccepted.ID(), lastAccepted.Height()+1, newTestUploadPayload(t, key, "file000000000001", 0, "hello "), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	processing, err := vm.NewBlock(upload.ID(), upload.Height()+1, newTestReferencePayload(t, other, "file000000000002", 0, hash), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if string(processing.getUploadChunk()) != "hello " {
+		t.Fatalf("expected the referenced data but got %q", processing.getUploadChunk())
+	}
+	if err := upload.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err := processing.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.SetPreference(processing.ID()); err != nil {
+		t.Fatal(err)
+	}
+	chunk1 := acceptTestPayload(t, vm, newTestUploadPayload(t, other, "file000000000002", 1, "world"))
+
+	// The referencing file reads like any other, and pays the reference fee
+	service := Service{vm}
+	reply := GetFileReply{}
+	args := &GetFileArgs{Owner: other.address, FileID: "file000000000002", IncludeContent: true, Encoding: formatting.Hex}
+	if err := service.GetFile(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	content, err := formatting.Decode(formatting.Hex, reply.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" || reply.File.Size != 11 {
+		t.Fatalf("expected 11 bytes of %q but got %+v", "hello world", reply)
+	}
+	if reply.Chunks[0].Hash != hash || reply.Chunks[0].BlockID != processing.ID().String() {
+		t.Fatalf("expected chunk 0 to be the reference but got %+v", reply.Chunks[0])
+	}
+	if balance := getTestBalance(t, processing, other.address); balance != 10-processing.getStorageFee() {
+		t.Fatalf("expected a balance of %d but got %d", 10-processing.getStorageFee(), balance)
+	}
+
+	// A manifest can list the reference
+	acceptTestPayload(t, vm, newTestManifestPayload(t, other, "file000000000002", "hello.txt", "text/plain", "hello world", []ids.ID{processing.ID(), chunk1.ID()}))
+}
+
+func TestGetChunk(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	first := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000002", 0, "hello"))
+
+	// Identical data uploaded twice is found at the first upload
+	service := Service{vm}
+	reply := GetChunkReply{}
+	if err := service.GetChunk(nil, &GetChunkArgs{Hash: hashChunk([]byte("hello")), Encoding: formatting.Hex}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	content, err := formatting.Decode(formatting.Hex, reply.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.BlockID != first.ID().String() || reply.Size != 5 || string(content) != "hello" {
+		t.Fatalf("expected the first upload of %q but got %+v", "hello", reply)
+	}
+
+	if err := service.GetChunk(nil, &GetChunkArgs{Hash: hashChunk([]byte("world"))}, &reply); err != errNoSuchChunk {
+		t.Fatalf("expected %s but got %v", errNoSuchChunk, err)
+	}
+	if err := service.GetChunk(nil, &GetChunkArgs{Hash: "hello"}, &reply); err != errBadChunkHash {
+		t.Fatalf("expected %s but got %v", errBadChunkHash, err)
+	}
+}
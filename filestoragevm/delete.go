@@ -0,0 +1,181 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+// Length of a deletion's content: the file ID and the refund
+const deleteLen = fileIDLen + 16
+
+var (
+	deletedIndexPrefix = []byte("deleted")
+
+	errMalformedDelete = errors.New("deletion is malformed")
+	errFileDeleted     = errors.New("file was deleted")
+	errRefundTooHigh   = errors.New("deletion's refund is more than the file's unused rent")
+)
+
+// A deletion withdraws one of the signer's files. The file stays in the
+// file index with a tombstone, its ID can't be used again, and nodes stop
+// serving it. It can claim back part of the rent paid for the file.
+func (b *Block) isDeleteBlock() bool {
+	return b.getBlockType() == "7"
+}
+
+func (b *Block) getDeleteFileID() string {
+	return string(b.Data[contentOffset : contentOffset+fileIDLen])
+}
+
+// returns the refund the signer claims. It's in the transaction, and
+// checked against the file's rent when it's verified, like a renewal's
+// rent.
+func (b *Block) getDeleteRefund() int64 {
+	refundBytes := b.Data[contentOffset+fileIDLen : contentOffset+deleteLen]
+	return b.convertBytesToInt(refundBytes)
+}
+
+// isFileDeleted returns true iff [creator]'s file [fileID] was deleted, as
+// of this block
+func (b *Block) isFileDeleted(creator string, fileID string) (bool, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return false, err
+	}
+	for _, block := range ancestry {
+		if !block.isDeleteBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil || isOfFile {
+			return isOfFile, err
+		}
+	}
+	return b.vm.isAcceptedFileDeleted(creator, fileID)
+}
+
+// isAcceptedFileDeleted returns true iff [creator]'s file [fileID] was
+// deleted, as of the last accepted block
+func (vm *VM) isAcceptedFileDeleted(creator string, fileID string) (bool, error) {
+	return vm.deletedDB.Has(fileKey(creator, fileID))
+}
+
+// verifyNotDeleted returns nil iff [creator]'s file [fileID] wasn't
+// deleted, as of this block's parent
+func (b *Block) verifyNotDeleted(parent *Block, creator string, fileID string) error {
+	deleted, err := parent.isFileDeleted(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return errFileDeleted
+	}
+	return nil
+}
+
+// hasFile returns true iff some of [creator]'s file [fileID] was uploaded,
+// as of this block
+func (b *Block) hasFile(creator string, fileID string) (bool, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return false, err
+	}
+	for _, block := range ancestry {
+		if !block.isUploadBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil || isOfFile {
+			return isOfFile, err
+		}
+	}
+	return b.vm.fileDB.Has(fileKey(creator, fileID))
+}
+
+// getRefund returns the most a deletion of [creator]'s file [fileID] at
+// [time] can claim back, as of this block. That's [RentRefundPercent] of
+// the rent for the file's whole days left, but never more than its
+// renewals paid.
+func (b *Block) getRefund(creator string, fileID string, time int64) (int64, error) {
+	if b.vm.params.RentRefundPercent == 0 {
+		return 0, nil
+	}
+	expiry, hasExpiry, err := b.getFileExpiry(creator, fileID)
+	if err != nil || !hasExpiry || expiry <= time {
+		return 0, err
+	}
+	size, err := b.getFileSize(creator, fileID)
+	if err != nil {
+		return 0, err
+	}
+	rentPaid, err := b.getRentPaid(creator, fileID)
+	if err != nil {
+		return 0, err
+	}
+	days := (expiry - time) / secondsPerDay
+	refund := b.vm.computeRent(size, days) * int64(b.vm.params.RentRefundPercent) / 100
+	if refund > rentPaid {
+		return rentPaid, nil
+	}
+	return refund, nil
+}
+
+// verifyDelete returns nil iff this deletion is of a file the signer owns
+// or is an admin of, that exists and wasn't deleted already, and claims no
+// more than its refund
+func (b *Block) verifyDelete(parent *Block) error {
+	if b.getContentLength() != deleteLen {
+		return errMalformedDelete
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getDeleteFileID()
+	hasFile, err := parent.hasFile(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if !hasFile {
+		return errNoSuchFile
+	}
+	if err := b.verifyFileRole(parent, roleAdmin); err != nil {
+		return err
+	}
+	if err := b.verifyNotDeleted(parent, creator, fileID); err != nil {
+		return err
+	}
+	// The refund can be less than the most it could be, so it's still
+	// valid if the block's time turns out to be later than expected
+	refund, err := parent.getRefund(creator, fileID, b.Timestamp().Unix())
+	if err != nil {
+		return err
+	}
+	if b.getDeleteRefund() > refund {
+		return errRefundTooHigh
+	}
+	return nil
+}
+
+// indexDeletion puts a tombstone in the file index for the file deleted by
+// the accepted block [b], then deletes its data, if this node prunes
+// deleted files
+func (vm *VM) indexDeletion(b *Block) error {
+	if !b.isDeleteBlock() {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getDeleteFileID()
+	if err := database.PutID(vm.deletedDB, fileKey(creator, fileID), b.ID()); err != nil {
+		return err
+	}
+	if !vm.config.PruneDeleted {
+		return nil
+	}
+	return vm.pruneFile(b, creator, fileID)
+}
@@ -0,0 +1,94 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestDeletePayload returns a signed deletion of [fileID] claiming
+// [refund]
+func newTestDeletePayload(t *testing.T, key *testKey, fileID string, refund int) [dataLen]byte {
+	return newTestPayload(t, key, '7', fmt.Sprintf("%s%016d", fileID, refund))
+}
+
+func TestDeleteFile(t *testing.T) {
+	// Rent is 2 per KB per day, and half of it is refunded
+	vm, ctx, _ := newTestVMWithGenesis(t, []byte(`{"rentPerKBDay": 2, "uploadRetention": 60, "rentRefundPercent": 50}`))
+	key := newTestKey(t)
+	fileID := "file000000000001"
+	start := time.Now().Add(-time.Hour)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1000, key.address), start)
+	acceptTestBlockAt(t, vm, newTestUploadPayload(t, key, fileID, 0, "hello"), start)
+	acceptTestBlockAt(t, vm, newTestRenewPayload(t, key, fileID, 10, 20), start)
+
+	// 10 whole days are left, which is 20 rent
+	deleteTime := start.Add(10 * time.Second)
+	if err := newTestBlockAt(t, vm, newTestDeletePayload(t, key, fileID, 11), deleteTime).Verify(); err != errRefundTooHigh {
+		t.Fatalf("expected %s but got %v", errRefundTooHigh, err)
+	}
+	deletion := acceptTestBlockAt(t, vm, newTestDeletePayload(t, key, fileID, 10), deleteTime)
+	// 1 for each of the upload, renewal and deletion, 20 rent and 10 back
+	if balance := getTestBalance(t, deletion, key.address); balance != 1000-3-20+10 {
+		t.Fatalf("expected a balance of %d but got %d", 1000-3-20+10, balance)
+	}
+
+	later := deleteTime.Add(time.Second)
+	for name, data := range map[string][dataLen]byte{
+		"upload":   newTestUploadPayload(t, key, fileID, 1, "world"),
+		"renewal":  newTestRenewPayload(t, key, fileID, 1, 2),
+		"deletion": newTestDeletePayload(t, key, fileID, 0),
+	} {
+		if err := newTestBlockAt(t, vm, data, later).Verify(); err != errFileDeleted {
+			t.Fatalf("expected %s for the %s but got %v", errFileDeleted, name, err)
+		}
+	}
+
+	service := Service{vm}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: key.address, FileID: fileID}, &GetFileReply{}); err != errFileDeleted {
+		t.Fatalf("expected %s but got %v", errFileDeleted, err)
+	}
+	recorder := httptest.NewRecorder()
+	gateway := &fileGateway{vm}
+	gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=%s", key.address, fileID), nil))
+	if status := recorder.Result().StatusCode; status != http.StatusGone {
+		t.Fatalf("expected status %d but got %d", http.StatusGone, status)
+	}
+}
+
+func TestPruneDeleted(t *testing.T) {
+	vm, ctx, _ := newTestVMWithConfig(t, []byte{0, 0, 0, 0, 0}, []byte(`{"pruneDeleted": true}`))
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	hello := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"))
+	acceptTestPayload(t, vm, newTestReferencePayload(t, key, "file000000000002", 0, hashChunk([]byte("hello"))))
+
+	// The second file still holds the data
+	acceptTestPayload(t, vm, newTestDeletePayload(t, key, "file000000000001", 0))
+	if chunk, err := vm.getBlock(hello.ID()); err != nil || chunk.pruned {
+		t.Fatalf("expected the chunk to be kept but got %v", err)
+	}
+
+	acceptTestPayload(t, vm, newTestDeletePayload(t, key, "file000000000002", 0))
+	chunk, err := vm.getBlock(hello.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chunk.pruned {
+		t.Fatal("expected the chunk to be pruned once both files were deleted")
+	}
+	if err := newTestBlockAt(t, vm, newTestReferencePayload(t, key, "file000000000003", 0, hashChunk([]byte("hello"))), time.Now()).Verify(); err != errChunkExpired {
+		t.Fatalf("expected %s but got %v", errChunkExpired, err)
+	}
+}
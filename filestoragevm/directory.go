@@ -0,0 +1,415 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+const (
+	// Length of the fixed part of a rename, before the path: the file ID
+	// and the length of the path
+	renameHeaderLen = fileIDLen + 3
+
+	// Longest path a file can have
+	maxPathLen = 255
+)
+
+var (
+	pathIndexPrefix      = []byte("path")
+	directoryIndexPrefix = []byte("directory")
+	fileOwnerIndexPrefix = []byte("fileOwner")
+
+	errMalformedRename = errors.New("rename is malformed")
+	errBadPath         = errors.New("path must start with /, and have no empty, . or .. parts")
+	errPathTaken       = errors.New("owner already has a file at that path")
+
+	// Key in the path index marking that it was backfilled. Its keys are
+	// otherwise a creator and a file ID, so it can't be one of them.
+	pathsIndexedKey = []byte("indexed")
+)
+
+// A file can have a path, like /projects/2026/report.pdf, in its owner's
+// directory tree. A manifest whose file name starts with / sets it, and so
+// does a rename. Each of an owner's files has its own path, and deleted
+// files leave the tree.
+
+// A rename moves one of the signer's files to a new path.
+func (b *Block) isRenameBlock() bool {
+	return b.getBlockType() == "c"
+}
+
+func (b *Block) getRenameFileID() string {
+	return string(b.Data[contentOffset : contentOffset+fileIDLen])
+}
+
+// returns the path a rename moves its file to
+func (b *Block) getRenamePath() (string, error) {
+	content := b.getContent()
+	if len(content) < renameHeaderLen {
+		return "", errMalformedRename
+	}
+	pathLen, err := strconv.ParseUint(string(content[fileIDLen:renameHeaderLen]), 10, 16)
+	if err != nil || uint64(len(content)) != renameHeaderLen+pathLen {
+		return "", errMalformedRename
+	}
+	return string(content[renameHeaderLen:]), nil
+}
+
+// getSetPath returns the path the transaction in this block moves its file
+// to, and false if it doesn't move it
+func (b *Block) getSetPath() (string, bool) {
+	if b.isRenameBlock() {
+		path, err := b.getRenamePath()
+		return path, err == nil
+	}
+	if b.isManifestBlock() {
+		// Manifests from before paths could have names that aren't paths
+		name := b.getManifestName()
+		return name, verifyPath(name) == nil
+	}
+	return "", false
+}
+
+// verifyPath returns nil iff [path] can be a file's path
+func verifyPath(path string) error {
+	if len(path) > maxPathLen || !strings.HasPrefix(path, "/") {
+		return errBadPath
+	}
+	for _, part := range strings.Split(path[1:], "/") {
+		if part == "" || part == "." || part == ".." {
+			return errBadPath
+		}
+	}
+	return nil
+}
+
+// directoryKey returns the directory index key of the file at [path] in
+// [owner]'s directory tree. Keys start with the owner, then the path, so a
+// directory's files can be iterated in order.
+func directoryKey(owner string, path string) []byte {
+	key := make([]byte, 0, addressLen+len(path))
+	key = append(key, owner...)
+	return append(key, path...)
+}
+
+// getFilePath returns the path of [creator]'s file [fileID] as of this
+// block, or "" if it doesn't have one
+func (b *Block) getFilePath(creator string, fileID string) (string, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return "", err
+	}
+	for _, block := range ancestry {
+		path, setsPath := block.getSetPath()
+		if !setsPath && !block.isDeleteBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return "", err
+		} else if isOfFile {
+			return path, nil
+		}
+	}
+	return b.vm.getAcceptedFilePath(creator, fileID)
+}
+
+// getAcceptedFilePath returns the path of [creator]'s file [fileID] as of
+// the last accepted block, like getFilePath
+func (vm *VM) getAcceptedFilePath(creator string, fileID string) (string, error) {
+	path, err := vm.pathDB.Get(fileKey(creator, fileID))
+	if err == database.ErrNotFound {
+		return "", nil
+	}
+	return string(path), err
+}
+
+// getAcceptedFileIDAt returns the file ID of the file at [path] in
+// [owner]'s directory tree as of the last accepted block
+func (vm *VM) getAcceptedFileIDAt(owner string, path string) (string, error) {
+	fileID, err := vm.directoryDB.Get(directoryKey(owner, path))
+	if err == database.ErrNotFound {
+		return "", errNoSuchFile
+	}
+	return string(fileID), err
+}
+
+// getFileOwner returns the owner of [creator]'s file [fileID] as of this
+// block
+func (b *Block) getFileOwner(creator string, fileID string) (string, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return "", err
+	}
+	for _, block := range ancestry {
+		if !block.isFileTransferBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return "", err
+		} else if isOfFile {
+			return block.getFileTransferRecipient(), nil
+		}
+	}
+	return b.vm.getAcceptedFileOwner(creator, fileID)
+}
+
+// getAcceptedFileOwner returns the owner of [creator]'s file [fileID] as
+// of the last accepted block. Files that were never transferred aren't in
+// the index, as they're owned by their creator.
+func (vm *VM) getAcceptedFileOwner(creator string, fileID string) (string, error) {
+	owner, err := vm.fileOwnerDB.Get(fileKey(creator, fileID))
+	if err == database.ErrNotFound {
+		return creator, nil
+	}
+	return string(owner), err
+}
+
+// isFileAtPath returns true iff [creator]'s file [fileID] is at [path] in
+// [owner]'s directory tree, as of this block
+func (b *Block) isFileAtPath(creator string, fileID string, owner string, path string) (bool, error) {
+	filePath, err := b.getFilePath(creator, fileID)
+	if err != nil || filePath != path {
+		return false, err
+	}
+	fileOwner, err := b.getFileOwner(creator, fileID)
+	return fileOwner == owner, err
+}
+
+// getFileAtPath returns the creator and file ID of the file at [path] in
+// [owner]'s directory tree as of this block, and false if there's none
+func (b *Block) getFileAtPath(owner string, path string) (string, string, bool, error) {
+	// The file there is either the one there as of the last accepted block,
+	// or one that was moved there, or given to [owner], since then
+	type file struct{ creator, fileID string }
+	files := []file{}
+	fileID, err := b.vm.directoryDB.Get(directoryKey(owner, path))
+	if err == nil {
+		creator, err := b.vm.getAcceptedFileCreatorOf(owner, string(fileID))
+		if err != nil {
+			return "", "", false, err
+		}
+		files = append(files, file{creator, string(fileID)})
+	} else if err != database.ErrNotFound {
+		return "", "", false, err
+	}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return "", "", false, err
+	}
+	for _, block := range ancestry {
+		blockPath, setsPath := block.getSetPath()
+		if (setsPath && blockPath == path) || (block.isFileTransferBlock() && block.getFileTransferRecipient() == owner) {
+			creator, err := block.getFileCreator()
+			if err != nil {
+				return "", "", false, err
+			}
+			files = append(files, file{creator, block.getFileID()})
+		}
+	}
+	for _, f := range files {
+		if atPath, err := b.isFileAtPath(f.creator, f.fileID, owner, path); err != nil {
+			return "", "", false, err
+		} else if atPath {
+			return f.creator, f.fileID, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// verifyPathFree returns nil iff, as of [parent], there's no file at
+// [path] in [owner]'s directory tree other than [creator]'s file [fileID]
+func verifyPathFree(parent *Block, owner string, path string, creator string, fileID string) error {
+	atCreator, atFileID, found, err := parent.getFileAtPath(owner, path)
+	if err != nil {
+		return err
+	}
+	if found && (atCreator != creator || atFileID != fileID) {
+		return errPathTaken
+	}
+	return nil
+}
+
+// verifySetPath returns nil iff the path this block moves its file to, if
+// it moves it, is one it can have. Manifests without a tx root are exempt,
+// since any file name was allowed before them.
+func (b *Block) verifySetPath(parent *Block) error {
+	path, setsPath := b.getSetPath()
+	if !setsPath || (b.isManifestBlock() && b.rootless) {
+		return nil
+	}
+	if err := verifyPath(path); err != nil {
+		return err
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getFileID()
+	owner, err := parent.getFileOwner(creator, fileID)
+	if err != nil {
+		return err
+	}
+	return verifyPathFree(parent, owner, path, creator, fileID)
+}
+
+// verifyRename returns nil iff this rename moves a file the signer can
+// write to, which hasn't expired or been deleted, to a path its owner
+// doesn't have a file at
+func (b *Block) verifyRename(parent *Block) error {
+	if _, err := b.getRenamePath(); err != nil {
+		return err
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getRenameFileID()
+	hasFile, err := parent.hasFile(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if !hasFile {
+		return errNoSuchFile
+	}
+	if err := b.verifyFileRole(parent, roleWrite); err != nil {
+		return err
+	}
+	if err := b.verifyNotExpired(parent, creator, fileID); err != nil {
+		return err
+	}
+	if err := b.verifyNotDeleted(parent, creator, fileID); err != nil {
+		return err
+	}
+	return b.verifySetPath(parent)
+}
+
+// indexPath updates the path and directory indexes with the accepted block
+// [b]
+func (vm *VM) indexPath(b *Block) error {
+	path, setsPath := b.getSetPath()
+	if !setsPath && !b.isDeleteBlock() && !b.isFileTransferBlock() {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getFileID()
+	oldPath, err := vm.getAcceptedFilePath(creator, fileID)
+	if err != nil {
+		return err
+	}
+
+	// Transfers move the file to the same path in the recipient's tree
+	if b.isFileTransferBlock() {
+		if err := vm.fileOwnerDB.Put(fileKey(creator, fileID), []byte(b.getFileTransferRecipient())); err != nil {
+			return err
+		}
+		if oldPath == "" {
+			return nil
+		}
+		if err := vm.directoryDB.Delete(directoryKey(b.getSigner(), oldPath)); err != nil {
+			return err
+		}
+		return vm.directoryDB.Put(directoryKey(b.getFileTransferRecipient(), oldPath), []byte(fileID))
+	}
+
+	owner, err := vm.getAcceptedFileOwner(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if oldPath != "" {
+		if err := vm.directoryDB.Delete(directoryKey(owner, oldPath)); err != nil {
+			return err
+		}
+	}
+	if b.isDeleteBlock() {
+		return vm.pathDB.Delete(fileKey(creator, fileID))
+	}
+	if err := vm.pathDB.Put(fileKey(creator, fileID), []byte(path)); err != nil {
+		return err
+	}
+	return vm.directoryDB.Put(directoryKey(owner, path), []byte(fileID))
+}
+
+// reindexPaths adds the paths set before the path and directory indexes
+// existed to them
+func (vm *VM) reindexPaths() error {
+	indexed, err := vm.pathDB.Has(pathsIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	blocks, err := vm.findAccepted(func(b *Block) bool {
+		_, setsPath := b.getSetPath()
+		return setsPath || b.isDeleteBlock() || b.isFileTransferBlock()
+	})
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := vm.indexPath(block); err != nil {
+			return err
+		}
+	}
+	if err := vm.pathDB.Put(pathsIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
+
+// directoryEntry is a file or directory in a directory
+type directoryEntry struct {
+	// Name of the file or directory in the directory. A directory's name
+	// ends with /.
+	name string
+
+	// File ID of the file, or "" for a directory
+	fileID string
+}
+
+// listDirectory returns up to [limit] of the files and directories in the
+// directory at [path] in [owner]'s directory tree, as of the last accepted
+// block, starting after the one named [cursor]. If [cursor] is empty,
+// starts from the first one. They're ordered by name.
+func (vm *VM) listDirectory(owner string, path string, cursor string, limit int) ([]directoryEntry, error) {
+	prefix := directoryKey(owner, strings.TrimSuffix(path, "/")+"/")
+	start := append(append([]byte{}, prefix...), cursor...)
+	entries := []directoryEntry{}
+	for len(entries) < limit {
+		// After a directory, carry on from just past everything in it
+		if strings.HasSuffix(cursor, "/") {
+			start[len(start)-1] = '/' + 1
+		}
+		iter := vm.directoryDB.NewIteratorWithStartAndPrefix(start, prefix)
+		found := false
+		for len(entries) < limit && iter.Next() {
+			name := string(iter.Key()[len(prefix):])
+			if name == cursor {
+				continue
+			}
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				cursor = name[:i+1]
+				entries = append(entries, directoryEntry{name: cursor})
+				found = true
+				break
+			}
+			cursor = name
+			entries = append(entries, directoryEntry{name: name, fileID: string(iter.Value())})
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+		if !found {
+			break
+		}
+		start = append(append([]byte{}, prefix...), cursor...)
+	}
+	return entries, nil
+}
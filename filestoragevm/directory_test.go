@@ -0,0 +1,154 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+// newTestRenamePayload returns a signed rename of [fileID] to [path]
+func newTestRenamePayload(t *testing.T, key *testKey, fileID string, path string) [dataLen]byte {
+	return newTestPayload(t, key, 'c', fmt.Sprintf("%s%03d%s", fileID, len(path), path))
+}
+
+// acceptTestFileAt accepts an upload of [content] as [fileID], and a
+// manifest putting it at [path]
+func acceptTestFileAt(t *testing.T, vm *VM, key *testKey, fileID string, path string, content string) {
+	chunk := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, content))
+	acceptTestPayload(t, vm, newTestManifestPayload(t, key, fileID, path, "text/plain", content, []ids.ID{chunk.ID()}))
+}
+
+// listTestDirectory returns the names in [owner]'s directory [path]
+func listTestDirectory(t *testing.T, vm *VM, owner string, path string) []string {
+	reply := &ListDirectoryReply{}
+	if err := (&Service{vm}).ListDirectory(nil, &ListDirectoryArgs{Owner: owner, Path: path}, reply); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range reply.Entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestDirectories(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	for _, key := range []*testKey{alice, bob} {
+		acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 20, key.address))
+	}
+	acceptTestFileAt(t, vm, alice, "file000000000001", "/projects/2026/report.pdf", "report")
+	acceptTestFileAt(t, vm, alice, "file000000000002", "/projects/notes.txt", "notes")
+	acceptTestFileAt(t, vm, alice, "file000000000003", "/readme.txt", "readme")
+	acceptTestFileAt(t, vm, bob, "file000000000004", "/projects/notes.txt", "bob's notes")
+
+	taken := acceptTestPayload(t, vm, newTestUploadPayload(t, alice, "file000000000005", 0, "taken"))
+	for name, test := range map[string]struct {
+		data     [dataLen]byte
+		expected error
+	}{
+		"path taken":  {newTestManifestPayload(t, alice, "file000000000005", "/readme.txt", "text/plain", "taken", []ids.ID{taken.ID()}), errPathTaken},
+		"bad path":    {newTestRenamePayload(t, alice, "file000000000003", "/projects//readme.txt"), errBadPath},
+		"rename over": {newTestRenamePayload(t, alice, "file000000000003", "/projects/notes.txt"), errPathTaken},
+		"no file":     {newTestRenamePayload(t, alice, "file000000000009", "/missing.txt"), errNoSuchFile},
+		"bob's path":  {newTestFileTransferPayload(t, alice, "file000000000002", bob.address), errPathTaken},
+	} {
+		if err := newTestBlockAt(t, vm, test.data, time.Now()).Verify(); err != test.expected {
+			t.Fatalf("expected %s for %s but got %v", test.expected, name, err)
+		}
+	}
+
+	// Manifests from before blocks had a tx root could take any path
+	rootless := &rootlessBlock{
+		Block:   core.NewBlock(taken.ID(), taken.Height()+1, time.Now().Unix()),
+		Data:    newTestManifestPayload(t, alice, "file000000000005", "/readme.txt", "text/plain", "taken", []ids.ID{taken.ID()}),
+		BaseFee: taken.getNextBaseFee(),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, rootless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.(*Block).verifySetPath(taken); err != nil {
+		t.Fatalf("expected a rootless manifest to be able to take a path but got %v", err)
+	}
+
+	if names := fmt.Sprint(listTestDirectory(t, vm, alice.address, "/")); names != "[projects/ readme.txt]" {
+		t.Fatalf("unexpected root directory %s", names)
+	}
+	if names := fmt.Sprint(listTestDirectory(t, vm, alice.address, "/projects")); names != "[2026/ notes.txt]" {
+		t.Fatalf("unexpected /projects directory %s", names)
+	}
+	page := &ListDirectoryReply{}
+	if err := (&Service{vm}).ListDirectory(nil, &ListDirectoryArgs{Owner: alice.address, Limit: 1}, page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || !page.Entries[0].Directory || page.Entries[0].Path != "/projects" || page.NextCursor != "projects/" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	if err := (&Service{vm}).ListDirectory(nil, &ListDirectoryArgs{Owner: alice.address, Cursor: page.NextCursor}, page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].FileID != "file000000000003" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+
+	// Renames move the file, transfers move it to the recipient's tree and
+	// deletions take it out of the tree
+	acceptTestPayload(t, vm, newTestRenamePayload(t, alice, "file000000000003", "/projects/readme.txt"))
+	acceptTestPayload(t, vm, newTestFileTransferPayload(t, alice, "file000000000001", bob.address))
+	if names := fmt.Sprint(listTestDirectory(t, vm, alice.address, "/projects")); names != "[notes.txt readme.txt]" {
+		t.Fatalf("unexpected /projects directory %s", names)
+	}
+	if names := fmt.Sprint(listTestDirectory(t, vm, bob.address, "/projects/2026")); names != "[report.pdf]" {
+		t.Fatalf("unexpected bob's /projects/2026 directory %s", names)
+	}
+	acceptTestPayload(t, vm, newTestDeletePayload(t, alice, "file000000000002", 0))
+	if names := fmt.Sprint(listTestDirectory(t, vm, alice.address, "/")); names != "[projects/]" {
+		t.Fatalf("unexpected root directory %s", names)
+	}
+	acceptTestPayload(t, vm, newTestManifestPayload(t, alice, "file000000000005", "/projects/notes.txt", "text/plain", "taken", []ids.ID{taken.ID()}))
+
+	recorder := httptest.NewRecorder()
+	gateway := &fileGateway{vm}
+	gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/file?owner=%s&path=/projects/2026/report.pdf", bob.address), nil))
+	if body := recorder.Body.String(); body != "report" {
+		t.Fatalf("expected the report's content but got %q", body)
+	}
+
+	// Nodes that accepted the paths before the indexes existed have the
+	// same directory trees
+	for _, db := range []database.Database{vm.pathDB, vm.directoryDB, vm.fileOwnerDB} {
+		iter := db.NewIterator()
+		for iter.Next() {
+			if err := db.Delete(iter.Key()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		iter.Release()
+	}
+	if err := vm.reindexPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if names := fmt.Sprint(listTestDirectory(t, vm, alice.address, "/projects")); names != "[notes.txt readme.txt]" {
+		t.Fatalf("unexpected /projects directory after reindexing %s", names)
+	}
+	if names := fmt.Sprint(listTestDirectory(t, vm, bob.address, "/projects")); names != "[2026/ notes.txt]" {
+		t.Fatalf("unexpected bob's /projects directory after reindexing %s", names)
+	}
+}
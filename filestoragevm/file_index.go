@@ -0,0 +1,298 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+const (
+	// Length of an account address (CB58 public key) in a transaction
+	addressLen = 50
+
+	// Length of a file ID in a transaction
+	fileIDLen = 16
+)
+
+var (
+	fileIndexPrefix      = []byte("file")
+	chunkIndexPrefix     = []byte("chunk")
+	chunkSizeIndexPrefix = []byte("chunkSize")
+
+	errNoSuchFile   = errors.New("there is no such file")
+	errBadAddress   = errors.New("address must be a 50 character CB58 public key")
+	errBadFileID    = errors.New("file ID must be 16 characters")
+	errChunkMissing = errors.New("file is missing a chunk")
+)
+
+// fileRecord is what the file index knows about one of an account's files
+type fileRecord struct {
+	// Number of distinct chunks uploaded
+	ChunkCount uint64 `serialize:"true"`
+
+	// Total size of the uploaded chunks' data
+	Size uint64 `serialize:"true"`
+
+	// ID of the latest manifest for the file, or ids.Empty if there's none
+	ManifestID ids.ID `serialize:"true"`
+}
+
+// fileKey returns the file index key of [owner]'s file [fileID].
+// Keys start with the owner so an owner's files can be iterated in order.
+func fileKey(owner string, fileID string) []byte {
+	key := make([]byte, 0, addressLen+fileIDLen)
+	key = append(key, owner...)
+	return append(key, fileID...)
+}
+
+// chunkKey returns the chunk index key of chunk [chunkNumber] of [owner]'s
+// file [fileID]. Keys start with the file key, and the chunk number is big
+// endian, so a file's chunks can be iterated in order.
+func chunkKey(owner string, fileID string, chunkNumber uint64) []byte {
+	return append(fileKey(owner, fileID), database.PackUInt64(chunkNumber)...)
+}
+
+// verifyFileArgs returns an error if [owner] or [fileID] can't appear in a
+// transaction
+func verifyFileArgs(owner string, fileID string) error {
+	if len(owner) != addressLen {
+		return errBadAddress
+	}
+	if len(fileID) != fileIDLen {
+		return errBadFileID
+	}
+	return nil
+}
+
+// hasChunk returns true iff chunk [chunkNumber] of [creator]'s file
+// [fileID] was uploaded in this block or one of its ancestors
+func (b *Block) hasChunk(creator string, fileID string, chunkNumber int64) (bool, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return false, err
+	}
+	for _, block := range ancestry {
+		if !block.isUploadBlock() || block.getUploadChunkNumber() != chunkNumber {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil || isOfFile {
+			return isOfFile, err
+		}
+	}
+	return b.vm.chunkDB.Has(chunkKey(creator, fileID, uint64(chunkNumber)))
+}
+
+// verifyNewChunk returns nil iff this upload's chunk hasn't been uploaded to
+// [creator]'s file yet, as of [parent]. Blocks from before base fees were in
+// the header were accepted before duplicate chunks were rejected, so they
+// can repeat a chunk.
+func (b *Block) verifyNewChunk(parent *Block, creator string) error {
+	if b.legacy {
+		return nil
+	}
+	hasChunk, err := parent.hasChunk(creator, b.getUploadFileID(), b.getUploadChunkNumber())
+	if err != nil {
+		return err
+	}
+	if hasChunk {
+		return errDuplicateChunk
+	}
+	return nil
+}
+
+// getChunkNumbers returns the numbers of the accepted chunks of [owner]'s
+// file [fileID], in order
+func (vm *VM) getChunkNumbers(owner string, fileID string) ([]uint64, error) {
+	iter := vm.chunkDB.NewIteratorWithPrefix(fileKey(owner, fileID))
+	defer iter.Release()
+
+	chunkNumbers := []uint64{}
+	for iter.Next() {
+		chunkNumber, err := database.ParseUInt64(iter.Key()[addressLen+fileIDLen:])
+		if err != nil {
+			return nil, err
+		}
+		chunkNumbers = append(chunkNumbers, chunkNumber)
+	}
+	return chunkNumbers, iter.Error()
+}
+
+// getFileRecord returns the file index's record of [owner]'s file [fileID]
+func (vm *VM) getFileRecord(owner string, fileID string) (*fileRecord, error) {
+	bytes, err := vm.fileDB.Get(fileKey(owner, fileID))
+	if err == database.ErrNotFound {
+		return nil, errNoSuchFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &fileRecord{}
+	_, err = vm.codec.Unmarshal(bytes, record)
+	return record, err
+}
+
+// putFileRecord saves [record] as the record of [owner]'s file [fileID]
+func (vm *VM) putFileRecord(owner string, fileID string, record *fileRecord) error {
+	bytes, err := vm.codec.Marshal(codecVersion, record)
+	if err != nil {
+		return err
+	}
+	return vm.fileDB.Put(fileKey(owner, fileID), bytes)
+}
+
+// listFiles returns up to [limit] of the file IDs of [owner]'s files, in
+// order, starting after [cursor]. If [cursor] is empty, starts from the
+// first file. That includes files it was given, but not ones it gave away.
+func (vm *VM) listFiles(owner string, cursor string, limit int) ([]string, error) {
+	start := fileKey(owner, cursor)
+	iter := vm.ownerDB.NewIteratorWithStartAndPrefix(start, []byte(owner))
+	defer iter.Release()
+
+	fileIDs := []string{}
+	for len(fileIDs) < limit && iter.Next() {
+		fileID := string(iter.Key()[addressLen:])
+		if fileID == cursor {
+			continue
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	return fileIDs, iter.Error()
+}
+
+// getFileChunks returns the upload blocks holding [creator]'s file [fileID],
+// in order. If the file has a manifest, its chunks are the ones it lists.
+// Otherwise they're the indexed uploads, which may have gaps.
+func (vm *VM) getFileChunks(creator string, fileID string, record *fileRecord) ([]*Block, error) {
+	var chunkIDs []ids.ID
+	if record.ManifestID != ids.Empty {
+		manifest, err := vm.getBlock(record.ManifestID)
+		if err != nil {
+			return nil, err
+		}
+		if chunkIDs, err = manifest.getManifestChunkIDs(); err != nil {
+			return nil, err
+		}
+	} else {
+		iter := vm.chunkDB.NewIteratorWithPrefix(fileKey(creator, fileID))
+		for iter.Next() {
+			chunkID, err := ids.ToID(iter.Value())
+			if err != nil {
+				iter.Release()
+				return nil, err
+			}
+			chunkIDs = append(chunkIDs, chunkID)
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+
+	return vm.getBlocks(chunkIDs)
+}
+
+// getBlocks returns the blocks with IDs [blockIDs]
+func (vm *VM) getBlocks(blockIDs []ids.ID) ([]*Block, error) {
+	blocks := make([]*Block, len(blockIDs))
+	for i, blockID := range blockIDs {
+		block, err := vm.getBlock(blockID)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+	return blocks, nil
+}
+
+// getFileContent returns the data of [chunks] concatenated. Unless they're
+// the chunks a manifest lists, they must be numbered 0, 1, 2...
+func getFileContent(chunks []*Block, listed bool) ([]byte, error) {
+	reader, err := newChunkReader(chunks, listed)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// indexFile updates the file index with the accepted block [b]
+func (vm *VM) indexFile(b *Block) error {
+	if b.isUploadBlock() {
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		fileID := b.getUploadFileID()
+		record, err := vm.getFileRecord(creator, fileID)
+		if err == errNoSuchFile {
+			// This upload creates the file, so its creator owns it
+			record = &fileRecord{}
+			if err := vm.ownerDB.Put(fileKey(creator, fileID), []byte(creator)); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		// Duplicate chunks are rejected now, but legacy blocks can have a
+		// chunk uploaded twice. The later one replaces the other.
+		key := chunkKey(creator, fileID, uint64(b.getUploadChunkNumber()))
+		oldChunkID, err := database.GetID(vm.chunkDB, key)
+		switch err {
+		case nil:
+			oldSize, err := vm.getChunkSize(key, oldChunkID)
+			if err != nil {
+				return err
+			}
+			record.Size -= oldSize
+		case database.ErrNotFound:
+			record.ChunkCount++
+		default:
+			return err
+		}
+		size := uint64(len(b.getUploadChunk()))
+		record.Size += size
+
+		if err := database.PutID(vm.chunkDB, key, b.ID()); err != nil {
+			return err
+		}
+		if err := database.PutUInt64(vm.chunkSizeDB, key, size); err != nil {
+			return err
+		}
+		return vm.putFileRecord(creator, fileID, record)
+	} else if b.isManifestBlock() {
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		fileID := b.getManifestFileID()
+		record, err := vm.getFileRecord(creator, fileID)
+		if err != nil {
+			return err
+		}
+		record.ManifestID = b.ID()
+		return vm.putFileRecord(creator, fileID, record)
+	}
+	return nil
+}
+
+// getChunkSize returns the size of the data of the chunk with chunk index
+// key [key], which was uploaded in [chunkID], as of when it was indexed.
+// Once the chunk is pruned its data is gone, so the size is kept in the
+// index. Chunks indexed before sizes were kept have it worked out from the
+// block.
+func (vm *VM) getChunkSize(key []byte, chunkID ids.ID) (uint64, error) {
+	size, err := database.GetUInt64(vm.chunkSizeDB, key)
+	if err != database.ErrNotFound {
+		return size, err
+	}
+	chunk, err := vm.getBlock(chunkID)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(chunk.getUploadChunk())), nil
+}
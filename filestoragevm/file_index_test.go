@@ -0,0 +1,231 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+func TestListFiles(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	other := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, other, 10, other.address))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000002", 0, "b"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "a"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, other, "file000000000003", 0, "c"))
+
+	service := Service{vm}
+	reply := ListFilesReply{}
+	if err := service.ListFiles(nil, &ListFilesArgs{Owner: key.address, Limit: 1}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Files) != 1 || reply.Files[0].FileID != "file000000000001" {
+		t.Fatalf("expected file000000000001 first but got %+v", reply.Files)
+	}
+	if reply.NextCursor != "file000000000001" {
+		t.Fatalf("expected a cursor after file000000000001 but got %q", reply.NextCursor)
+	}
+
+	cursor := reply.NextCursor
+	reply = ListFilesReply{}
+	if err := service.ListFiles(nil, &ListFilesArgs{Owner: key.address, Cursor: cursor}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Files) != 1 || reply.Files[0].FileID != "file000000000002" {
+		t.Fatalf("expected only file000000000002 but got %+v", reply.Files)
+	}
+	if reply.NextCursor != "" {
+		t.Fatalf("expected no more pages but got cursor %q", reply.NextCursor)
+	}
+
+	if err := service.ListFiles(nil, &ListFilesArgs{Owner: "nobody"}, &reply); err != errBadAddress {
+		t.Fatalf("expected %s but got %v", errBadAddress, err)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	chunk1 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 1, "world"))
+
+	service := Service{vm}
+	reply := GetFileReply{}
+	args := &GetFileArgs{Owner: key.address, FileID: fileID, IncludeContent: true, Encoding: formatting.Hex}
+	if err := service.GetFile(nil, args, &reply); err != errChunkMissing {
+		t.Fatalf("expected %s but got %v", errChunkMissing, err)
+	}
+
+	chunk0 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, "hello "))
+	if err := service.GetFile(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.File.ChunkCount != 2 || reply.File.Size != 11 {
+		t.Fatalf("expected 2 chunks of 11 bytes but got %+v", reply.File)
+	}
+	if len(reply.Chunks) != 2 || reply.Chunks[0].BlockID != chunk0.ID().String() || reply.Chunks[1].BlockID != chunk1.ID().String() {
+		t.Fatalf("unexpected chunks %+v", reply.Chunks)
+	}
+	content, err := formatting.Decode(formatting.Hex, reply.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" {
+		t.Fatalf("expected content %q but got %q", "hello world", content)
+	}
+
+	manifest := acceptTestPayload(t, vm, newTestManifestPayload(t, key, fileID, "hello.txt", "text/plain", "hello world", []ids.ID{chunk0.ID(), chunk1.ID()}))
+	if err := service.GetFile(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.File.ManifestID != manifest.ID().String() || reply.File.FileName != "hello.txt" || reply.File.MimeType != "text/plain" {
+		t.Fatalf("expected the manifest's metadata but got %+v", reply.File)
+	}
+
+	args.FileID = "file000000000002"
+	if err := service.GetFile(nil, args, &reply); err != errNoSuchFile {
+		t.Fatalf("expected %s but got %v", errNoSuchFile, err)
+	}
+}
+
+func TestDuplicateChunk(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	accepted := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, "hello "))
+
+	// Chunk 0 is in the index
+	duplicate, err := vm.NewBlock(accepted.ID(), accepted.Height()+1, newTestUploadPayload(t, key, fileID, 0, "howdy "), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := duplicate.Verify(); err != errDuplicateChunk {
+		t.Fatalf("expected %s but got %v", errDuplicateChunk, err)
+	}
+
+	// Chunk 1 is in a block that's verified but not accepted yet
+	processing, err := vm.NewBlock(accepted.ID(), accepted.Height()+1, newTestUploadPayload(t, key, fileID, 1, "world"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err = vm.NewBlock(processing.ID(), processing.Height()+1, newTestUploadPayload(t, key, fileID, 1, "world"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := duplicate.Verify(); err != errDuplicateChunk {
+		t.Fatalf("expected %s but got %v", errDuplicateChunk, err)
+	}
+
+	// Another account can use the same file ID and chunk number
+	other := newTestKey(t)
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, other, 10, other.address))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, other, fileID, 0, "hello "))
+
+	// Legacy blocks are from before duplicate chunks were rejected
+	legacy := &legacyBlock{
+		Block: core.NewBlock(accepted.ID(), accepted.Height()+1, time.Now().Unix()),
+		Data:  newTestUploadPayload(t, key, fileID, 0, "howdy "),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.(*Block).verifyNewChunk(accepted, key.address); err != nil {
+		t.Fatalf("expected a legacy block to be able to repeat a chunk but got %v", err)
+	}
+
+	// The chunk it replaces takes its size out of the file's, even once
+	// its data was pruned
+	if err := vm.pruneBlock(accepted); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.indexFile(parsed.(*Block)); err != nil {
+		t.Fatal(err)
+	}
+	record, err := vm.getFileRecord(key.address, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ChunkCount != 1 || record.Size != uint64(len("howdy ")) {
+		t.Fatalf("expected 1 chunk of %d bytes but got %+v", len("howdy "), record)
+	}
+}
+
+func TestGetUploadProgress(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+
+	service := Service{vm}
+	args := &GetUploadProgressArgs{Owner: key.address, FileID: fileID}
+	reply := GetUploadProgressReply{}
+	if err := service.GetUploadProgress(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Uploaded) != 0 || len(reply.Missing) != 0 {
+		t.Fatalf("expected no progress but got %+v", reply)
+	}
+
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, "a"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 3, "d"))
+	reply = GetUploadProgressReply{}
+	if err := service.GetUploadProgress(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(reply.Uploaded) != "[0 3]" || fmt.Sprint(reply.Missing) != "[1 2]" || reply.ChunkCount != 0 {
+		t.Fatalf("expected chunks 0 and 3 uploaded and 1 and 2 missing but got %+v", reply)
+	}
+
+	// Missing chunks are paged, however high the uploader numbered a chunk
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 99999999, "z"))
+	reply = GetUploadProgressReply{}
+	if err := service.GetUploadProgress(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Missing) != maxPageSize || reply.Missing[maxPageSize-1] != maxPageSize+1 || reply.NextCursor != fmt.Sprint(maxPageSize+1) {
+		t.Fatalf("expected a page of %d missing chunks but got %d, next %q", maxPageSize, len(reply.Missing), reply.NextCursor)
+	}
+	args.Cursor, args.Limit = "99999997", 5
+	reply = GetUploadProgressReply{}
+	if err := service.GetUploadProgress(nil, args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(reply.Missing) != "[99999998]" || reply.NextCursor != "" {
+		t.Fatalf("expected the last missing chunk but got %v, next %q", reply.Missing, reply.NextCursor)
+	}
+	args.Cursor = "x"
+	if err := service.GetUploadProgress(nil, args, &reply); err != errBadChunkCursor {
+		t.Fatalf("expected %s but got %v", errBadChunkCursor, err)
+	}
+}
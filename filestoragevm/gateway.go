@@ -0,0 +1,213 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+var (
+	errBadWhence      = errors.New("invalid whence")
+	errNegativeOffset = errors.New("negative offset")
+
+	_ http.Handler  = &fileGateway{}
+	_ io.ReadSeeker = &chunkReader{}
+)
+
+// fileGateway serves the content of files in the file index over plain HTTP:
+//   GET /file?owner=<address>&fileID=<file ID>[&version=<version>]
+//   GET /file?owner=<address>&path=<path>[&version=<version>]
+// Range requests and conditional requests (ETag) are supported. Files that
+// have expired or were deleted are 410 Gone.
+type fileGateway struct{ vm *VM }
+
+func (g *fileGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	owner, fileID := r.URL.Query().Get("owner"), r.URL.Query().Get("fileID")
+	if path := r.URL.Query().Get("path"); path != "" && fileID == "" {
+		if err := verifyPath(path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if fileID, err = g.vm.getAcceptedFileIDAt(owner, path); err == errNoSuchFile {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := verifyFileArgs(owner, fileID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Files that were given away are served by their new owner
+	creator, err := g.vm.getAcceptedFileCreatorOf(owner, fileID)
+	if err == errFileTransferred {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	record, err := g.vm.getFileRecord(creator, fileID)
+	if err == errNoSuchFile {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if versionParam := r.URL.Query().Get("version"); versionParam != "" {
+		version, err := strconv.ParseUint(versionParam, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record, err = g.vm.getVersionRecord(creator, fileID, record, version)
+		if err == errNoSuchVersion {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// The file's data may have been pruned, and even if this node still
+	// has it, it's no longer paid for or its owner withdrew it
+	deleted, err := g.vm.isAcceptedFileDeleted(creator, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if deleted {
+		http.Error(w, errFileDeleted.Error(), http.StatusGone)
+		return
+	}
+	expired, err := g.vm.isAcceptedFileExpired(creator, fileID, time.Now().Unix())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if expired {
+		http.Error(w, errFileExpired.Error(), http.StatusGone)
+		return
+	}
+	chunks, err := g.vm.getFileChunks(creator, fileID, record)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reader, err := newChunkReader(chunks, record.ManifestID != ids.Empty)
+	if err == errChunkMissing {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Without a manifest there's no content hash, so the ETag is a hash of
+	// the chunks' IDs, which changes whenever a chunk is replaced
+	name := ""
+	etag := hashChunkIDs(chunks)
+	if record.ManifestID != ids.Empty {
+		manifest, err := g.vm.getBlock(record.ManifestID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name = manifest.getManifestName()
+		etag = manifest.getManifestHash()
+		if mimeType := manifest.getManifestMimeType(); mimeType != "" {
+			w.Header().Set("Content-Type", mimeType)
+		}
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+
+	// ServeContent sets Content-Length, and falls back on [name]'s
+	// extension and then sniffing the content when there's no MIME type
+	http.ServeContent(w, r, name, time.Time{}, reader)
+}
+
+// hashChunkIDs returns the hex encoded SHA-256 of the IDs of [chunks]
+func hashChunkIDs(chunks []*Block) string {
+	hash := sha256.New()
+	for _, chunk := range chunks {
+		id := chunk.ID()
+		hash.Write(id[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// chunkReader reads the data of a file's chunks as one stream, without
+// copying them into one buffer
+type chunkReader struct {
+	chunks [][]byte
+	size   int64
+	offset int64
+}
+
+// newChunkReader returns a reader over the data of [chunks]. Unless
+// they're the chunks a manifest lists, they must be numbered 0, 1, 2...
+func newChunkReader(chunks []*Block, listed bool) (*chunkReader, error) {
+	reader := &chunkReader{chunks: make([][]byte, len(chunks))}
+	for i, chunk := range chunks {
+		if !listed && chunk.getUploadChunkNumber() != int64(i) {
+			return nil, errChunkMissing
+		}
+		reader.chunks[i] = chunk.getUploadChunk()
+		reader.size += int64(len(reader.chunks[i]))
+	}
+	return reader, nil
+}
+
+// Read implements io.Reader
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	start := int64(0)
+	for _, chunk := range r.chunks {
+		end := start + int64(len(chunk))
+		if r.offset < end && n < len(p) {
+			copied := copy(p[n:], chunk[r.offset-start:])
+			n += copied
+			r.offset += int64(copied)
+		}
+		start = end
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errBadWhence
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	r.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,102 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+func TestFileGateway(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	chunk0 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, "hello "))
+	chunk1 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 1, "world"))
+	acceptTestPayload(t, vm, newTestManifestPayload(t, key, fileID, "hello.txt", "text/plain", "hello world", []ids.ID{chunk0.ID(), chunk1.ID()}))
+
+	gateway := &fileGateway{vm}
+	url := fmt.Sprintf("/file?owner=%s&fileID=%s", key.address, fileID)
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte("hello world")))
+
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"whole file", "", "", http.StatusOK, "hello world"},
+		{"range across chunks", "Range", "bytes=4-7", http.StatusPartialContent, "o wo"},
+		{"suffix range", "Range", "bytes=-5", http.StatusPartialContent, "world"},
+		{"unsatisfiable range", "Range", "bytes=20-30", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"etag matches", "If-None-Match", etag, http.StatusNotModified, ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		if test.header != "" {
+			request.Header.Set(test.header, test.value)
+		}
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, request)
+		response := recorder.Result()
+		if response.StatusCode != test.expectedStatus {
+			t.Fatalf("%s: expected status %d but got %d", test.name, test.expectedStatus, response.StatusCode)
+		}
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+			continue
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.expectedBody {
+			t.Fatalf("%s: expected body %q but got %q", test.name, test.expectedBody, body)
+		}
+		if length := response.Header.Get("Content-Length"); length != fmt.Sprint(len(test.expectedBody)) {
+			t.Fatalf("%s: expected Content-Length %d but got %s", test.name, len(test.expectedBody), length)
+		}
+		if contentType := response.Header.Get("Content-Type"); contentType != "text/plain" {
+			t.Fatalf("%s: expected Content-Type text/plain but got %s", test.name, contentType)
+		}
+		if response.Header.Get("ETag") != etag {
+			t.Fatalf("%s: expected ETag %s but got %s", test.name, etag, response.Header.Get("ETag"))
+		}
+	}
+}
+
+func TestFileGatewayErrors(t *testing.T) {
+	vm, _, _ := newTestVM(t)
+	key := newTestKey(t)
+	gateway := &fileGateway{vm}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{"no such file", http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=file000000000001", key.address), http.StatusNotFound},
+		{"bad file ID", http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=short", key.address), http.StatusBadRequest},
+		{"no owner", http.MethodGet, "/file?fileID=file000000000001", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/file", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, httptest.NewRequest(test.method, test.url, nil))
+		if recorder.Code != test.expectedStatus {
+			t.Fatalf("%s: expected status %d but got %d", test.name, test.expectedStatus, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Seconds files are kept for after their first upload, unless the genesis
+// data says otherwise: 30 days
+const defaultUploadRetention = 30 * secondsPerDay
+
+// genesisParams are the chain's parameters, read from the genesis block's
+// data. The genesis data can be anything, so if it isn't a JSON object the
+// defaults are used. A JSON object can leave out any of the fields, e.g.
+//
+//	{"baseFee": 1, "feePerByte": 1, "targetBlockSize": 1000}
+type genesisParams struct {
+	// Fee for every upload, chunk reference and manifest, whatever its
+	// size, in the first block. After that each block's base fee is in its
+	// header, and this is the lowest it can go.
+	BaseFee uint64 `json:"baseFee"`
+
+	// Fee for each byte of content in an upload, chunk reference or manifest
+	FeePerByte uint64 `json:"feePerByte"`
+
+	// Content length of a block that leaves the next block's base fee
+	// unchanged. Above it the base fee goes up, below it the base fee goes
+	// down.
+	TargetBlockSize uint64 `json:"targetBlockSize"`
+
+	// The base fee changes by at most 1/[BaseFeeChangeDenominator] from
+	// one block to the next
+	BaseFeeChangeDenominator uint64 `json:"baseFeeChangeDenominator"`
+
+	// Rent for keeping 1 KB of a file for a day. If it's 0, files never
+	// expire and can't be renewed.
+	RentPerKBDay uint64 `json:"rentPerKBDay"`
+
+	// Seconds a file is kept for after its first upload, which the upload
+	// fee pays for. Renewals extend it.
+	UploadRetention uint64 `json:"uploadRetention"`
+
+	// Percentage of the rent for a deleted file's remaining days that's
+	// refunded, up to the rent its renewals paid
+	RentRefundPercent uint64 `json:"rentRefundPercent"`
+}
+
+// defaultGenesisParams are the parameters of chains whose genesis data
+// doesn't set them. They price every upload at 1 token, which is what
+// uploads always cost before prices were configurable. No block's content
+// can be bigger than the target, so the base fee never goes up. There's no
+// rent, so files are kept forever, like they always were.
+func defaultGenesisParams() genesisParams {
+	return genesisParams{
+		BaseFee:                  1,
+		FeePerByte:               0,
+		TargetBlockSize:          maxContentLen,
+		BaseFeeChangeDenominator: 8,
+		RentPerKBDay:             0,
+		UploadRetention:          defaultUploadRetention,
+		RentRefundPercent:        0,
+	}
+}
+
+// parseGenesisParams returns the parameters set by the genesis block's
+// data [data]
+func parseGenesisParams(data []byte) genesisParams {
+	params := defaultGenesisParams()
+	// The genesis data was copied into a block, so it's padded with zeros
+	data = bytes.TrimRight(data, "\x00")
+	if err := json.Unmarshal(data, &params); err != nil {
+		return defaultGenesisParams()
+	}
+	if params.TargetBlockSize == 0 {
+		params.TargetBlockSize = maxContentLen
+	}
+	if params.BaseFeeChangeDenominator == 0 {
+		params.BaseFeeChangeDenominator = 8
+	}
+	if params.RentRefundPercent > 100 {
+		params.RentRefundPercent = 100
+	}
+	return params
+}
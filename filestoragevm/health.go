@@ -0,0 +1,205 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/snow/choices"
+	"github.com/ava-labs/avalanchego/vms/platformvm"
+)
+
+const (
+	// defaultStallTimeout is how long the chain can go without accepting a
+	// block, while it has data waiting, before it's stalled, unless the
+	// config says otherwise
+	defaultStallTimeout = 5 * time.Minute
+
+	// validatorHealthTimeout is how long a check of the P-chain waits on
+	// it. The checks run in the background, so health checks don't.
+	validatorHealthTimeout = 2 * time.Second
+
+	// maxIndexLag is the most accepted blocks the health check looks back
+	// through for ones missing from the indexes
+	maxIndexLag = 32
+)
+
+var errUnhealthyIndex = errors.New("accepted blocks are missing from the indexes")
+
+// health is what HealthCheck reports about this node's view of the chain.
+// avalanchego serves it from its health API.
+type health struct {
+	DatabaseReachable bool   `json:"databaseReachable"`
+	DatabaseError     string `json:"databaseError,omitempty"`
+
+	LastAcceptedID     string `json:"lastAcceptedID"`
+	LastAcceptedHeight uint64 `json:"lastAcceptedHeight"`
+
+	// Time since the last accepted block was built
+	LastAcceptedAge string `json:"lastAcceptedAge"`
+
+	// Pieces of data waiting to be put in a block, and how long they've
+	// been waiting
+	MempoolSize int    `json:"mempoolSize"`
+	PendingAge  string `json:"pendingAge,omitempty"`
+
+	// Accepted blocks that aren't in the indexes yet, up to maxIndexLag.
+	// This should always be 0, since blocks are indexed as they're
+	// accepted.
+	IndexLag uint64 `json:"indexLag"`
+
+	// Whether the P-chain lookups that staking rewards depend on worked
+	// the last time they were checked, and when that was. They're checked
+	// in the background, so they're unset until the first check is done.
+	// They don't affect the chain's health otherwise.
+	ValidatorStateReachable bool   `json:"validatorStateReachable"`
+	ValidatorStateError     string `json:"validatorStateError,omitempty"`
+	ValidatorStateChecked   string `json:"validatorStateChecked,omitempty"`
+	PChainHeight            uint64 `json:"pChainHeight,omitempty"`
+}
+
+// HealthCheck implements the common.VM interface. It returns an error if
+// the database can't be read, accepted blocks are missing from the indexes,
+// or the chain is stalled: it has data waiting to be put in a block, or a
+// block waiting to be decided, and hasn't accepted one for longer than the
+// stall timeout.
+func (vm *VM) HealthCheck() (interface{}, error) {
+	details := &health{MempoolSize: len(vm.mempool)}
+	vm.validatorProbe.report(details)
+	vm.validatorProbe.start()
+
+	// The genesis block is always in the height index
+	if _, err := vm.heightDB.Has(database.PackUInt64(0)); err != nil {
+		details.DatabaseError = err.Error()
+		return details, fmt.Errorf("couldn't read the database: %w", err)
+	}
+	details.DatabaseReachable = true
+
+	lastAccepted, err := vm.getLastAcceptedBlock()
+	if err != nil {
+		return details, fmt.Errorf("couldn't get the last accepted block: %w", err)
+	}
+	now := time.Now()
+	lastAcceptedAge := now.Sub(lastAccepted.Timestamp())
+	details.LastAcceptedID = lastAccepted.ID().String()
+	details.LastAcceptedHeight = lastAccepted.Height()
+	details.LastAcceptedAge = lastAcceptedAge.Round(time.Second).String()
+
+	if details.IndexLag, err = vm.getIndexLag(lastAccepted); err != nil {
+		return details, fmt.Errorf("couldn't check the indexes: %w", err)
+	}
+	if details.IndexLag > 0 {
+		return details, fmt.Errorf("%w: %d behind", errUnhealthyIndex, details.IndexLag)
+	}
+
+	// Data waiting since before the last accepted block was built isn't
+	// waiting on the chain for longer than the block's age
+	stallTimeout := vm.config.getStallTimeout()
+	if len(vm.mempool) > 0 {
+		pendingAge := now.Sub(vm.pendingSince)
+		details.PendingAge = pendingAge.Round(time.Second).String()
+		if pendingAge > stallTimeout && lastAcceptedAge > stallTimeout {
+			return details, fmt.Errorf("chain is stalled: %d pieces of data have waited %s to be put in a block", len(vm.mempool), details.PendingAge)
+		}
+	}
+	preferred, err := vm.getBlock(vm.Preferred())
+	if err != nil {
+		return details, fmt.Errorf("couldn't get the preferred block: %w", err)
+	}
+	if preferred.Status() != choices.Accepted && now.Sub(preferred.Timestamp()) > stallTimeout && lastAcceptedAge > stallTimeout {
+		return details, fmt.Errorf("chain is stalled: block %s has waited %s to be decided", preferred.ID(), now.Sub(preferred.Timestamp()).Round(time.Second))
+	}
+	return details, nil
+}
+
+// getIndexLag returns how many accepted blocks, counting back from
+// [lastAccepted], are missing from the height index, up to maxIndexLag
+func (vm *VM) getIndexLag(lastAccepted *Block) (uint64, error) {
+	lag := uint64(0)
+	for block := lastAccepted; lag < maxIndexLag; lag++ {
+		id, err := vm.getBlockIDAtHeight(block.Height())
+		if err == nil && id == block.ID() {
+			return lag, nil
+		}
+		if err != nil && err != errNoBlockAtHeight {
+			return 0, err
+		}
+		if block.Height() == 0 {
+			return lag + 1, nil
+		}
+		if block, err = block.getParent(); err != nil {
+			return 0, err
+		}
+	}
+	return lag, nil
+}
+
+// validatorProbe checks in the background whether the P-chain that the
+// validator lookups for staking rely on is reachable, and keeps the result
+// of the last check
+type validatorProbe struct {
+	lock sync.Mutex
+
+	// Whether a check is running
+	running bool
+
+	reachable bool
+	err       string
+	checked   time.Time
+	height    uint64
+
+	checks sync.WaitGroup
+}
+
+// start starts a check, unless one is running already
+func (p *validatorProbe) start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running {
+		return
+	}
+	p.running = true
+	p.checks.Add(1)
+	go p.check()
+}
+
+// check asks the P-chain for its height, and records the result
+func (p *validatorProbe) check() {
+	defer p.checks.Done()
+	height, err := platformvm.NewClient(pChainURI, validatorHealthTimeout).GetHeight()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.running = false
+	p.checked = time.Now()
+	p.reachable = err == nil
+	p.err, p.height = "", 0
+	if err != nil {
+		p.err = err.Error()
+		return
+	}
+	p.height = uint64(height)
+}
+
+// report records in [details] the result of the last check
+func (p *validatorProbe) report(details *health) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.checked.IsZero() {
+		return
+	}
+	details.ValidatorStateReachable = p.reachable
+	details.ValidatorStateError = p.err
+	details.ValidatorStateChecked = p.checked.Format(time.RFC3339)
+	details.PChainHeight = p.height
+}
+
+// wait waits for the running check, if any, to finish
+func (p *validatorProbe) wait() {
+	p.checks.Wait()
+}
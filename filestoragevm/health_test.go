@@ -0,0 +1,117 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+func TestHealthCheck(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	// The chain's last block is older than the stall timeout, but it has
+	// nothing to do, so it isn't stalled
+	old := time.Now().Add(-2 * defaultStallTimeout)
+	last := acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 10, key.address), old)
+	detailsIntf, err := vm.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := detailsIntf.(*health)
+	if !details.DatabaseReachable || details.LastAcceptedID != last.ID().String() || details.LastAcceptedHeight != 1 || details.MempoolSize != 0 || details.IndexLag != 0 {
+		t.Fatalf("expected a healthy chain at %s but got %+v", last.ID(), details)
+	}
+
+	// Data that just arrived isn't stalled, but data that's waited longer
+	// than the stall timeout is
+	vm.proposeBlock(newTestFaucetPayload(t, key, 1, key.address))
+	if detailsIntf, err = vm.HealthCheck(); err != nil {
+		t.Fatalf("expected fresh data not to stall the chain but got %v", err)
+	}
+	if details := detailsIntf.(*health); details.MempoolSize != 1 {
+		t.Fatalf("expected 1 piece of data in the mempool but got %+v", details)
+	}
+	vm.pendingSince = old
+	if _, err := vm.HealthCheck(); err == nil {
+		t.Fatal("expected the chain to be stalled")
+	}
+	vm.mempool = nil
+
+	// So is a block that's waited to be decided for that long
+	processing := newTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), old)
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.SetPreference(processing.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.HealthCheck(); err == nil {
+		t.Fatal("expected the chain to be stalled on the processing block")
+	}
+	if err := vm.SetPreference(last.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Accepted blocks missing from the indexes make the node unhealthy
+	if err := vm.heightDB.Delete(database.PackUInt64(last.Height())); err != nil {
+		t.Fatal(err)
+	}
+	detailsIntf, err = vm.HealthCheck()
+	if !errors.Is(err, errUnhealthyIndex) || detailsIntf.(*health).IndexLag != 1 {
+		t.Fatalf("expected an index lag of 1 but got %+v, %v", detailsIntf, err)
+	}
+}
+
+func TestIndexLagLimit(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	var last *Block
+	for i := 0; i <= maxIndexLag; i++ {
+		last = acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 1, key.address))
+	}
+	// Every block is missing from the height index, but the health check
+	// only looks so far back
+	for height := uint64(0); height <= last.Height(); height++ {
+		if err := vm.heightDB.Delete(database.PackUInt64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lag, err := vm.getIndexLag(last); err != nil || lag != maxIndexLag {
+		t.Fatalf("expected an index lag of %d but got %d (%v)", maxIndexLag, lag, err)
+	}
+}
+
+func TestValidatorProbe(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	// The P-chain is checked in the background, so the first health check
+	// doesn't have a result yet
+	detailsIntf, err := vm.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details := detailsIntf.(*health); details.ValidatorStateChecked != "" {
+		t.Fatalf("expected the P-chain not to have been checked yet but got %+v", details)
+	}
+	vm.validatorProbe.wait()
+	if detailsIntf, err = vm.HealthCheck(); err != nil {
+		t.Fatal(err)
+	}
+	details := detailsIntf.(*health)
+	if details.ValidatorStateChecked == "" || details.ValidatorStateReachable == (details.ValidatorStateError != "") {
+		t.Fatalf("expected the last check's result but got %+v", details)
+	}
+}
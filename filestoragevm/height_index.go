@@ -0,0 +1,68 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+var (
+	heightIndexPrefix = []byte("height")
+
+	errNoBlockAtHeight = errors.New("there is no accepted block at that height")
+)
+
+// putBlockIDAtHeight records that the accepted block at [height] is [id]
+func (vm *VM) putBlockIDAtHeight(height uint64, id ids.ID) error {
+	return database.PutID(vm.heightDB, database.PackUInt64(height), id)
+}
+
+// getBlockIDAtHeight returns the ID of the accepted block at [height]
+func (vm *VM) getBlockIDAtHeight(height uint64) (ids.ID, error) {
+	id, err := database.GetID(vm.heightDB, database.PackUInt64(height))
+	if err == database.ErrNotFound {
+		return ids.Empty, errNoBlockAtHeight
+	}
+	return id, err
+}
+
+// getBlockAtHeight returns the accepted block at [height]
+func (vm *VM) getBlockAtHeight(height uint64) (*Block, error) {
+	id, err := vm.getBlockIDAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return vm.getBlock(id)
+}
+
+// getGenesisBlock returns the genesis block. Chains accepted before the
+// height index existed don't have it in the index until they're reindexed,
+// so it falls back to walking back from the last accepted block.
+func (vm *VM) getGenesisBlock() (*Block, error) {
+	block, err := vm.getBlockAtHeight(0)
+	if err != errNoBlockAtHeight {
+		return block, err
+	}
+	if block, err = vm.getLastAcceptedBlock(); err != nil {
+		return nil, err
+	}
+	for block.Height() > 0 {
+		if block, err = block.getParent(); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// getLastAcceptedBlock returns the last accepted block
+func (vm *VM) getLastAcceptedBlock() (*Block, error) {
+	id, err := vm.LastAccepted()
+	if err != nil {
+		return nil, err
+	}
+	return vm.getBlock(id)
+}
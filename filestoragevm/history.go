@@ -0,0 +1,285 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+var (
+	fileHistoryIndexPrefix        = []byte("fileHistory")
+	fileCreatorHistoryIndexPrefix = []byte("fileCreatorHistory")
+	stakeHistoryIndexPrefix       = []byte("stakeHistory")
+
+	// Key in the file history index marking that the history indexes were
+	// backfilled. Its keys are otherwise longer, so it can't be one.
+	historyIndexedKey = []byte("indexed")
+
+	errNotAccepted = errors.New("files can only be looked up as of an accepted block")
+)
+
+// The state indexes only know the state as of the last accepted block. To
+// answer queries as of an older one, the history indexes record, by
+// height:
+//   - the accepted blocks about each file, so its state can be replayed
+//   - the creator of the file each account has under each file ID, since
+//     transfers and file access change it
+//   - the stakes each account earns the rewards of
+
+// historyKey returns the key of the entry at [height] in a history index,
+// under [prefix]. Heights are big endian, so entries iterate oldest first.
+func historyKey(prefix []byte, height uint64) []byte {
+	key := make([]byte, 0, len(prefix)+8)
+	key = append(key, prefix...)
+	return append(key, database.PackUInt64(height)...)
+}
+
+// indexHistory updates the history indexes with the accepted block [b]
+func (vm *VM) indexHistory(b *Block) error {
+	id := b.ID()
+	if b.isStakeBlock() {
+		if err := vm.stakeHistoryDB.Put(historyKey([]byte(b.getStakeRewardAddress()), b.Height()), id[:]); err != nil {
+			return err
+		}
+	}
+	fileID := b.getFileID()
+	if fileID == "" {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	if err := vm.fileHistoryDB.Put(historyKey(fileKey(creator, fileID), b.Height()), id[:]); err != nil {
+		return err
+	}
+	switch {
+	case b.isFileTransferBlock():
+		// An empty creator means the account gave the file away
+		if err := vm.fileCreatorHistoryDB.Put(historyKey(fileKey(b.getSigner(), fileID), b.Height()), nil); err != nil {
+			return err
+		}
+		return vm.fileCreatorHistoryDB.Put(historyKey(fileKey(b.getFileTransferRecipient(), fileID), b.Height()), []byte(creator))
+	case b.isFileAccessBlock():
+		// Once its access is revoked, the account's file ID is its own again
+		account := b.getFileAccessAccount()
+		if b.getFileAccessRole() == roleNone {
+			creator = account
+		}
+		return vm.fileCreatorHistoryDB.Put(historyKey(fileKey(account, fileID), b.Height()), []byte(creator))
+	}
+	return nil
+}
+
+// reindexHistory adds the blocks accepted before the history indexes
+// existed to them
+func (vm *VM) reindexHistory() error {
+	indexed, err := vm.fileHistoryDB.Has(historyIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	blocks, err := vm.findAccepted(func(b *Block) bool {
+		return b.isStakeBlock() || b.getFileID() != ""
+	})
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := vm.indexHistory(block); err != nil {
+			return err
+		}
+	}
+	if err := vm.fileHistoryDB.Put(historyIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
+
+// getHistory returns the values of the entries under [prefix] in [db] up to
+// and including [height], oldest first
+func getHistory(db database.Database, prefix []byte, height uint64) ([][]byte, error) {
+	iter := db.NewIteratorWithPrefix(prefix)
+	defer iter.Release()
+
+	values := [][]byte{}
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != len(prefix)+8 {
+			continue
+		}
+		entryHeight, err := database.ParseUInt64(key[len(prefix):])
+		if err != nil {
+			return nil, err
+		}
+		if entryHeight > height {
+			break
+		}
+		values = append(values, append([]byte(nil), iter.Value()...))
+	}
+	return values, iter.Error()
+}
+
+// getFileCreatorAt returns the creator of [account]'s file [fileID] as of
+// the accepted block at [height], like getAcceptedFileCreatorOf
+func (vm *VM) getFileCreatorAt(account string, fileID string, height uint64) (string, error) {
+	creators, err := getHistory(vm.fileCreatorHistoryDB, fileKey(account, fileID), height)
+	if err != nil {
+		return "", err
+	}
+	if len(creators) == 0 {
+		return account, nil
+	}
+	creator := creators[len(creators)-1]
+	if len(creator) == 0 {
+		return "", errFileTransferred
+	}
+	return string(creator), nil
+}
+
+// fileSnapshot is what was known about a file as of an accepted block
+type fileSnapshot struct {
+	owner  string
+	record fileRecord
+
+	// Block ID and size of each uploaded chunk, by chunk number
+	chunkIDs   map[uint64]ids.ID
+	chunkSizes map[uint64]uint64
+
+	// IDs of the manifests of every version, oldest first
+	manifestIDs []ids.ID
+
+	path      string
+	expiry    int64
+	hasExpiry bool
+	deleted   bool
+	roles     map[string]fileRole
+}
+
+// getFileAt returns [creator]'s file [fileID] as of the accepted block at
+// [height], by replaying the blocks about it up to there
+func (vm *VM) getFileAt(creator string, fileID string, height uint64) (*fileSnapshot, error) {
+	blockIDs, err := getHistory(vm.fileHistoryDB, fileKey(creator, fileID), height)
+	if err != nil {
+		return nil, err
+	}
+	file := &fileSnapshot{
+		owner:      creator,
+		chunkIDs:   map[uint64]ids.ID{},
+		chunkSizes: map[uint64]uint64{},
+		roles:      map[string]fileRole{},
+	}
+	for _, blockID := range blockIDs {
+		id, err := ids.ToID(blockID)
+		if err != nil {
+			return nil, err
+		}
+		block, err := vm.getBlock(id)
+		if err != nil {
+			return nil, err
+		}
+		file.apply(vm, block)
+	}
+	// A file exists once its first chunk is uploaded
+	if len(file.chunkIDs) == 0 {
+		return nil, errNoSuchFile
+	}
+	return file, nil
+}
+
+// apply updates this file with the accepted block [b] about it, the way
+// the file, version, expiry, deletion, path, owner and access indexes do
+func (f *fileSnapshot) apply(vm *VM, b *Block) {
+	switch {
+	case b.isUploadBlock():
+		chunkNumber := uint64(b.getUploadChunkNumber())
+		if size, ok := f.chunkSizes[chunkNumber]; ok {
+			f.record.Size -= size
+		} else {
+			f.record.ChunkCount++
+		}
+		f.chunkIDs[chunkNumber] = b.ID()
+		f.chunkSizes[chunkNumber] = uint64(len(b.getUploadChunk()))
+		f.record.Size += f.chunkSizes[chunkNumber]
+		if vm.rentEnabled() && !f.hasExpiry {
+			f.expiry, f.hasExpiry = b.getUploadExpiry(), true
+		}
+	case b.isManifestBlock():
+		f.record.ManifestID = b.ID()
+		f.manifestIDs = append(f.manifestIDs, b.ID())
+		if path, setsPath := b.getSetPath(); setsPath {
+			f.path = path
+		}
+	case b.isRenameBlock():
+		if path, setsPath := b.getSetPath(); setsPath {
+			f.path = path
+		}
+	case b.isRenewBlock():
+		f.expiry, f.hasExpiry = f.expiry+b.getRenewDays()*secondsPerDay, true
+	case b.isDeleteBlock():
+		f.deleted, f.path = true, ""
+	case b.isFileTransferBlock():
+		f.owner = b.getFileTransferRecipient()
+	case b.isFileAccessBlock():
+		if role := b.getFileAccessRole(); role == roleNone {
+			delete(f.roles, b.getFileAccessAccount())
+		} else {
+			f.roles[b.getFileAccessAccount()] = role
+		}
+	}
+}
+
+// getChunkIDs returns the IDs of this file's uploaded chunks, ordered by
+// chunk number
+func (f *fileSnapshot) getChunkIDs() []ids.ID {
+	chunkNumbers := make([]uint64, 0, len(f.chunkIDs))
+	for chunkNumber := range f.chunkIDs {
+		chunkNumbers = append(chunkNumbers, chunkNumber)
+	}
+	sort.Slice(chunkNumbers, func(i, j int) bool { return chunkNumbers[i] < chunkNumbers[j] })
+	chunkIDs := make([]ids.ID, len(chunkNumbers))
+	for i, chunkNumber := range chunkNumbers {
+		chunkIDs[i] = f.chunkIDs[chunkNumber]
+	}
+	return chunkIDs
+}
+
+// getStakesAt returns the stakes whose rewards go to [account], as of
+// [block]. That's the accepted ones up to its height, and the ones in its
+// ancestors that are still being processed.
+func (vm *VM) getStakesAt(account string, block *Block) ([]*Block, error) {
+	ancestry, err := block.unacceptedAncestry()
+	if err != nil {
+		return nil, err
+	}
+	height := block.Height()
+	if len(ancestry) > 0 {
+		height = ancestry[len(ancestry)-1].Height() - 1
+	}
+	stakeIDs, err := getHistory(vm.stakeHistoryDB, []byte(account), height)
+	if err != nil {
+		return nil, err
+	}
+	stakes := []*Block{}
+	for _, stakeID := range stakeIDs {
+		id, err := ids.ToID(stakeID)
+		if err != nil {
+			return nil, err
+		}
+		stake, err := vm.getBlock(id)
+		if err != nil {
+			return nil, err
+		}
+		stakes = append(stakes, stake)
+	}
+	for i := len(ancestry) - 1; i >= 0; i-- {
+		if ancestry[i].isStakeBlock() && ancestry[i].getStakeRewardAddress() == account {
+			stakes = append(stakes, ancestry[i])
+		}
+	}
+	return stakes, nil
+}
@@ -0,0 +1,166 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+	"github.com/ava-labs/avalanchego/utils/json"
+)
+
+// newTestStakePayload returns a signed stake of [amount] on a node, from
+// [start] to [end], whose rewards go to [key]
+func newTestStakePayload(t *testing.T, key *testKey, amount int64, start int64, end int64) [dataLen]byte {
+	return newTestPayload(t, key, '2', fmt.Sprintf("%-40s%s%010d%010d%016d", "NodeID-test", key.address, start, end, amount))
+}
+
+// getTestFileAt returns [owner]'s file [fileID] as of the accepted block
+// at [height]
+func getTestFileAt(t *testing.T, vm *VM, owner string, fileID string, height uint64) (*GetFileReply, error) {
+	h := json.Uint64(height)
+	reply := &GetFileReply{}
+	err := (&Service{vm}).GetFile(nil, &GetFileArgs{Owner: owner, FileID: fileID, IncludeContent: true, Encoding: formatting.Hex, Height: &h}, reply)
+	return reply, err
+}
+
+func TestHistoricalQueries(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	service := &Service{vm}
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	funded := acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 20, alice.address))
+	acceptTestFileAt(t, vm, alice, "file000000000001", "/a.txt", "hello")
+	written := vm.LastAcceptedID
+	acceptTestPayload(t, vm, newTestRenamePayload(t, alice, "file000000000001", "/b.txt"))
+	transferred := acceptTestPayload(t, vm, newTestFileTransferPayload(t, alice, "file000000000001", bob.address))
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, bob, 10, bob.address))
+	deleted := acceptTestPayload(t, vm, newTestDeletePayload(t, bob, "file000000000001", 0))
+
+	// Balances and unallocated funds as of a block, by ID or height
+	height := json.Uint64(funded.Height())
+	balance := &GetBalanceReply{}
+	if err := service.GetBalance(nil, &GetBalanceArgs{Account: alice.address, Height: &height}, balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance != 20 || balance.BlockID != funded.ID().String() {
+		t.Fatalf("expected alice to have 20 as of %s but got %+v", funded.ID(), balance)
+	}
+	if err := service.GetBalance(nil, &GetBalanceArgs{Account: alice.address, BlockID: written.String()}, balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance >= 20 {
+		t.Fatalf("expected alice to have paid for her file but got %+v", balance)
+	}
+	if err := service.GetBalance(nil, &GetBalanceArgs{Account: alice.address, BlockID: written.String(), Height: &height}, balance); err != errBlockAndHeight {
+		t.Fatalf("expected %s but got %v", errBlockAndHeight, err)
+	}
+	genesis := json.Uint64(0)
+	unallocated := &GetUnallocatedFundsReply{}
+	if err := service.GetUnallocatedFunds(nil, &GetUnallocatedFundsArgs{Height: &genesis}, unallocated); err != nil {
+		t.Fatal(err)
+	}
+	if unallocated.UnallocatedFunds != genesisFunds || unallocated.Height != 0 {
+		t.Fatalf("expected the genesis funds but got %+v", unallocated)
+	}
+
+	// Files as of a block are replayed from the blocks about them
+	writtenBlock, err := vm.getBlock(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := getTestFileAt(t, vm, alice.address, "file000000000001", writtenBlock.Height())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := formatting.Decode(formatting.Hex, reply.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.File.Path != "/a.txt" || reply.File.Version != 1 || string(content) != "hello" {
+		t.Fatalf("expected alice's file at /a.txt but got %+v", reply.File)
+	}
+	if reply, err := getTestFileAt(t, vm, alice.address, "file000000000001", transferred.Height()-1); err != nil || reply.File.Path != "/b.txt" {
+		t.Fatalf("expected alice's file at /b.txt but got %+v, %v", reply.File, err)
+	}
+	for name, test := range map[string]struct {
+		owner    string
+		height   uint64
+		expected error
+	}{
+		"before upload":     {alice.address, funded.Height(), errNoSuchFile},
+		"before transfer":   {bob.address, transferred.Height() - 1, errNoSuchFile},
+		"after transfer":    {alice.address, transferred.Height(), errFileTransferred},
+		"after deletion":    {bob.address, deleted.Height(), errFileDeleted},
+		"bob before delete": {bob.address, deleted.Height() - 1, nil},
+	} {
+		if _, err := getTestFileAt(t, vm, test.owner, "file000000000001", test.height); err != test.expected {
+			t.Fatalf("expected %v for %s but got %v", test.expected, name, err)
+		}
+	}
+	versions := &ListVersionsReply{}
+	before := json.Uint64(writtenBlock.Height() - 1)
+	if err := service.ListVersions(nil, &ListVersionsArgs{Owner: alice.address, FileID: "file000000000001", Height: &before}, versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 0 {
+		t.Fatalf("expected no versions before the manifest but got %+v", versions.Versions)
+	}
+
+	// Stakes as of a block
+	start := time.Now().Add(time.Minute).Unix()
+	stake := acceptTestPayload(t, vm, newTestStakePayload(t, bob, 5, start, start+600))
+	stakes := &GetStakesReply{}
+	if err := service.GetStakes(nil, &GetStakesArgs{Account: bob.address}, stakes); err != nil {
+		t.Fatal(err)
+	}
+	if len(stakes.Stakes) != 1 || stakes.Stakes[0].ID != stake.ID().String() || stakes.Stakes[0].Amount != 5 {
+		t.Fatalf("expected bob's stake but got %+v", stakes)
+	}
+	// It's locked as of the block's timestamp, which is in it
+	if stakes.Stakes[0].Locked != 5 || stakes.Stakes[0].Reward != 0 {
+		t.Fatalf("expected bob's stake to be locked but got %+v", stakes.Stakes[0])
+	}
+	if err := service.GetStakes(nil, &GetStakesArgs{Account: bob.address, BlockID: stake.Parent().String()}, stakes); err != nil {
+		t.Fatal(err)
+	}
+	if len(stakes.Stakes) != 0 {
+		t.Fatalf("expected no stakes before bob's but got %+v", stakes)
+	}
+
+	// Files can only be looked up as of accepted blocks
+	processing := newTestBlockAt(t, vm, newTestFaucetPayload(t, alice, 1, alice.address), time.Now())
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: bob.address, FileID: "file000000000001", BlockID: processing.ID().String()}, &GetFileReply{}); err != errNotAccepted {
+		t.Fatalf("expected %s but got %v", errNotAccepted, err)
+	}
+
+	// Nodes that accepted the blocks before the history indexes existed
+	// have the same history
+	for _, db := range []database.Database{vm.fileHistoryDB, vm.fileCreatorHistoryDB, vm.stakeHistoryDB} {
+		iter := db.NewIterator()
+		for iter.Next() {
+			if err := db.Delete(iter.Key()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		iter.Release()
+	}
+	if err := vm.reindexHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getTestFileAt(t, vm, alice.address, "file000000000001", transferred.Height()); err != errFileTransferred {
+		t.Fatalf("expected %s after reindexing but got %v", errFileTransferred, err)
+	}
+	if reply, err := getTestFileAt(t, vm, bob.address, "file000000000001", deleted.Height()-1); err != nil || reply.File.Path != "/b.txt" {
+		t.Fatalf("expected bob's file at /b.txt after reindexing but got %+v, %v", reply.File, err)
+	}
+}
@@ -0,0 +1,107 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+// indexAccepted updates every index with the accepted block [b]
+func (vm *VM) indexAccepted(b *Block) error {
+	if err := vm.indexCreator(b); err != nil {
+		return err
+	}
+	if err := vm.indexState(b); err != nil {
+		return err
+	}
+	if err := vm.indexHistory(b); err != nil {
+		return err
+	}
+	if err := vm.indexAccountHistory(b); err != nil {
+		return err
+	}
+	if err := vm.putBlockIDAtHeight(b.Height(), b.ID()); err != nil {
+		return err
+	}
+	if err := vm.indexTx(b); err != nil {
+		return err
+	}
+	if err := vm.indexVersion(b); err != nil {
+		return err
+	}
+	if err := vm.indexFile(b); err != nil {
+		return err
+	}
+	if err := vm.indexContent(b); err != nil {
+		return err
+	}
+	if err := vm.indexExpiry(b); err != nil {
+		return err
+	}
+	if err := vm.indexDeletion(b); err != nil {
+		return err
+	}
+	if err := vm.indexPath(b); err != nil {
+		return err
+	}
+	if err := vm.indexAnchor(b); err != nil {
+		return err
+	}
+	if err := vm.indexOwner(b); err != nil {
+		return err
+	}
+	return vm.indexFileAccess(b)
+}
+
+// findAccepted returns the accepted blocks other than genesis that [match]
+// returns true for, oldest first. It walks back through the whole chain,
+// so it's only for backfilling indexes.
+func (vm *VM) findAccepted(match func(*Block) bool) ([]*Block, error) {
+	block, err := vm.getLastAcceptedBlock()
+	if err != nil {
+		return nil, err
+	}
+	matches := []*Block{}
+	for block.Height() > 0 {
+		if match(block) {
+			matches = append(matches, block)
+		}
+		if block, err = block.getParent(); err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches, nil
+}
+
+// reindex walks back from the last accepted block to the first one missing
+// from the height index, then indexes those blocks oldest first. This only
+// does work for chains that were accepted before the indexes existed.
+func (vm *VM) reindex() error {
+	block, err := vm.getLastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+	unindexed := []*Block{}
+	for {
+		id, err := vm.getBlockIDAtHeight(block.Height())
+		if err == nil && id == block.ID() {
+			break
+		}
+		if err != nil && err != errNoBlockAtHeight {
+			return err
+		}
+		unindexed = append(unindexed, block)
+		if block.Height() == 0 {
+			break
+		}
+		if block, err = block.getParent(); err != nil {
+			return err
+		}
+	}
+	for i := len(unindexed) - 1; i >= 0; i-- {
+		if err := vm.indexAccepted(unindexed[i]); err != nil {
+			return err
+		}
+	}
+	return vm.DB.Commit()
+}
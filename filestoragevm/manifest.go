@@ -0,0 +1,220 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+const (
+	// Offset of the message content in a block
+	contentOffset = 158
+
+	// Length of the fixed part of an upload, before the chunk data
+	uploadHeaderLen = 16 + 8
+
+	// Length of the fixed part of a manifest, before the file name
+	manifestHeaderLen = 16 + 16 + 8 + 64
+
+	// Length of a hex encoded chunk block ID in a manifest
+	manifestChunkIDLen = 64
+)
+
+var (
+	errMalformedManifest = errors.New("manifest is malformed")
+	errManifestChunk     = errors.New("manifest chunk must be an upload of the same file, in order, on this chain")
+	errManifestSize      = errors.New("manifest size doesn't match the size of its chunks")
+	errManifestHash      = errors.New("manifest hash doesn't match the SHA-256 of its chunks")
+)
+
+// version manifests are manifests too. They just name the version of the
+// file they replace.
+func (b *Block) isManifestBlock() bool {
+	return b.getBlockType() == "3" || b.isVersionBlock()
+}
+
+// returns the message content, without the null bytes padding the block
+func (b *Block) getContent() []byte {
+	end := contentOffset + b.getContentLength()
+	if end > dataLen {
+		end = dataLen
+	}
+	return b.Data[contentOffset:end]
+}
+
+// returns the data stored by an upload block
+func (b *Block) getUploadChunk() []byte {
+	if b.pruned {
+		return nil
+	}
+	if b.isReferenceBlock() {
+		return b.getReferencedChunk()
+	}
+	content := b.getContent()
+	if b.isSessionUploadBlock() {
+		_, start, err := b.getSessionProof()
+		if err != nil {
+			return nil
+		}
+		return content[start:]
+	}
+	headerLen := uploadHeaderLen
+	if b.isRetentionUploadBlock() {
+		headerLen = retentionUploadHeaderLen
+	}
+	if len(content) < headerLen {
+		return nil
+	}
+	return content[headerLen:]
+}
+
+func (b *Block) getManifestFileID() string {
+	return string(b.Data[contentOffset : contentOffset+16])
+}
+
+// returns the offset of the manifest's content in the block, past the
+// parent version of a version manifest
+func (b *Block) getManifestOffset() int {
+	if b.isVersionBlock() {
+		return contentOffset + versionParentLen
+	}
+	return contentOffset
+}
+
+func (b *Block) getManifestSize() int64 {
+	offset := b.getManifestOffset()
+	sizeBytes := b.Data[offset+16 : offset+16+16]
+	return b.convertBytesToInt(sizeBytes)
+}
+
+func (b *Block) getManifestChunkCount() int64 {
+	offset := b.getManifestOffset()
+	countBytes := b.Data[offset+16+16 : offset+16+16+8]
+	return b.convertBytesToInt(countBytes)
+}
+
+// returns the hex encoded SHA-256 of the file's content
+func (b *Block) getManifestHash() string {
+	offset := b.getManifestOffset()
+	return string(b.Data[offset+16+16+8 : offset+manifestHeaderLen])
+}
+
+// getManifestFields splits the variable length part of a manifest into
+// the file name, the MIME type and the hex encoded chunk block IDs
+func (b *Block) getManifestFields() (string, string, []byte, error) {
+	rest := b.getContent()
+	headerLen := b.getManifestOffset() - contentOffset + manifestHeaderLen
+	if len(rest) < headerLen {
+		return "", "", nil, errMalformedManifest
+	}
+	rest = rest[headerLen:]
+
+	fields := make([]string, 2)
+	for i := range fields {
+		if len(rest) < 3 {
+			return "", "", nil, errMalformedManifest
+		}
+		fieldLen, err := strconv.ParseUint(string(rest[:3]), 10, 16)
+		if err != nil || uint64(len(rest)) < 3+fieldLen {
+			return "", "", nil, errMalformedManifest
+		}
+		fields[i] = string(rest[3 : 3+fieldLen])
+		rest = rest[3+fieldLen:]
+	}
+	return fields[0], fields[1], rest, nil
+}
+
+func (b *Block) getManifestName() string {
+	name, _, _, _ := b.getManifestFields()
+	return name
+}
+
+func (b *Block) getManifestMimeType() string {
+	_, mimeType, _, _ := b.getManifestFields()
+	return mimeType
+}
+
+// returns the IDs of the upload blocks holding the file's chunks, in order
+func (b *Block) getManifestChunkIDs() ([]ids.ID, error) {
+	_, _, chunkIDBytes, err := b.getManifestFields()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(chunkIDBytes)) != b.getManifestChunkCount()*manifestChunkIDLen {
+		return nil, errMalformedManifest
+	}
+	chunkIDs := make([]ids.ID, b.getManifestChunkCount())
+	for i := range chunkIDs {
+		idBytes, err := hex.DecodeString(string(chunkIDBytes[i*manifestChunkIDLen : (i+1)*manifestChunkIDLen]))
+		if err != nil {
+			return nil, errMalformedManifest
+		}
+		chunkIDs[i], err = ids.ToID(idBytes)
+		if err != nil {
+			return nil, errMalformedManifest
+		}
+	}
+	return chunkIDs, nil
+}
+
+// getManifestChunks returns the upload blocks listed by this manifest.
+// Each one must be on this block's chain and be chunk i of the same file,
+// though it may have been uploaded before the file was transferred. A
+// version manifest can list any of the file's chunks, in any order, so
+// versions can share the chunks that didn't change.
+func (b *Block) getManifestChunks() ([]*Block, error) {
+	chunkIDs, err := b.getManifestChunkIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(chunkIDs) == 0 {
+		return nil, errMalformedManifest
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]*Block, len(chunkIDs))
+	for i, chunkID := range chunkIDs {
+		chunk, err := b.vm.getBlock(chunkID)
+		if err != nil || !chunk.isUploadBlock() {
+			return nil, errManifestChunk
+		}
+		if isOfFile, err := chunk.isOfFile(creator, b.getManifestFileID()); err != nil || !isOfFile ||
+			(!b.isVersionBlock() && chunk.getUploadChunkNumber() != int64(i)) ||
+			!b.hasAncestor(chunk) {
+			return nil, errManifestChunk
+		}
+		chunks[i] = chunk
+	}
+	return chunks, nil
+}
+
+// verifyManifest returns nil iff this manifest's size and hash match the
+// chunks it lists
+func (b *Block) verifyManifest() error {
+	chunks, err := b.getManifestChunks()
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	var size int64
+	for _, chunk := range chunks {
+		data := chunk.getUploadChunk()
+		size += int64(len(data))
+		hash.Write(data)
+	}
+	if size != b.getManifestSize() {
+		return errManifestSize
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != b.getManifestHash() {
+		return errManifestHash
+	}
+	return nil
+}
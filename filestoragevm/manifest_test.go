@@ -0,0 +1,121 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+func TestManifest(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	chunk0 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello "))
+	chunk1 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 1, "world"))
+	chunkIDs := []ids.ID{chunk0.ID(), chunk1.ID()}
+
+	manifest := acceptTestPayload(t, vm, newTestManifestPayload(t, key, "file000000000001", "hello.txt", "text/plain", "hello world", chunkIDs))
+	if !manifest.isManifestBlock() {
+		t.Fatal("expected a manifest block")
+	}
+	if name := manifest.getManifestName(); name != "hello.txt" {
+		t.Fatalf("expected name hello.txt but got %q", name)
+	}
+	if mimeType := manifest.getManifestMimeType(); mimeType != "text/plain" {
+		t.Fatalf("expected MIME type text/plain but got %q", mimeType)
+	}
+	if balance := getTestBalance(t, manifest, key.address); balance != 7 {
+		t.Fatalf("expected balance 7 after 2 chunks and a manifest but got %d", balance)
+	}
+
+	service := Service{vm}
+	reply := GetBlockReply{}
+	if err := service.GetBlock(nil, &GetBlockArgs{ID: manifest.ID().String(), Decode: true}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Tx.Type != "manifest" || reply.Tx.TotalSize != 11 || reply.Tx.ChunkCount != 2 {
+		t.Fatalf("unexpected decoded manifest %+v", reply.Tx)
+	}
+	if len(reply.Tx.ChunkIDs) != 2 || reply.Tx.ChunkIDs[1] != chunk1.ID().String() {
+		t.Fatalf("unexpected decoded chunk IDs %v", reply.Tx.ChunkIDs)
+	}
+}
+
+func TestManifestInvalid(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	other := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, other, 10, other.address))
+	chunk0 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello "))
+	chunk1 := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 1, "world"))
+	otherChunk := acceptTestPayload(t, vm, newTestUploadPayload(t, other, "file000000000001", 1, "world"))
+
+	tests := []struct {
+		name     string
+		data     [dataLen]byte
+		expected error
+	}{
+		{"wrong hash", newTestManifestPayload(t, key, "file000000000001", "a", "", "hello wOrld", []ids.ID{chunk0.ID(), chunk1.ID()}), errManifestHash},
+		{"wrong size", newTestManifestPayload(t, key, "file000000000001", "a", "", "hello worl", []ids.ID{chunk0.ID(), chunk1.ID()}), errManifestSize},
+		{"out of order", newTestManifestPayload(t, key, "file000000000001", "a", "", "worldhello ", []ids.ID{chunk1.ID(), chunk0.ID()}), errManifestChunk},
+		{"other owner", newTestManifestPayload(t, key, "file000000000001", "a", "", "hello world", []ids.ID{chunk0.ID(), otherChunk.ID()}), errManifestChunk},
+		{"other file", newTestManifestPayload(t, key, "file000000000002", "a", "", "hello world", []ids.ID{chunk0.ID(), chunk1.ID()}), errManifestChunk},
+		{"no chunks", newTestManifestPayload(t, key, "file000000000001", "a", "", "", nil), errMalformedManifest},
+	}
+	for _, test := range tests {
+		block, err := vm.NewBlock(vm.LastAcceptedID, otherChunk.Height()+1, test.data, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := block.Verify(); err != test.expected {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestManifestProcessingChunks(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	funded := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+
+	// The chunk is verified but not accepted yet
+	chunk, err := vm.NewBlock(funded.ID(), funded.Height()+1, newTestUploadPayload(t, key, "file000000000001", 0, "hi"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chunk.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := newTestManifestPayload(t, key, "file000000000001", "hi.txt", "text/plain", "hi", []ids.ID{chunk.ID()})
+	onChunk, err := vm.NewBlock(chunk.ID(), chunk.Height()+1, data, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := onChunk.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sibling of the chunk can't list it
+	onSibling, err := vm.NewBlock(funded.ID(), funded.Height()+1, data, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := onSibling.Verify(); err != errManifestChunk {
+		t.Fatalf("expected %s but got %v", errManifestChunk, err)
+	}
+}
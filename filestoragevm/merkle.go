@@ -0,0 +1,121 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+)
+
+// The Merkle trees in this VM are built bottom up from a list of leaf
+// hashes. The bottom level is the SHA-256 of each leaf after a 0x00 byte,
+// and each node above it is the SHA-256 of a 0x01 byte followed by its two
+// children, so a node can't be passed off as a leaf. When a level has an
+// odd number of nodes, the last one moves up a level as is, so there are
+// no duplicated nodes. The shape of a tree only depends on its number of
+// leaves.
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// merkleLeaf returns the node of [leaf] in the bottom level
+func merkleLeaf(leaf [32]byte) [32]byte {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, leaf[:]...))
+}
+
+// merkleLeaves returns the bottom level of the tree whose leaves are
+// [leaves]
+func merkleLeaves(leaves [][32]byte) [][32]byte {
+	level := make([][32]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+	return level
+}
+
+// merkleParents returns the level above [level]
+func merkleParents(level [][32]byte) [][32]byte {
+	parents := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleNode(level[i], level[i+1]))
+	}
+	return parents
+}
+
+// merkleNode returns the parent of [left] and [right]
+func merkleNode(left [32]byte, right [32]byte) [32]byte {
+	node := make([]byte, 0, 1+len(left)+len(right))
+	node = append(node, merkleNodePrefix)
+	node = append(node, left[:]...)
+	return sha256.Sum256(append(node, right[:]...))
+}
+
+// merkleRoot returns the root of the tree whose leaves are [leaves].
+// The root of an empty tree is all zeros.
+func merkleRoot(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return [32]byte{}
+	}
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+// merkleProof returns the sibling hashes on the path from leaf [index] up
+// to the root, bottom first. Levels where the node has no sibling are
+// skipped.
+func merkleProof(leaves [][32]byte, index int) [][32]byte {
+	proof := [][32]byte{}
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		level = merkleParents(level)
+		index /= 2
+	}
+	return proof
+}
+
+// verifyMerkleProof returns true iff [proof] shows that [leaf] is leaf
+// [index] of a tree with [count] leaves whose root is [root]
+func verifyMerkleProof(leaf [32]byte, index int, count int, proof [][32]byte, root [32]byte) bool {
+	proofRoot, ok := merkleProofRoot(leaf, index, count, proof)
+	return ok && proofRoot == root
+}
+
+// merkleProofRoot returns the root of the tree with [count] leaves that
+// [proof] shows [leaf] is leaf [index] of, and false if [proof] doesn't
+// fit a tree of that shape
+func merkleProofRoot(leaf [32]byte, index int, count int, proof [][32]byte) ([32]byte, bool) {
+	if index < 0 || index >= count {
+		return [32]byte{}, false
+	}
+	node := merkleLeaf(leaf)
+	for count > 1 {
+		sibling := index ^ 1
+		if sibling < count {
+			if len(proof) == 0 {
+				return [32]byte{}, false
+			}
+			if index%2 == 0 {
+				node = merkleNode(node, proof[0])
+			} else {
+				node = merkleNode(proof[0], node)
+			}
+			proof = proof[1:]
+		}
+		count = (count + 1) / 2
+		index /= 2
+	}
+	return node, len(proof) == 0
+}
@@ -0,0 +1,54 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves := make([][32]byte, count)
+		for i := range leaves {
+			leaves[i] = sha256.Sum256([]byte{byte(i)})
+		}
+		root := merkleRoot(leaves)
+		for i := range leaves {
+			proof := merkleProof(leaves, i)
+			if !verifyMerkleProof(leaves[i], i, count, proof, root) {
+				t.Fatalf("proof of leaf %d of %d should verify", i, count)
+			}
+			if verifyMerkleProof(leaves[i], (i+1)%count, count, proof, root) && count > 1 {
+				t.Fatalf("proof of leaf %d of %d shouldn't verify at another index", i, count)
+			}
+			if verifyMerkleProof(sha256.Sum256([]byte("other")), i, count, proof, root) {
+				t.Fatalf("proof of leaf %d of %d shouldn't verify another leaf", i, count)
+			}
+		}
+	}
+}
+
+func TestMerkleRootOddLevels(t *testing.T) {
+	a, b, c := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))
+	expected := merkleNode(merkleNode(merkleLeaf(a), merkleLeaf(b)), merkleLeaf(c))
+	if root := merkleRoot([][32]byte{a, b, c}); root != expected {
+		t.Fatal("expected the odd node to move up a level unchanged")
+	}
+}
+
+func TestMerkleNodeIsNotALeaf(t *testing.T) {
+	leaves := make([][32]byte, 4)
+	for i := range leaves {
+		leaves[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	root := merkleRoot(leaves)
+	// The node above the first two leaves, proven as a leaf of a tree with
+	// half as many leaves
+	node := merkleNode(merkleLeaf(leaves[0]), merkleLeaf(leaves[1]))
+	proof := merkleProof(leaves, 0)[1:]
+	if verifyMerkleProof(node, 0, 2, proof, root) {
+		t.Fatal("a node shouldn't verify as a leaf")
+	}
+}
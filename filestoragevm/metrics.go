@@ -0,0 +1,168 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/metric"
+	"github.com/ava-labs/avalanchego/utils/wrappers"
+)
+
+// metrics are this chain's Prometheus metrics. They're registered on the
+// snow.Context's registry, so avalanchego serves them from its metrics API
+// under the chain's namespace.
+type metrics struct {
+	blocksBuilt, blocksVerified, blocksVerifyFailed, blocksAccepted, blocksRejected prometheus.Counter
+
+	// How long verifying a block takes, in milliseconds
+	verifyLatency prometheus.Histogram
+
+	// Pieces of data waiting to be put in a block
+	mempoolDepth prometheus.Gauge
+
+	// Accepted transactions, by type
+	txs *prometheus.CounterVec
+
+	// Bytes of chunk data in accepted uploads. Chunk references don't store
+	// new data, so they don't count.
+	bytesStored prometheus.Counter
+
+	// Storage fees and rent the accepted transactions paid
+	feesCollected, rentCollected prometheus.Counter
+
+	// Rewards the accepted stakes paid out. Rewards aren't paid by a
+	// transaction: a stake pays its reward once a block's timestamp is past
+	// its end, so they're counted when the first such block is accepted.
+	stakeRewardsPaid prometheus.Counter
+
+	// Accepted stakes that hadn't ended as of the last accepted block
+	unpaidStakes []*Block
+
+	// Duration and errors of API calls, by method
+	apiRequestMetric metric.APIInterceptor
+}
+
+// Initialize creates the metrics and registers them on [registerer]
+func (m *metrics) Initialize(namespace string, registerer prometheus.Registerer) error {
+	newCounter := func(name string, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help})
+	}
+	m.blocksBuilt = newCounter("blocks_built", "Number of blocks this node built")
+	m.blocksVerified = newCounter("blocks_verified", "Number of block verifications that passed")
+	m.blocksVerifyFailed = newCounter("blocks_verify_failed", "Number of block verifications that failed")
+	m.blocksAccepted = newCounter("blocks_accepted", "Number of blocks accepted")
+	m.blocksRejected = newCounter("blocks_rejected", "Number of blocks rejected")
+	m.verifyLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "verify_latency_ms",
+		Help:      "Time spent verifying a block, in milliseconds",
+		Buckets:   []float64{1, 5, 10, 50, 100, 500, 1000, 5000},
+	})
+	m.mempoolDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mempool_depth",
+		Help:      "Number of pieces of data waiting to be put in a block",
+	})
+	m.txs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txs_accepted",
+		Help:      "Number of transactions accepted, by type",
+	}, []string{"type"})
+	m.bytesStored = newCounter("bytes_stored", "Bytes of chunk data in accepted uploads")
+	m.feesCollected = newCounter("fees_collected", "Storage fees paid by accepted transactions")
+	m.rentCollected = newCounter("rent_collected", "Rent paid by accepted renewals and uploads with retention")
+	m.stakeRewardsPaid = newCounter("stake_rewards_paid", "Rewards paid out by accepted stakes once they ended")
+
+	apiRequestMetric, err := metric.NewAPIInterceptor(namespace, registerer)
+	m.apiRequestMetric = apiRequestMetric
+	errs := wrappers.Errs{}
+	errs.Add(
+		err,
+		registerer.Register(m.blocksBuilt),
+		registerer.Register(m.blocksVerified),
+		registerer.Register(m.blocksVerifyFailed),
+		registerer.Register(m.blocksAccepted),
+		registerer.Register(m.blocksRejected),
+		registerer.Register(m.verifyLatency),
+		registerer.Register(m.mempoolDepth),
+		registerer.Register(m.txs),
+		registerer.Register(m.bytesStored),
+		registerer.Register(m.feesCollected),
+		registerer.Register(m.rentCollected),
+		registerer.Register(m.stakeRewardsPaid),
+	)
+	return errs.Err
+}
+
+// observeVerify records a verification of a block that took [duration]
+// and returned [err]
+func (m *metrics) observeVerify(duration time.Duration, err error) {
+	m.verifyLatency.Observe(float64(duration) / float64(time.Millisecond))
+	if err != nil {
+		m.blocksVerifyFailed.Inc()
+	} else {
+		m.blocksVerified.Inc()
+	}
+}
+
+// observeAccept records the acceptance of [b]
+func (m *metrics) observeAccept(b *Block) {
+	m.blocksAccepted.Inc()
+	// The genesis block holds the chain's parameters, not a transaction
+	if b.Height() == 0 {
+		return
+	}
+	m.txs.WithLabelValues(b.getTxType()).Inc()
+	if b.isUploadBlock() && !b.isReferenceBlock() {
+		m.bytesStored.Add(float64(len(b.getUploadChunk())))
+	}
+	if b.paysStorageFee() {
+		m.feesCollected.Add(float64(b.getStorageFee()))
+	}
+	if rent := b.getTxRent(); rent > 0 {
+		m.rentCollected.Add(float64(rent))
+	}
+	at := b.Timestamp().Unix()
+	unpaid := m.unpaidStakes[:0]
+	for _, stake := range m.unpaidStakes {
+		if at > stake.getStakeEnd() {
+			m.stakeRewardsPaid.Add(float64(stake.getStakeReward(at)))
+		} else {
+			unpaid = append(unpaid, stake)
+		}
+	}
+	m.unpaidStakes = unpaid
+	if b.isStakeBlock() {
+		m.unpaidStakes = append(m.unpaidStakes, b)
+	}
+}
+
+// loadUnpaidStakes finds the accepted stakes that hadn't ended as of the
+// last accepted block, so their rewards are counted once they're paid
+func (vm *VM) loadUnpaidStakes() error {
+	lastAccepted, err := vm.getLastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+	iter := vm.stakeHistoryDB.NewIterator()
+	defer iter.Release()
+	for iter.Next() {
+		id, err := ids.ToID(iter.Value())
+		if err != nil {
+			return err
+		}
+		stake, err := vm.getBlock(id)
+		if err != nil {
+			return err
+		}
+		if lastAccepted.Timestamp().Unix() <= stake.getStakeEnd() {
+			vm.metrics.unpaidStakes = append(vm.metrics.unpaidStakes, stake)
+		}
+	}
+	return iter.Error()
+}
@@ -0,0 +1,90 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 20, key.address))
+	upload := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"))
+	reference := acceptTestPayload(t, vm, newTestReferencePayload(t, key, "file000000000002", 0, hashChunk([]byte("hello"))))
+	vm.proposeBlock(newTestFaucetPayload(t, key, 1, key.address))
+
+	for name, test := range map[string]struct {
+		value    float64
+		expected float64
+	}{
+		"blocks built":    {testutil.ToFloat64(vm.metrics.blocksBuilt), 3},
+		"blocks accepted": {testutil.ToFloat64(vm.metrics.blocksAccepted), 4},
+		"faucet txs":      {testutil.ToFloat64(vm.metrics.txs.WithLabelValues("faucet")), 1},
+		"upload txs":      {testutil.ToFloat64(vm.metrics.txs.WithLabelValues("upload")), 1},
+		"reference txs":   {testutil.ToFloat64(vm.metrics.txs.WithLabelValues("chunkReference")), 1},
+		"mempool depth":   {testutil.ToFloat64(vm.metrics.mempoolDepth), 1},
+		// The reference doesn't store the data again
+		"bytes stored":   {testutil.ToFloat64(vm.metrics.bytesStored), 5},
+		"fees collected": {testutil.ToFloat64(vm.metrics.feesCollected), float64(upload.getStorageFee() + reference.getStorageFee())},
+	} {
+		if test.value != test.expected {
+			t.Fatalf("expected %s to be %v but got %v", name, test.expected, test.value)
+		}
+	}
+
+	// Failed verifications and rejections are counted too
+	verified := testutil.ToFloat64(vm.metrics.blocksVerified)
+	invalid := newTestBlockAt(t, vm, newTestFaucetPayload(t, key, genesisFunds+1, key.address), time.Now())
+	if err := invalid.Verify(); err != errFaucetEmpty {
+		t.Fatalf("expected %s but got %v", errFaucetEmpty, err)
+	}
+	if failed := testutil.ToFloat64(vm.metrics.blocksVerifyFailed); failed != 1 || testutil.ToFloat64(vm.metrics.blocksVerified) != verified {
+		t.Fatalf("expected 1 failed verification but got %v", failed)
+	}
+	processing := newTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), time.Now())
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := processing.Reject(); err != nil {
+		t.Fatal(err)
+	}
+	if rejected := testutil.ToFloat64(vm.metrics.blocksRejected); rejected != 1 {
+		t.Fatalf("expected 1 rejected block but got %v", rejected)
+	}
+}
+
+func TestStakeRewardsMetric(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	start := time.Now().Add(time.Minute)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 20, key.address))
+	stake := acceptTestPayload(t, vm, newTestStakePayload(t, key, 5, start.Unix(), start.Unix()+10))
+	if len(vm.metrics.unpaidStakes) != 1 {
+		t.Fatalf("expected 1 unpaid stake but got %d", len(vm.metrics.unpaidStakes))
+	}
+
+	// The stake pays out once a block is past its end
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), start.Add(10*time.Second))
+	if len(vm.metrics.unpaidStakes) != 1 {
+		t.Fatalf("expected 1 unpaid stake but got %d", len(vm.metrics.unpaidStakes))
+	}
+	paidAt := start.Add(11 * time.Second)
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), paidAt)
+	if len(vm.metrics.unpaidStakes) != 0 {
+		t.Fatalf("expected no unpaid stakes but got %d", len(vm.metrics.unpaidStakes))
+	}
+	if paid := testutil.ToFloat64(vm.metrics.stakeRewardsPaid); paid != float64(stake.getStakeReward(paidAt.Unix())) {
+		t.Fatalf("expected %d rewards paid but got %v", stake.getStakeReward(paidAt.Unix()), paid)
+	}
+}
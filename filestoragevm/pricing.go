@@ -0,0 +1,173 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+	"strconv"
+)
+
+const (
+	// Most content one block can hold
+	maxContentLen = dataLen - contentOffset
+
+	// Most data one data chunk can hold
+	maxChunkSize = maxContentLen - uploadHeaderLen
+)
+
+var (
+	errWrongBaseFee     = errors.New("block's base fee isn't the one its parent sets")
+	errLegacyBlock      = errors.New("block has no base fee, but its parent does")
+	errBadContentLength = errors.New("content length is too long, or doesn't fit the transaction's layout")
+
+	// Content lengths of the transactions whose layout is fixed, by type.
+	// The file transactions check their own, with their own errors.
+	fixedContentLens = map[string]int64{
+		"1": 16 + addressLen + addressLen,   // transfer: amount, sender, recipient
+		"2": 40 + addressLen + 10 + 10 + 16, // stake: node ID, reward address, start, end, amount
+		"9": 16 + addressLen,                // faucet: amount, recipient
+	}
+)
+
+// computeNextBaseFee returns the base fee of the block after one whose
+// base fee is [baseFee] and whose content is [contentLength] bytes long.
+// Like EIP-1559, the base fee moves towards the price where blocks are
+// [params.TargetBlockSize] full, by at most 1/[params.BaseFeeChangeDenominator]
+// per block, and never drops below [params.BaseFee].
+func computeNextBaseFee(params genesisParams, baseFee uint64, contentLength int64) uint64 {
+	target := params.TargetBlockSize
+	used := uint64(contentLength)
+	switch {
+	case used > target:
+		delta := baseFee * (used - target) / target / params.BaseFeeChangeDenominator
+		if delta < 1 {
+			delta = 1
+		}
+		baseFee += delta
+	case used < target:
+		baseFee -= baseFee * (target - used) / target / params.BaseFeeChangeDenominator
+	}
+	if baseFee < params.BaseFee {
+		return params.BaseFee
+	}
+	return baseFee
+}
+
+// getBaseFee returns this block's base fee. Blocks from before base fees
+// were in the header pay the genesis base fee.
+func (b *Block) getBaseFee() uint64 {
+	if b.legacy {
+		return b.vm.params.BaseFee
+	}
+	return b.BaseFee
+}
+
+// getNextBaseFee returns the base fee of this block's children
+func (b *Block) getNextBaseFee() uint64 {
+	// The genesis block and blocks from before base fees were in the
+	// header don't count towards the base fee
+	if b.legacy || b.Height() == 0 {
+		return b.vm.params.BaseFee
+	}
+	return computeNextBaseFee(b.vm.params, b.BaseFee, b.getContentLength())
+}
+
+// verifyContentLength returns nil iff the content length this block's
+// transaction declares fits in a block and in the transaction's layout.
+// Fees and the base fee are worked out from the declared length, so
+// otherwise a free transfer could declare a full block and push the base
+// fee up. Blocks from before base fees were in the header were accepted
+// without the check, but they pay the genesis base fee and don't move it.
+func (b *Block) verifyContentLength() error {
+	if b.legacy {
+		return nil
+	}
+	length, err := strconv.ParseUint(string(b.Data[154:158]), 10, 64)
+	if err != nil || length > maxContentLen {
+		return errBadContentLength
+	}
+	if fixed, ok := fixedContentLens[b.getBlockType()]; ok && int64(length) != fixed {
+		return errBadContentLength
+	}
+	// A data chunk is the only part of an upload whose length can vary
+	if b.getBlockType() == "0" && length < uploadHeaderLen {
+		return errBadContentLength
+	}
+	if b.isRetentionUploadBlock() && length < retentionUploadHeaderLen {
+		return errBadContentLength
+	}
+	return nil
+}
+
+// verifyBaseFee returns nil iff this block has the base fee its parent sets
+func (b *Block) verifyBaseFee(parent *Block) error {
+	if b.legacy {
+		// Only allowed until the chain's first block with a base fee. Chains
+		// created since then have a genesis block with one.
+		if !parent.legacy {
+			return errLegacyBlock
+		}
+		return nil
+	}
+	if b.BaseFee != parent.getNextBaseFee() {
+		return errWrongBaseFee
+	}
+	return nil
+}
+
+// storageFee returns the fee for storing a transaction with
+// [contentLength] bytes of content in a block whose base fee is [baseFee]
+func (vm *VM) storageFee(baseFee uint64, contentLength int64) int64 {
+	return int64(baseFee) + int64(vm.params.FeePerByte)*contentLength
+}
+
+// estimateFees returns the fee for storing transactions with
+// [contentLengths] bytes of content, one per block, in the blocks after
+// [parent]. The base fee changes from block to block, so it also returns
+// the base fee of the block after them. It assumes nothing else gets in
+// between.
+func (vm *VM) estimateFees(parent *Block, contentLengths []int64) (int64, uint64) {
+	var fee int64
+	baseFee := parent.getNextBaseFee()
+	for _, contentLength := range contentLengths {
+		fee += vm.storageFee(baseFee, contentLength)
+		baseFee = computeNextBaseFee(vm.params, baseFee, contentLength)
+	}
+	return fee, baseFee
+}
+
+// estimateUploadFee returns the fee for uploading [size] bytes of data,
+// split into as few data chunks as possible, in the blocks after
+// [parent]. Each chunk's data follows [headerLen] bytes of header. It also
+// returns the number of chunks, and the base fee after them.
+func (vm *VM) estimateUploadFee(parent *Block, size int64, headerLen int64) (int64, int64, uint64) {
+	contentLengths := []int64{}
+	chunkLimit := maxContentLen - headerLen
+	for remaining := size; remaining > 0 || len(contentLengths) == 0; remaining -= chunkLimit {
+		// an empty upload still takes a chunk
+		chunkSize := remaining
+		if chunkSize > chunkLimit {
+			chunkSize = chunkLimit
+		}
+		contentLengths = append(contentLengths, headerLen+chunkSize)
+	}
+	fee, baseFee := vm.estimateFees(parent, contentLengths)
+	return fee, int64(len(contentLengths)), baseFee
+}
+
+// getStorageFee returns what this upload, chunk reference, manifest,
+// renewal, deletion, file transfer, file access change, rename or anchor
+// costs its signer, not counting rent or a deletion's refund. A
+// reference's content is just the hash of the data it points at, so it
+// costs much less than uploading the data again.
+func (b *Block) getStorageFee() int64 {
+	return b.vm.storageFee(b.getBaseFee(), b.getContentLength())
+}
+
+// paysStorageFee returns true iff the transaction in this block pays the
+// storage fee. Faucet drips, transfers and stakes don't.
+func (b *Block) paysStorageFee() bool {
+	return b.isUploadBlock() || b.isManifestBlock() || b.isRenewBlock() || b.isDeleteBlock() ||
+		b.isFileTransferBlock() || b.isFileAccessBlock() || b.isRenameBlock() || b.isAnchorBlock()
+}
@@ -0,0 +1,208 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+func TestGenesisParams(t *testing.T) {
+	if params := parseGenesisParams([]byte{0, 0, 0, 0, 0}); params != defaultGenesisParams() {
+		t.Fatalf("expected the default params for non-JSON genesis data but got %+v", params)
+	}
+	if params := parseGenesisParams([]byte(`{"feePerByte": 3}` + "\x00\x00")); params.BaseFee != 1 || params.FeePerByte != 3 {
+		t.Fatalf("expected the default base fee and 3 per byte but got %+v", params)
+	}
+}
+
+func TestSizeBasedPricing(t *testing.T) {
+	vm, ctx, _ := newTestVMWithGenesis(t, []byte(`{"baseFee": 2, "feePerByte": 1}`))
+	key := newTestKey(t)
+	chunk := strings.Repeat("a", 200)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 320, key.address))
+
+	// 2 + 1 for each of the 24 + 200 bytes of content
+	upload := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, chunk))
+	if balance := getTestBalance(t, upload, key.address); balance != 320-226 {
+		t.Fatalf("expected a balance of %d but got %d", 320-226, balance)
+	}
+
+	// Referencing the same data costs 2 + 1 for each of the 24 + 64 bytes
+	reference := acceptTestPayload(t, vm, newTestReferencePayload(t, key, "file000000000002", 0, hashChunk([]byte(chunk))))
+	if balance := getTestBalance(t, reference, key.address); balance != 320-226-90 {
+		t.Fatalf("expected a balance of %d but got %d", 320-226-90, balance)
+	}
+
+	// The 4 left can't pay the 2 + 25 for uploading 1 byte
+	tooBig, err := vm.NewBlock(reference.ID(), reference.Height()+1, newTestUploadPayload(t, key, "file000000000003", 0, "a"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tooBig.Verify(); err != errInsufficientBalance {
+		t.Fatalf("expected %s but got %v", errInsufficientBalance, err)
+	}
+
+	service := Service{vm}
+	reply := GetStorageCostReply{}
+	if err := service.GetStorageCost(nil, &GetStorageCostArgs{Size: maxChunkSize + 10}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// 2 chunks, each with a 24 byte header
+	if reply.ChunkCount != 2 || reply.Cost != 2*2+2*24+maxChunkSize+10 || reply.ReferenceCost != 90 {
+		t.Fatalf("unexpected quote %+v", reply)
+	}
+}
+
+func TestComputeNextBaseFee(t *testing.T) {
+	params := genesisParams{BaseFee: 10, TargetBlockSize: 1000, BaseFeeChangeDenominator: 8}
+	tests := []struct {
+		baseFee       uint64
+		contentLength int64
+		expected      uint64
+	}{
+		{baseFee: 80, contentLength: 1000, expected: 80}, // on target
+		{baseFee: 80, contentLength: 2000, expected: 90}, // twice the target, up 1/8
+		{baseFee: 80, contentLength: 0, expected: 70},    // empty, down 1/8
+		{baseFee: 10, contentLength: 1001, expected: 11}, // always goes up when over target
+		{baseFee: 10, contentLength: 0, expected: 10},    // never below the genesis base fee
+		{baseFee: 11, contentLength: 0, expected: 10},    // rounds down to the genesis base fee
+	}
+	for _, test := range tests {
+		if baseFee := computeNextBaseFee(params, test.baseFee, test.contentLength); baseFee != test.expected {
+			t.Fatalf("expected %d after a base fee of %d with %d bytes but got %d", test.expected, test.baseFee, test.contentLength, baseFee)
+		}
+	}
+}
+
+func TestDynamicBaseFee(t *testing.T) {
+	vm, ctx, _ := newTestVMWithGenesis(t, []byte(`{"baseFee": 8, "targetBlockSize": 100}`))
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	faucet := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 1000, key.address))
+	if faucet.BaseFee != 8 {
+		t.Fatalf("expected the genesis base fee but got %d", faucet.BaseFee)
+	}
+
+	// A faucet drip is under the target, so the base fee stays at the minimum.
+	// A full chunk is way over, so the base fee goes up after it.
+	upload := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, strings.Repeat("a", maxChunkSize)))
+	if upload.BaseFee != 8 {
+		t.Fatalf("expected a base fee of 8 but got %d", upload.BaseFee)
+	}
+	next := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000002", 0, "a"))
+	if next.BaseFee <= 8 {
+		t.Fatalf("expected the base fee to go up but got %d", next.BaseFee)
+	}
+	if balance := getTestBalance(t, next, key.address); balance != 1000-8-int64(next.BaseFee) {
+		t.Fatalf("expected a balance of %d but got %d", 1000-8-int64(next.BaseFee), balance)
+	}
+
+	// The base fee is part of the block, and has to be the one the parent sets
+	wrong, err := vm.NewBlock(next.ID(), next.Height()+1, newTestUploadPayload(t, key, "file000000000003", 0, "a"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong.BaseFee = next.getNextBaseFee() - 1
+	bytes, err := vm.codec.Marshal(codecVersion, wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(); err != errWrongBaseFee {
+		t.Fatalf("expected %s but got %v", errWrongBaseFee, err)
+	}
+
+	service := Service{vm}
+	reply := EstimateFeeReply{}
+	if err := service.EstimateFee(nil, &EstimateFeeArgs{Size: 2 * maxChunkSize}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(reply.BaseFee) != next.getNextBaseFee() || reply.ChunkCount != 2 || reply.BaseFeeAfterUpload <= reply.BaseFee {
+		t.Fatalf("unexpected estimate %+v", reply)
+	}
+	// The second chunk pays the base fee the first one sets
+	second := computeNextBaseFee(vm.params, uint64(reply.BaseFee), maxContentLen)
+	if reply.UploadFee != int64(reply.BaseFee)+int64(second) {
+		t.Fatalf("expected an upload fee of %d but got %d", int64(reply.BaseFee)+int64(second), reply.UploadFee)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	vm, ctx, _ := newTestVMWithGenesis(t, []byte(`{"baseFee": 8, "targetBlockSize": 200}`))
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 1000, alice.address))
+
+	// Transfers pay no fee, so they can't declare a longer content than
+	// they have to push the base fee up. Nothing can declare more than a
+	// block holds.
+	transfer := fmt.Sprintf("%016d%s%s", 0, alice.address, bob.address)
+	chunk := strings.Repeat("a", 10)
+	for name, data := range map[string][dataLen]byte{
+		"long transfer":  newTestPayloadWithLength(t, alice, '1', transfer, maxContentLen),
+		"short faucet":   newTestPayloadWithLength(t, alice, '9', fmt.Sprintf("%016d%s", 1, alice.address), 10),
+		"empty upload":   newTestPayloadWithLength(t, alice, '0', "file000000000001"+"00000000"+chunk, 4),
+		"overlong chunk": newTestPayloadWithLength(t, alice, '0', "file000000000001"+"00000000"+chunk, maxContentLen+1),
+	} {
+		block := newTestBlockAt(t, vm, data, time.Now())
+		if err := block.Verify(); err != errBadContentLength {
+			t.Fatalf("expected %s for a %s but got %v", errBadContentLength, name, err)
+		}
+	}
+	block := acceptTestPayload(t, vm, newTestPayload(t, alice, '1', transfer))
+	if block.getNextBaseFee() != 8 {
+		t.Fatalf("expected a transfer not to move the base fee but got %d", block.getNextBaseFee())
+	}
+}
+
+func TestLegacyBlock(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	genesis, err := vm.getBlockAtHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks serialized without a base fee still parse, and pay the
+	// genesis base fee
+	legacy := &legacyBlock{
+		Block: core.NewBlock(genesis.ID(), 1, time.Now().Unix()),
+		Data:  newTestFaucetPayload(t, key, 10, key.address),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedIntf, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := parsedIntf.(*Block)
+	if !parsed.legacy || parsed.getBaseFee() != vm.params.BaseFee || parsed.Data != legacy.Data {
+		t.Fatalf("expected a legacy block but got %+v", parsed)
+	}
+
+	// This chain's genesis block has a base fee, so its blocks must too
+	if err := parsed.Verify(); err != errLegacyBlock {
+		t.Fatalf("expected %s but got %v", errLegacyBlock, err)
+	}
+}
@@ -0,0 +1,282 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
+	"github.com/ava-labs/avalanchego/snow/consensus/snowman"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+	"github.com/ava-labs/avalanchego/vms/components/state"
+)
+
+var prunedIndexPrefix = []byte("pruned")
+
+// prunedBlock is how an accepted upload is stored once its data is
+// deleted. The data's bytes are zeroed, so the block's bytes no longer
+// hash to its ID, and the ID is stored with it. Everything else, like the
+// upload's header, signature and content length, is kept.
+type prunedBlock struct {
+	BlockID     ids.ID `serialize:"true"`
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
+	BaseFee     uint64        `serialize:"true"`
+	Legacy      bool          `serialize:"true"`
+	TxRoot      [32]byte      `serialize:"true"`
+	Rootless    bool          `serialize:"true"`
+	StateRoot   [32]byte      `serialize:"true"`
+	Stateless   bool          `serialize:"true"`
+}
+
+// statelessPrunedBlock is how pruned blocks were stored before blocks had
+// a state root
+type statelessPrunedBlock struct {
+	BlockID     ids.ID `serialize:"true"`
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
+	BaseFee     uint64        `serialize:"true"`
+	Legacy      bool          `serialize:"true"`
+	TxRoot      [32]byte      `serialize:"true"`
+	Rootless    bool          `serialize:"true"`
+}
+
+// rootlessPrunedBlock is how pruned blocks were stored before blocks had a
+// tx root
+type rootlessPrunedBlock struct {
+	BlockID     ids.ID `serialize:"true"`
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
+	BaseFee     uint64        `serialize:"true"`
+	Legacy      bool          `serialize:"true"`
+}
+
+// ID returns this block's ID. That's the hash of its bytes, except for
+// pruned blocks, which keep the ID they were accepted with.
+func (b *Block) ID() ids.ID {
+	if b.pruned {
+		return b.prunedID
+	}
+	return b.Block.ID()
+}
+
+// GetBlock implements the snowman.ChainVM interface. Pruned blocks are
+// moved from the state to the pruned index, so they're looked up there
+// if they aren't in the state. Decided blocks are cached; see cache.go.
+func (vm *VM) GetBlock(id ids.ID) (snowman.Block, error) {
+	if block, ok := vm.blockCache.Get(id); ok {
+		return block.(*Block), nil
+	}
+	blockIntf, err := vm.SnowmanVM.GetBlock(id)
+	if err == database.ErrNotFound {
+		blockIntf, err = vm.getPrunedBlock(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block, ok := blockIntf.(*Block); ok && block.Status().Decided() {
+		vm.blockCache.Put(id, block)
+	}
+	return blockIntf, nil
+}
+
+// getPrunedBlock returns the pruned block with ID [id]. Its bytes are the
+// pruned record, which other nodes can't parse, so a pruning node can't
+// help them bootstrap past it.
+func (vm *VM) getPrunedBlock(id ids.ID) (*Block, error) {
+	bytes, err := vm.prunedDB.Get(id[:])
+	if err != nil {
+		return nil, err
+	}
+	record := &prunedBlock{}
+	if _, err := vm.codec.Unmarshal(bytes, record); err != nil {
+		stateless := &statelessPrunedBlock{}
+		rootless := &rootlessPrunedBlock{}
+		if _, statelessErr := vm.codec.Unmarshal(bytes, stateless); statelessErr == nil {
+			record = &prunedBlock{
+				BlockID:   stateless.BlockID,
+				Block:     stateless.Block,
+				Data:      stateless.Data,
+				BaseFee:   stateless.BaseFee,
+				Legacy:    stateless.Legacy,
+				TxRoot:    stateless.TxRoot,
+				Rootless:  stateless.Rootless,
+				Stateless: true,
+			}
+		} else if _, rootlessErr := vm.codec.Unmarshal(bytes, rootless); rootlessErr == nil {
+			record = &prunedBlock{
+				BlockID:   rootless.BlockID,
+				Block:     rootless.Block,
+				Data:      rootless.Data,
+				BaseFee:   rootless.BaseFee,
+				Legacy:    rootless.Legacy,
+				Rootless:  true,
+				Stateless: true,
+			}
+		} else {
+			return nil, err
+		}
+	}
+	block := &Block{
+		Block:     record.Block,
+		Data:      record.Data,
+		BaseFee:   record.BaseFee,
+		vm:        vm,
+		legacy:    record.Legacy,
+		TxRoot:    record.TxRoot,
+		rootless:  record.Rootless,
+		StateRoot: record.StateRoot,
+		stateless: record.Stateless,
+		pruned:    true,
+		prunedID:  record.BlockID,
+	}
+	block.Initialize(bytes, &vm.SnowmanVM)
+	// Only accepted blocks are pruned
+	block.SetStatus(choices.Accepted)
+	return block, nil
+}
+
+// pruneBlock deletes the data of the accepted upload [b], keeping the
+// rest of the block
+func (vm *VM) pruneBlock(b *Block) error {
+	record := &prunedBlock{
+		BlockID:   b.ID(),
+		Block:     b.Block,
+		Data:      b.Data,
+		BaseFee:   b.BaseFee,
+		Legacy:    b.legacy,
+		TxRoot:    b.TxRoot,
+		Rootless:  b.rootless,
+		StateRoot: b.StateRoot,
+		Stateless: b.stateless,
+	}
+	// The data is always at the end of the content
+	end := contentOffset + len(b.getContent())
+	for i := end - len(b.getUploadChunk()); i < end; i++ {
+		record.Data[i] = 0
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, record)
+	if err != nil {
+		return err
+	}
+	id := b.ID()
+	if err := vm.prunedDB.Put(id[:], bytes); err != nil {
+		return err
+	}
+	vm.blockCache.Evict(id)
+	return vm.State.Put(vm.DB, state.BlockTypeID, id, nil)
+}
+
+// pruneExpired deletes the data of the files that expired before the
+// accepted block [b], unless another file that hasn't expired holds it too
+func (vm *VM) pruneExpired(b *Block) error {
+	now := b.Timestamp().Unix()
+	expired := [][]byte{}
+	iter := vm.expiryQueueDB.NewIterator()
+	for iter.Next() {
+		expiry, err := database.ParseUInt64(iter.Key()[:8])
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		if int64(expiry) >= now {
+			break
+		}
+		// The iterator may reuse the key's memory
+		expired = append(expired, append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		owner, fileID := string(key[8:8+addressLen]), string(key[8+addressLen:])
+		if err := vm.pruneFile(b, owner, fileID); err != nil {
+			return err
+		}
+		if err := vm.expiryQueueDB.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneFile deletes the data of [owner]'s file [fileID], which expired or
+// was deleted, as of the accepted block [b]
+func (vm *VM) pruneFile(b *Block, owner string, fileID string) error {
+	iter := vm.chunkDB.NewIteratorWithPrefix(fileKey(owner, fileID))
+	chunkIDs := []ids.ID{}
+	for iter.Next() {
+		chunkID, err := ids.ToID(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	for _, chunkID := range chunkIDs {
+		chunk, err := vm.getBlock(chunkID)
+		if err != nil {
+			return err
+		}
+		if chunk.pruned {
+			continue
+		}
+		hash, err := chunk.getChunkHash()
+		if err != nil {
+			return err
+		}
+		live, err := b.isContentLive(hash, b.Timestamp().Unix())
+		if err != nil {
+			return err
+		}
+		if live {
+			// Another file still holds this data
+			continue
+		}
+		if err := vm.pruneContent(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneContent deletes the data whose SHA-256 is [hash] from every
+// accepted upload that holds it
+func (vm *VM) pruneContent(hash [32]byte) error {
+	iter := vm.contentHolderDB.NewIteratorWithPrefix(hash[:])
+	holderIDs := []ids.ID{}
+	for iter.Next() {
+		holderID, err := ids.ToID(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		holderIDs = append(holderIDs, holderID)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	for _, holderID := range holderIDs {
+		holder, err := vm.getBlock(holderID)
+		if err != nil {
+			return err
+		}
+		if holder.pruned || holder.isReferenceBlock() {
+			continue
+		}
+		if err := vm.pruneBlock(holder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+)
+
+const (
+	// Length of a renewal's content: file ID, number of days and rent
+	renewLen = 16 + 8 + 16
+
+	// Length of the fixed part of an upload with retention, before the
+	// chunk data: file ID, chunk number, number of days and rent
+	retentionUploadHeaderLen = uploadHeaderLen + 8 + 16
+
+	secondsPerDay = 24 * 60 * 60
+)
+
+var (
+	expiryIndexPrefix        = []byte("expiry")
+	expiryQueuePrefix        = []byte("expiryQueue")
+	contentHolderIndexPrefix = []byte("contentHolder")
+	rentPaidIndexPrefix      = []byte("rentPaid")
+	retentionIndexPrefix     = []byte("retention")
+
+	errMalformedRenewal = errors.New("renewal is malformed")
+	errRentDisabled     = errors.New("files on this chain don't expire")
+	errWrongRent        = errors.New("renewal's rent isn't the rent for the file's size and the number of days")
+	errFileExpired      = errors.New("file has expired")
+	errChunkExpired     = errors.New("every file holding the chunk has expired or was deleted")
+	errMalformedUpload  = errors.New("upload with retention is malformed")
+	errWrongUploadRent  = errors.New("upload's rent isn't the rent for the data it adds to the file, for the file's days")
+	errRentRequired     = errors.New("file was uploaded with a retention, so its chunks have to be too")
+)
+
+// A renewal extends how long one of the signer's files is kept by a number
+// of days, and pays rent for them. It's only accepted before the file
+// expires, since nodes are allowed to delete the data of expired files.
+func (b *Block) isRenewBlock() bool {
+	return b.getBlockType() == "6"
+}
+
+func (b *Block) getRenewFileID() string {
+	return string(b.Data[contentOffset : contentOffset+16])
+}
+
+func (b *Block) getRenewDays() int64 {
+	daysBytes := b.Data[contentOffset+16 : contentOffset+16+8]
+	return b.convertBytesToInt(daysBytes)
+}
+
+// returns the rent the signer pays for the renewal. It's in the
+// transaction, and checked against the file's size when it's verified, so
+// balances don't depend on what the file looked like back then.
+func (b *Block) getRenewRent() int64 {
+	rentBytes := b.Data[contentOffset+16+8 : contentOffset+renewLen]
+	return b.convertBytesToInt(rentBytes)
+}
+
+// An upload with retention (type e) is a data chunk that pays rent. The
+// first one of a file declares how many days the file is kept, and pays
+// rent for its chunk for them. The later ones declare 0 days, and pay rent
+// for the data they add for the file's days left, so a file's chunks pay
+// for the whole file. Once a file is uploaded with a retention, the other
+// kinds of uploads can't add to it.
+func (b *Block) isRetentionUploadBlock() bool {
+	return b.getBlockType() == "e"
+}
+
+func (b *Block) getRetentionDays() int64 {
+	daysBytes := b.Data[contentOffset+uploadHeaderLen : contentOffset+uploadHeaderLen+8]
+	return b.convertBytesToInt(daysBytes)
+}
+
+// returns the rent the signer pays for the upload. Like a renewal's, it's
+// in the transaction, and checked when it's verified.
+func (b *Block) getRetentionRent() int64 {
+	rentBytes := b.Data[contentOffset+uploadHeaderLen+8 : contentOffset+retentionUploadHeaderLen]
+	return b.convertBytesToInt(rentBytes)
+}
+
+// getTxRent returns the rent the transaction in this block pays
+func (b *Block) getTxRent() int64 {
+	if b.isRenewBlock() {
+		return b.getRenewRent()
+	}
+	if b.isRetentionUploadBlock() {
+		return b.getRetentionRent()
+	}
+	return 0
+}
+
+// computeDaysLeft returns the number of days, counting a partial day as a
+// whole one, from [time] to [expiry]
+func computeDaysLeft(expiry int64, time int64) int64 {
+	if expiry <= time {
+		return 0
+	}
+	return (expiry - time + secondsPerDay - 1) / secondsPerDay
+}
+
+// rentEnabled returns true iff files on this chain expire
+func (vm *VM) rentEnabled() bool {
+	return vm.params.RentPerKBDay > 0
+}
+
+// computeRent returns the rent for keeping [size] bytes for [days] days.
+// Partial KBs are rounded up, and every file pays for at least 1 KB.
+func (vm *VM) computeRent(size uint64, days int64) int64 {
+	kbs := (size + 1023) / 1024
+	if kbs == 0 {
+		kbs = 1
+	}
+	return int64(kbs) * int64(vm.params.RentPerKBDay) * days
+}
+
+// expiryQueueKey returns the key of [owner]'s file [fileID] in the expiry
+// queue. Keys start with the expiry, so the queue iterates soonest first.
+func expiryQueueKey(expiry uint64, owner string, fileID string) []byte {
+	return append(database.PackUInt64(expiry), fileKey(owner, fileID)...)
+}
+
+// contentHolderKey returns the key recording that chunk [chunkNumber] of
+// [owner]'s file [fileID] holds the data whose SHA-256 is [hash]
+func contentHolderKey(hash [32]byte, owner string, fileID string, chunkNumber uint64) []byte {
+	return append(hash[:], chunkKey(owner, fileID, chunkNumber)...)
+}
+
+// getAcceptedFileExpiry returns when [owner]'s file [fileID] expires as of
+// the last accepted block, and false if it doesn't
+func (vm *VM) getAcceptedFileExpiry(owner string, fileID string) (int64, bool, error) {
+	expiry, err := vm.expiryDB.Get(fileKey(owner, fileID))
+	if err == database.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	parsed, err := database.ParseUInt64(expiry)
+	return int64(parsed), err == nil, err
+}
+
+// isAcceptedFileExpired returns true iff [owner]'s file [fileID], as of the
+// last accepted block, has expired by [time]
+func (vm *VM) isAcceptedFileExpired(owner string, fileID string, time int64) (bool, error) {
+	expiry, hasExpiry, err := vm.getAcceptedFileExpiry(owner, fileID)
+	return hasExpiry && time > expiry, err
+}
+
+// getFileExpiry returns when [creator]'s file [fileID] expires as of this
+// block, and false if it doesn't. A file's first upload keeps it for the
+// chain's upload retention, and renewals extend that.
+func (b *Block) getFileExpiry(creator string, fileID string) (int64, bool, error) {
+	expiry, hasExpiry, err := b.vm.getAcceptedFileExpiry(creator, fileID)
+	if err != nil || !b.vm.rentEnabled() {
+		return expiry, hasExpiry, err
+	}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return 0, false, err
+	}
+	for i := len(ancestry) - 1; i >= 0; i-- {
+		block := ancestry[i]
+		if !block.isUploadBlock() && !block.isRenewBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return 0, false, err
+		} else if !isOfFile {
+			continue
+		}
+		if !hasExpiry && block.isUploadBlock() {
+			expiry, hasExpiry = block.getUploadExpiry(), true
+		} else if hasExpiry && block.isRenewBlock() {
+			expiry += block.getRenewDays() * secondsPerDay
+		}
+	}
+	return expiry, hasExpiry, nil
+}
+
+// getUploadExpiry returns when the file this upload creates expires, if
+// it's the file's first one. An upload with retention declares it, and
+// the other uploads keep the file for the chain's upload retention.
+func (b *Block) getUploadExpiry() int64 {
+	if b.isRetentionUploadBlock() {
+		return b.Timestamp().Unix() + b.getRetentionDays()*secondsPerDay
+	}
+	return b.Timestamp().Unix() + int64(b.vm.params.UploadRetention)
+}
+
+// hasRetention returns true iff [creator]'s file [fileID] was created by an
+// upload with retention, as of this block
+func (b *Block) hasRetention(creator string, fileID string) (bool, error) {
+	if has, err := b.vm.retentionDB.Has(fileKey(creator, fileID)); err != nil || has {
+		return has, err
+	}
+	if hasFile, err := b.vm.fileDB.Has(fileKey(creator, fileID)); err != nil || hasFile {
+		return false, err
+	}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return false, err
+	}
+	for i := len(ancestry) - 1; i >= 0; i-- {
+		block := ancestry[i]
+		if !block.isUploadBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return false, err
+		} else if isOfFile {
+			return block.isRetentionUploadBlock(), nil
+		}
+	}
+	return false, nil
+}
+
+// isFileExpired returns true iff [creator]'s file [fileID] has expired by
+// [time], as of this block
+func (b *Block) isFileExpired(creator string, fileID string, time int64) (bool, error) {
+	expiry, hasExpiry, err := b.getFileExpiry(creator, fileID)
+	return hasExpiry && time > expiry, err
+}
+
+// getFileSize returns the size of [creator]'s file [fileID] as of this
+// block
+func (b *Block) getFileSize(creator string, fileID string) (uint64, error) {
+	var size uint64
+	record, err := b.vm.getFileRecord(creator, fileID)
+	if err == nil {
+		size = record.Size
+	} else if err != errNoSuchFile {
+		return 0, err
+	}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return 0, err
+	}
+	for _, block := range ancestry {
+		if !block.isUploadBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return 0, err
+		} else if isOfFile {
+			size += uint64(len(block.getUploadChunk()))
+		}
+	}
+	return size, nil
+}
+
+// getRentPaid returns the rent paid for [creator]'s file [fileID] by all of
+// its renewals and uploads with retention, as of this block
+func (b *Block) getRentPaid(creator string, fileID string) (int64, error) {
+	var rentPaid int64
+	bytes, err := b.vm.rentPaidDB.Get(fileKey(creator, fileID))
+	if err == nil {
+		parsed, err := database.ParseUInt64(bytes)
+		if err != nil {
+			return 0, err
+		}
+		rentPaid = int64(parsed)
+	} else if err != database.ErrNotFound {
+		return 0, err
+	}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return 0, err
+	}
+	for _, block := range ancestry {
+		if block.getTxRent() == 0 {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return 0, err
+		} else if isOfFile {
+			rentPaid += block.getTxRent()
+		}
+	}
+	return rentPaid, nil
+}
+
+// isContentLive returns true iff some file holding the data whose SHA-256
+// is [hash] hasn't expired by [time] or been deleted, as of this block.
+// Data that isn't live may have been pruned, so it can't be referenced.
+func (b *Block) isContentLive(hash [32]byte, time int64) (bool, error) {
+	type file struct{ creator, fileID string }
+	files := []file{}
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return false, err
+	}
+	for _, block := range ancestry {
+		if !block.isUploadBlock() {
+			continue
+		}
+		if blockHash, err := block.getChunkHash(); err == nil && blockHash == hash {
+			creator, err := block.getFileCreator()
+			if err != nil {
+				return false, err
+			}
+			files = append(files, file{creator, block.getUploadFileID()})
+		}
+	}
+	iter := b.vm.contentHolderDB.NewIteratorWithPrefix(hash[:])
+	for iter.Next() {
+		key := iter.Key()[len(hash):]
+		files = append(files, file{string(key[:addressLen]), string(key[addressLen : addressLen+fileIDLen])})
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return false, err
+	}
+
+	for _, f := range files {
+		deleted, err := b.isFileDeleted(f.creator, f.fileID)
+		if err != nil {
+			return false, err
+		}
+		if deleted {
+			continue
+		}
+		expired, err := b.isFileExpired(f.creator, f.fileID, time)
+		if err != nil {
+			return false, err
+		}
+		if !expired {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifyNotExpired returns nil iff [creator]'s file [fileID], as of this
+// block's parent, hasn't expired by this block's time
+func (b *Block) verifyNotExpired(parent *Block, creator string, fileID string) error {
+	expired, err := parent.isFileExpired(creator, fileID, b.Timestamp().Unix())
+	if err != nil {
+		return err
+	}
+	if expired {
+		return errFileExpired
+	}
+	return nil
+}
+
+// verifyRenew returns nil iff this renewal is of one of the signer's files
+// that hasn't expired, and pays the right rent for it
+func (b *Block) verifyRenew(parent *Block) error {
+	if !b.vm.rentEnabled() {
+		return errRentDisabled
+	}
+	if b.getContentLength() != renewLen || b.getRenewDays() <= 0 {
+		return errMalformedRenewal
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	fileID := b.getRenewFileID()
+	_, hasExpiry, err := parent.getFileExpiry(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if !hasExpiry {
+		return errNoSuchFile
+	}
+	if err := b.verifyNotExpired(parent, creator, fileID); err != nil {
+		return err
+	}
+	size, err := parent.getFileSize(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if b.getRenewRent() != b.vm.computeRent(size, b.getRenewDays()) {
+		return errWrongRent
+	}
+	return nil
+}
+
+// verifyUploadRent returns nil iff this upload pays the rent it has to for
+// [creator]'s file: an upload with retention the rent for the data it adds,
+// and the other uploads none, which they can't add to a file that was
+// uploaded with a retention
+func (b *Block) verifyUploadRent(parent *Block, creator string) error {
+	fileID := b.getUploadFileID()
+	if !b.isRetentionUploadBlock() {
+		if !b.vm.rentEnabled() {
+			return nil
+		}
+		hasRetention, err := parent.hasRetention(creator, fileID)
+		if err != nil {
+			return err
+		}
+		if hasRetention {
+			return errRentRequired
+		}
+		return nil
+	}
+	if !b.vm.rentEnabled() {
+		return errRentDisabled
+	}
+	if b.getContentLength() < retentionUploadHeaderLen {
+		return errMalformedUpload
+	}
+	expiry, hasExpiry, err := parent.getFileExpiry(creator, fileID)
+	if err != nil {
+		return err
+	}
+	chunkSize := uint64(len(b.getUploadChunk()))
+	if !hasExpiry {
+		// The file's first upload declares how long it's kept
+		if b.getRetentionDays() <= 0 {
+			return errMalformedUpload
+		}
+		if b.getRetentionRent() != b.vm.computeRent(chunkSize, b.getRetentionDays()) {
+			return errWrongUploadRent
+		}
+		return nil
+	}
+	if b.getRetentionDays() != 0 {
+		return errMalformedUpload
+	}
+	size, err := parent.getFileSize(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if b.getRetentionRent() != b.vm.computeAddedRent(size, chunkSize, computeDaysLeft(expiry, b.Timestamp().Unix())) {
+		return errWrongUploadRent
+	}
+	return nil
+}
+
+// computeAddedRent returns the rent for adding [added] bytes to a file of
+// [size] bytes, which is kept for [days] more days
+func (vm *VM) computeAddedRent(size uint64, added uint64, days int64) int64 {
+	return vm.computeRent(size+added, days) - vm.computeRent(size, days)
+}
+
+// putFileExpiry sets when [owner]'s file [fileID] expires, replacing
+// [oldExpiry] in the expiry queue if [hadExpiry]
+func (vm *VM) putFileExpiry(owner string, fileID string, expiry int64, oldExpiry int64, hadExpiry bool) error {
+	if hadExpiry {
+		if err := vm.expiryQueueDB.Delete(expiryQueueKey(uint64(oldExpiry), owner, fileID)); err != nil {
+			return err
+		}
+	}
+	if err := vm.expiryQueueDB.Put(expiryQueueKey(uint64(expiry), owner, fileID), nil); err != nil {
+		return err
+	}
+	return vm.expiryDB.Put(fileKey(owner, fileID), database.PackUInt64(uint64(expiry)))
+}
+
+// indexExpiry updates the expiries of files with the accepted block [b],
+// then deletes the data of files that expired before it, if this node
+// prunes
+func (vm *VM) indexExpiry(b *Block) error {
+	if b.isUploadBlock() {
+		owner, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		fileID := b.getUploadFileID()
+		hash, err := b.getChunkHash()
+		if err != nil {
+			return err
+		}
+		if err := database.PutID(vm.contentHolderDB, contentHolderKey(hash, owner, fileID, uint64(b.getUploadChunkNumber())), b.ID()); err != nil {
+			return err
+		}
+		if vm.rentEnabled() {
+			_, hasExpiry, err := vm.getAcceptedFileExpiry(owner, fileID)
+			if err != nil {
+				return err
+			}
+			if !hasExpiry {
+				if err := vm.putFileExpiry(owner, fileID, b.getUploadExpiry(), 0, false); err != nil {
+					return err
+				}
+				if b.isRetentionUploadBlock() {
+					if err := vm.retentionDB.Put(fileKey(owner, fileID), nil); err != nil {
+						return err
+					}
+				}
+			}
+			if b.isRetentionUploadBlock() {
+				if err := vm.addRentPaid(b, owner, fileID); err != nil {
+					return err
+				}
+			}
+		}
+	} else if b.isRenewBlock() {
+		owner, err := b.getFileCreator()
+		if err != nil {
+			return err
+		}
+		fileID := b.getRenewFileID()
+		expiry, _, err := vm.getAcceptedFileExpiry(owner, fileID)
+		if err != nil {
+			return err
+		}
+		if err := vm.putFileExpiry(owner, fileID, expiry+b.getRenewDays()*secondsPerDay, expiry, true); err != nil {
+			return err
+		}
+		if err := vm.addRentPaid(b, owner, fileID); err != nil {
+			return err
+		}
+	}
+
+	if !vm.config.PruneExpired {
+		return nil
+	}
+	return vm.pruneExpired(b)
+}
+
+// addRentPaid adds the rent the accepted block [b] paid to the rent paid
+// for [owner]'s file [fileID]
+func (vm *VM) addRentPaid(b *Block, owner string, fileID string) error {
+	rentPaid, err := b.getRentPaid(owner, fileID)
+	if err != nil {
+		return err
+	}
+	return vm.rentPaidDB.Put(fileKey(owner, fileID), database.PackUInt64(uint64(rentPaid+b.getTxRent())))
+}
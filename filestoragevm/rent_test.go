@@ -0,0 +1,274 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/snow/choices"
+)
+
+// Rent is 2 per KB per day, and uploads are kept for a minute
+var testRentGenesis = []byte(`{"rentPerKBDay": 2, "uploadRetention": 60}`)
+
+// newTestRenewPayload returns a signed renewal of [fileID] for [days]
+// days, paying [rent]
+func newTestRenewPayload(t *testing.T, key *testKey, fileID string, days int, rent int) [dataLen]byte {
+	return newTestPayload(t, key, '6', fmt.Sprintf("%s%08d%016d", fileID, days, rent))
+}
+
+// newTestRetentionUploadPayload returns a signed upload with retention of
+// [chunk] as chunk [chunkNumber] of [fileID], for [days] days, paying [rent]
+func newTestRetentionUploadPayload(t *testing.T, key *testKey, fileID string, chunkNumber int, days int, rent int, chunk string) [dataLen]byte {
+	return newTestPayload(t, key, 'e', fmt.Sprintf("%s%08d%08d%016d%s", fileID, chunkNumber, days, rent, chunk))
+}
+
+// newTestBlockAt returns a block with [data] and timestamp [timestamp]
+// built on the preferred block
+func newTestBlockAt(t *testing.T, vm *VM, data [dataLen]byte, timestamp time.Time) *Block {
+	preferred, err := vm.getBlock(vm.Preferred())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := vm.NewBlock(preferred.ID(), preferred.Height()+1, data, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// acceptTestBlockAt verifies and accepts a block with [data] and
+// timestamp [timestamp] built on the preferred block
+func acceptTestBlockAt(t *testing.T, vm *VM, data [dataLen]byte, timestamp time.Time) *Block {
+	block := newTestBlockAt(t, vm, data, timestamp)
+	if err := block.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.SetPreference(block.ID()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestRenewFile(t *testing.T) {
+	vm, ctx, _ := newTestVMWithGenesis(t, testRentGenesis)
+	key := newTestKey(t)
+	// Far enough in the past that the files have expired by now
+	start := time.Now().Add(-time.Hour)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1000, key.address), start)
+	acceptTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"), start)
+	acceptTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000002", 0, "world"), start)
+
+	service := Service{vm}
+	rentReply := GetRentReply{}
+	if err := service.GetRent(nil, &GetRentArgs{Owner: key.address, FileID: "file000000000001", Days: 3}, &rentReply); err != nil {
+		t.Fatal(err)
+	}
+	// 5 bytes are charged as 1 KB
+	if rentReply.Rent != 6 || rentReply.Size != 5 || int64(rentReply.Expiry) != start.Unix()+60 {
+		t.Fatalf("unexpected rent %+v", rentReply)
+	}
+
+	wrongRent := newTestBlockAt(t, vm, newTestRenewPayload(t, key, "file000000000001", 3, 5), start.Add(10*time.Second))
+	if err := wrongRent.Verify(); err != errWrongRent {
+		t.Fatalf("expected %s but got %v", errWrongRent, err)
+	}
+	renew := acceptTestBlockAt(t, vm, newTestRenewPayload(t, key, "file000000000001", 3, 6), start.Add(10*time.Second))
+	// 1 for each upload and the renewal, and 6 rent
+	if balance := getTestBalance(t, renew, key.address); balance != 1000-3-6 {
+		t.Fatalf("expected a balance of %d but got %d", 1000-3-6, balance)
+	}
+	expiry, _, err := vm.getAcceptedFileExpiry(key.address, "file000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry != start.Unix()+60+3*secondsPerDay {
+		t.Fatalf("expected the file to expire at %d but it expires at %d", start.Unix()+60+3*secondsPerDay, expiry)
+	}
+
+	// The second file expired after a minute
+	later := start.Add(2 * time.Minute)
+	if err := newTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000002", 1, "!"), later).Verify(); err != errFileExpired {
+		t.Fatalf("expected %s but got %v", errFileExpired, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestRenewPayload(t, key, "file000000000002", 1, 2), later).Verify(); err != errFileExpired {
+		t.Fatalf("expected %s but got %v", errFileExpired, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestReferencePayload(t, key, "file000000000003", 0, hashChunk([]byte("world"))), later).Verify(); err != errChunkExpired {
+		t.Fatalf("expected %s but got %v", errChunkExpired, err)
+	}
+	// Files expire as of the last accepted block's timestamp
+	if err := service.GetFile(nil, &GetFileArgs{Owner: key.address, FileID: "file000000000002"}, &GetFileReply{}); err != nil {
+		t.Fatal(err)
+	}
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), later)
+	if err := service.GetFile(nil, &GetFileArgs{Owner: key.address, FileID: "file000000000002"}, &GetFileReply{}); err != errFileExpired {
+		t.Fatalf("expected %s but got %v", errFileExpired, err)
+	}
+
+	gateway := &fileGateway{vm}
+	for fileID, expectedStatus := range map[string]int{"file000000000001": http.StatusOK, "file000000000002": http.StatusGone} {
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=%s", key.address, fileID), nil))
+		if status := recorder.Result().StatusCode; status != expectedStatus {
+			t.Fatalf("expected status %d for %s but got %d", expectedStatus, fileID, status)
+		}
+	}
+}
+
+func TestRetentionUpload(t *testing.T) {
+	vm, ctx, _ := newTestVMWithGenesis(t, testRentGenesis)
+	key := newTestKey(t)
+	start := time.Now().Add(-time.Hour)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1000, key.address), start)
+
+	service := Service{vm}
+	estimate := EstimateFeeReply{}
+	if err := service.EstimateFee(nil, &EstimateFeeArgs{Size: 2005, Days: 3}, &estimate); err != nil {
+		t.Fatal(err)
+	}
+	// 2005 bytes are charged as 2 KB
+	if estimate.Rent != 12 {
+		t.Fatalf("expected a rent of 12 but got %d", estimate.Rent)
+	}
+
+	// The first upload pays for its chunk for the days it declares
+	if err := newTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 0, 0, 0, "hello"), start).Verify(); err != errMalformedUpload {
+		t.Fatalf("expected %s but got %v", errMalformedUpload, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 0, 3, 5, "hello"), start).Verify(); err != errWrongUploadRent {
+		t.Fatalf("expected %s but got %v", errWrongUploadRent, err)
+	}
+	first := acceptTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 0, 3, 6, "hello"), start)
+	// 1 for the upload, and 6 rent
+	if balance := getTestBalance(t, first, key.address); balance != 1000-1-6 {
+		t.Fatalf("expected a balance of %d but got %d", 1000-1-6, balance)
+	}
+	expiry, _, err := vm.getAcceptedFileExpiry(key.address, "file000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry != start.Unix()+3*secondsPerDay {
+		t.Fatalf("expected the file to expire at %d but it expires at %d", start.Unix()+3*secondsPerDay, expiry)
+	}
+
+	// The later ones pay for the data they add, for the file's days left
+	chunk := strings.Repeat("a", 2000)
+	rentReply := GetUploadRentReply{}
+	if err := service.GetUploadRent(nil, &GetUploadRentArgs{Owner: key.address, FileID: "file000000000001", ChunkSize: 2000}, &rentReply); err != nil {
+		t.Fatal(err)
+	}
+	if rentReply.Rent != 6 || rentReply.First {
+		t.Fatalf("unexpected upload rent %+v", rentReply)
+	}
+	later := start.Add(10 * time.Second)
+	if err := newTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 1, 3, 6, chunk), later).Verify(); err != errMalformedUpload {
+		t.Fatalf("expected %s but got %v", errMalformedUpload, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 1, 0, 12, chunk), later).Verify(); err != errWrongUploadRent {
+		t.Fatalf("expected %s but got %v", errWrongUploadRent, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000001", 1, chunk), later).Verify(); err != errRentRequired {
+		t.Fatalf("expected %s but got %v", errRentRequired, err)
+	}
+	second := acceptTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000001", 1, 0, 6, chunk), later)
+	if balance := getTestBalance(t, second, key.address); balance != 1000-2-12 {
+		t.Fatalf("expected a balance of %d but got %d", 1000-2-12, balance)
+	}
+	rentPaid, err := second.getRentPaid(key.address, "file000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rentPaid != 12 {
+		t.Fatalf("expected 12 rent paid but got %d", rentPaid)
+	}
+	// Plain uploads still can't add to the file once it's accepted
+	if err := newTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000001", 2, "!"), later).Verify(); err != errRentRequired {
+		t.Fatalf("expected %s but got %v", errRentRequired, err)
+	}
+}
+
+func TestRentDisabled(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"))
+
+	renew := newTestBlockAt(t, vm, newTestRenewPayload(t, key, "file000000000001", 1, 0), time.Now())
+	if err := renew.Verify(); err != errRentDisabled {
+		t.Fatalf("expected %s but got %v", errRentDisabled, err)
+	}
+	upload := newTestBlockAt(t, vm, newTestRetentionUploadPayload(t, key, "file000000000002", 0, 1, 2, "hello"), time.Now())
+	if err := upload.Verify(); err != errRentDisabled {
+		t.Fatalf("expected %s but got %v", errRentDisabled, err)
+	}
+	if _, hasExpiry, err := vm.getAcceptedFileExpiry(key.address, "file000000000001"); err != nil || hasExpiry {
+		t.Fatalf("expected the file to never expire but got %v, %v", hasExpiry, err)
+	}
+}
+
+func TestPruneExpired(t *testing.T) {
+	vm, ctx, _ := newTestVMWithConfig(t, testRentGenesis, []byte(`{"pruneExpired": true}`))
+	key := newTestKey(t)
+	start := time.Now().Add(-time.Hour)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1000, key.address), start)
+	hello := acceptTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"), start)
+	world := acceptTestBlockAt(t, vm, newTestUploadPayload(t, key, "file000000000002", 0, "world"), start)
+
+	// The third file references the second one's data and is kept for a
+	// day, so the data is kept after the second file expires
+	acceptTestBlockAt(t, vm, newTestReferencePayload(t, key, "file000000000003", 0, hashChunk([]byte("world"))), start)
+	acceptTestBlockAt(t, vm, newTestRenewPayload(t, key, "file000000000003", 1, 2), start)
+	balance := getTestBalance(t, world, key.address)
+
+	// Accepting a block after the first two files expire prunes them
+	last := acceptTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), start.Add(2*time.Minute))
+
+	pruned, err := vm.getBlock(hello.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pruned.pruned || pruned.ID() != hello.ID() || pruned.Status() != choices.Accepted {
+		t.Fatalf("expected the first file's chunk to be pruned, keeping its ID and status")
+	}
+	if pruned.getUploadChunk() != nil || pruned.getUploadFileID() != "file000000000001" {
+		t.Fatalf("expected the chunk's data to be gone and its header kept")
+	}
+	if kept, err := vm.getBlock(world.ID()); err != nil || kept.pruned {
+		t.Fatalf("expected the second file's chunk to be kept but got %v", err)
+	}
+	// Pruning doesn't change anyone's balance
+	if newBalance := getTestBalance(t, last, key.address); newBalance != balance-1-(1+2)+1 {
+		t.Fatalf("expected a balance of %d but got %d", balance-1-(1+2)+1, newBalance)
+	}
+
+	service := Service{vm}
+	chunkReply := GetChunkReply{}
+	if err := service.GetChunk(nil, &GetChunkArgs{Hash: hashChunk([]byte("world"))}, &chunkReply); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.GetChunk(nil, &GetChunkArgs{Hash: hashChunk([]byte("hello"))}, &GetChunkReply{}); err != errChunkExpired {
+		t.Fatalf("expected %s but got %v", errChunkExpired, err)
+	}
+}
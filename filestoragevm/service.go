@@ -4,20 +4,36 @@
 package filestoragevm
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ava-labs/avalanchego/database"
 	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
 	"github.com/ava-labs/avalanchego/utils/crypto"
 	"github.com/ava-labs/avalanchego/utils/formatting"
 	"github.com/ava-labs/avalanchego/utils/json"
 )
 
+const (
+	// maxBlockRange is the most blocks GetBlocksByHeight returns at once
+	maxBlockRange = 100
+
+	// maxPageSize is the most items a paginated method returns at once
+	maxPageSize = 100
+)
+
 var (
 	errBadData     = errors.New("data must be base 58 repr. of 32 bytes")
 	errNoSuchBlock = errors.New("couldn't get block from database. Does it exist?")
+	errBadRange    = errors.New("end height must not be below start height")
+	errBlockAndHeight = errors.New("pass a block ID or a height, not both")
+	errBadChunkCursor = errors.New("cursor must be a chunk number")
 )
 
 // Service is the API service for this VM
@@ -48,10 +64,159 @@ func (s *Service) ProposeBlock(_ *http.Request, args *ProposeBlockArgs, reply *P
 
 // APIBlock is the API representation of a block
 type APIBlock struct {
-	Timestamp json.Uint64 `json:"timestamp"` // Timestamp of most recent block
-	Data      string      `json:"data"`      // Data in the most recent block. Base 58 repr. of 5 bytes.
-	ID        string      `json:"id"`        // String repr. of ID of the most recent block
-	ParentID  string      `json:"parentID"`  // String repr. of ID of the most recent block's parent
+	Timestamp json.Uint64 `json:"timestamp"`    // Timestamp of most recent block
+	Data      string      `json:"data"`         // Data in the most recent block. Base 58 repr. of 5 bytes.
+	ID        string      `json:"id"`           // String repr. of ID of the most recent block
+	ParentID  string      `json:"parentID"`     // String repr. of ID of the most recent block's parent
+	Height    json.Uint64 `json:"height"`       // Height of the block. The genesis block is at height 0.
+	BaseFee   json.Uint64 `json:"baseFee"`      // Base fee every upload in the block pays
+	StateRoot string      `json:"stateRoot"`    // Hex encoded Merkle root of the state as of the block. Empty for blocks from before state roots.
+	TxRoot    string      `json:"txRoot"`       // Hex encoded Merkle root of the block's transactions. Empty for blocks from before tx roots.
+	Tx        *APITx      `json:"tx,omitempty"` // The decoded transaction, if it was asked for
+}
+
+// APITx is the decoded API representation of the transaction in a block.
+// Only the fields that apply to the transaction's type are filled out.
+// See TRANSACTION.md for the layout these are read from.
+type APITx struct {
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	Signer         string      `json:"signer"`
+	SignatureValid bool        `json:"signatureValid"`
+	ContentLength  json.Uint64 `json:"contentLength"`
+
+	// Transfer, faucet and stake transactions. File transfers have a
+	// recipient too.
+	Amount    json.Uint64 `json:"amount,omitempty"`
+	Sender    string      `json:"sender,omitempty"`
+	Recipient string      `json:"recipient,omitempty"`
+
+	// Stake transactions
+	NodeID     string      `json:"nodeID,omitempty"`
+	StakeStart json.Uint64 `json:"stakeStart,omitempty"`
+	StakeEnd   json.Uint64 `json:"stakeEnd,omitempty"`
+
+	// Upload, manifest, renewal, deletion, file transfer, file access and
+	// rename transactions
+	FileID      string       `json:"fileID,omitempty"`
+	ChunkNumber *json.Uint64 `json:"chunkNumber,omitempty"`
+
+	// Upload transactions. For a chunk reference it's the hash it names.
+	ChunkHash string `json:"chunkHash,omitempty"`
+
+	// Renewals
+	RetentionDays json.Uint64 `json:"retentionDays,omitempty"`
+	Rent          json.Uint64 `json:"rent,omitempty"`
+
+	// Deletions
+	Refund json.Uint64 `json:"refund,omitempty"`
+
+	// File access changes: the account whose role is set, and the role
+	Account string `json:"account,omitempty"`
+	Role    string `json:"role,omitempty"`
+
+	// Renames: the path the file is moved to
+	Path string `json:"path,omitempty"`
+
+	// Manifest transactions. Anchors have the hash they record as
+	// [ContentHash].
+	FileName    string      `json:"fileName,omitempty"`
+	MimeType    string      `json:"mimeType,omitempty"`
+	TotalSize   json.Uint64 `json:"totalSize,omitempty"`
+	ChunkCount  json.Uint64 `json:"chunkCount,omitempty"`
+	ContentHash string      `json:"contentHash,omitempty"`
+	ChunkIDs    []string    `json:"chunkIDs,omitempty"`
+}
+
+// newAPITx decodes the transaction in [block]
+func newAPITx(block *Block) *APITx {
+	tx := &APITx{
+		ID:             block.txID().String(),
+		Type:           block.getTxType(),
+		Signer:         block.getSigner(),
+		SignatureValid: block.hasValidSignature(),
+		ContentLength:  json.Uint64(block.getContentLength()),
+	}
+	if block.isUploadBlock() {
+		chunkNumber := json.Uint64(block.getUploadChunkNumber())
+		tx.FileID = block.getUploadFileID()
+		tx.ChunkNumber = &chunkNumber
+		if hash, err := block.getChunkHash(); err == nil {
+			tx.ChunkHash = hex.EncodeToString(hash[:])
+		}
+		if block.isRetentionUploadBlock() {
+			tx.RetentionDays = json.Uint64(block.getRetentionDays())
+			tx.Rent = json.Uint64(block.getRetentionRent())
+		}
+	} else if block.isManifestBlock() {
+		tx.FileID = block.getManifestFileID()
+		tx.FileName = block.getManifestName()
+		tx.MimeType = block.getManifestMimeType()
+		tx.TotalSize = json.Uint64(block.getManifestSize())
+		tx.ChunkCount = json.Uint64(block.getManifestChunkCount())
+		tx.ContentHash = block.getManifestHash()
+		chunkIDs, _ := block.getManifestChunkIDs()
+		for _, chunkID := range chunkIDs {
+			tx.ChunkIDs = append(tx.ChunkIDs, chunkID.String())
+		}
+	} else if block.isRenewBlock() {
+		tx.FileID = block.getRenewFileID()
+		tx.RetentionDays = json.Uint64(block.getRenewDays())
+		tx.Rent = json.Uint64(block.getRenewRent())
+	} else if block.isDeleteBlock() {
+		tx.FileID = block.getDeleteFileID()
+		tx.Refund = json.Uint64(block.getDeleteRefund())
+	} else if block.isFileTransferBlock() {
+		tx.FileID = block.getFileTransferFileID()
+		tx.Recipient = block.getFileTransferRecipient()
+	} else if block.isFileAccessBlock() {
+		tx.FileID = block.getFileAccessFileID()
+		tx.Account = block.getFileAccessAccount()
+		tx.Role = block.getFileAccessRole().String()
+	} else if block.isRenameBlock() {
+		tx.FileID = block.getRenameFileID()
+		tx.Path, _ = block.getRenamePath()
+	} else if block.isAnchorBlock() {
+		tx.ContentHash = block.getAnchorHash()
+	} else if block.isTransferBlock() {
+		tx.Amount = json.Uint64(block.getTransferAmount())
+		tx.Sender = block.getTransferSender()
+		tx.Recipient = block.getTransferRecipient()
+	} else if block.isStakeBlock() {
+		tx.Amount = json.Uint64(block.getStakeAmount())
+		tx.NodeID = block.getStakeNode()
+		tx.Recipient = block.getStakeRewardAddress()
+		tx.StakeStart = json.Uint64(block.getStakeStart())
+		tx.StakeEnd = json.Uint64(block.getStakeEnd())
+	} else if block.isFaucetBlock() {
+		tx.Amount = json.Uint64(block.getFaucetAmount())
+		tx.Recipient = block.getFaucetRecipient()
+	}
+	return tx
+}
+
+// newAPIBlock returns the API representation of [block].
+// If [decode] is true, the transaction in the block is decoded as well.
+func newAPIBlock(block *Block, decode bool) (APIBlock, error) {
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, block.Data[:])
+	apiBlock := APIBlock{
+		Timestamp: json.Uint64(block.Timestamp().Unix()),
+		Data:      data,
+		ID:        block.ID().String(),
+		ParentID:  block.Parent().String(),
+		Height:    json.Uint64(block.Height()),
+		BaseFee:   json.Uint64(block.getBaseFee()),
+	}
+	if !block.stateless {
+		apiBlock.StateRoot = hex.EncodeToString(block.StateRoot[:])
+	}
+	if !block.rootless {
+		apiBlock.TxRoot = hex.EncodeToString(block.TxRoot[:])
+	}
+	if decode {
+		apiBlock.Tx = newAPITx(block)
+	}
+	return apiBlock, err
 }
 
 // GetBlockArgs are the arguments to GetBlock
@@ -59,6 +224,9 @@ type GetBlockArgs struct {
 	// ID of the block we're getting.
 	// If left blank, gets the latest block
 	ID string
+
+	// If true, the reply includes the decoded transaction
+	Decode bool `json:"decode"`
 }
 
 // GetBlockReply is the reply from GetBlock
@@ -97,14 +265,153 @@ func (s *Service) GetBlock(_ *http.Request, args *GetBlockArgs, reply *GetBlockR
 	}
 
 	// Fill out the response with the block's data
-	reply.APIBlock.ID = block.ID().String()
-	reply.APIBlock.Timestamp = json.Uint64(block.Timestamp().Unix())
-	reply.APIBlock.ParentID = block.Parent().String()
-	reply.Data, err = formatting.EncodeWithChecksum(formatting.CB58, block.Data[:])
+	reply.APIBlock, err = newAPIBlock(block, args.Decode)
+	return err
+}
+
+// getBlockAt returns the block with ID [blockID] if it's given, or else
+// the accepted block at [height] if it's given, or else the last accepted
+// block. Queries that take a block use it to find the block to answer as
+// of.
+func (s *Service) getBlockAt(blockID string, height *json.Uint64) (*Block, error) {
+	switch {
+	case blockID != "" && height != nil:
+		return nil, errBlockAndHeight
+	case blockID != "":
+		id, err := ids.FromString(blockID)
+		if err != nil {
+			return nil, errors.New("problem parsing ID")
+		}
+		block, err := s.vm.getBlock(id)
+		if err != nil {
+			return nil, errNoSuchBlock
+		}
+		return block, nil
+	case height != nil:
+		return s.vm.getBlockAtHeight(uint64(*height))
+	}
+	block, err := s.vm.getLastAcceptedBlock()
+	if err != nil {
+		return nil, errNoSuchBlock
+	}
+	return block, nil
+}
+
+// getAcceptedBlockAt is getBlockAt for queries that can only be answered
+// as of an accepted block
+func (s *Service) getAcceptedBlockAt(blockID string, height *json.Uint64) (*Block, error) {
+	block, err := s.getBlockAt(blockID, height)
+	if err != nil {
+		return nil, err
+	}
+	if block.Status() != choices.Accepted {
+		return nil, errNotAccepted
+	}
+	return block, nil
+}
+
+// GetBlockByHeightArgs are the arguments to GetBlockByHeight
+type GetBlockByHeightArgs struct {
+	Height json.Uint64 `json:"height"`
+	Decode bool        `json:"decode"`
+}
+
+// GetBlockByHeightReply is the reply from GetBlockByHeight
+type GetBlockByHeightReply struct {
+	APIBlock
+}
+
+// GetBlockByHeight gets the accepted block at height [args.Height]
+func (s *Service) GetBlockByHeight(_ *http.Request, args *GetBlockByHeightArgs, reply *GetBlockByHeightReply) error {
+	block, err := s.vm.getBlockAtHeight(uint64(args.Height))
+	if err != nil {
+		return err
+	}
+	reply.APIBlock, err = newAPIBlock(block, args.Decode)
+	return err
+}
+
+// GetBlockByTxIDArgs are the arguments to GetBlockByTxID
+type GetBlockByTxIDArgs struct {
+	TxID   string `json:"txID"`
+	Decode bool   `json:"decode"`
+}
 
+// GetBlockByTxIDReply is the reply from GetBlockByTxID
+type GetBlockByTxIDReply struct {
+	APIBlock
+}
+
+// GetBlockByTxID gets the accepted block holding the transaction
+// [args.TxID], like the ones the upload gateway replies with
+func (s *Service) GetBlockByTxID(_ *http.Request, args *GetBlockByTxIDArgs, reply *GetBlockByTxIDReply) error {
+	txID, err := ids.FromString(args.TxID)
+	if err != nil {
+		return errors.New("problem parsing tx ID")
+	}
+	id, err := s.vm.getBlockIDOfTx(txID)
+	if err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(id)
+	if err != nil {
+		return err
+	}
+	reply.APIBlock, err = newAPIBlock(block, args.Decode)
 	return err
 }
 
+// GetBlocksByHeightArgs are the arguments to GetBlocksByHeight
+type GetBlocksByHeightArgs struct {
+	StartHeight json.Uint64 `json:"startHeight"`
+	EndHeight   json.Uint64 `json:"endHeight"`
+	Decode      bool        `json:"decode"`
+}
+
+// GetBlocksByHeightReply is the reply from GetBlocksByHeight
+type GetBlocksByHeightReply struct {
+	Blocks []APIBlock `json:"blocks"`
+}
+
+// GetBlocksByHeight gets the accepted blocks from [args.StartHeight] up to
+// and including [args.EndHeight], in height order.
+// At most [maxBlockRange] blocks are returned, and the range stops early at
+// the last accepted block. A range that starts after the last accepted block
+// is empty.
+func (s *Service) GetBlocksByHeight(_ *http.Request, args *GetBlocksByHeightArgs, reply *GetBlocksByHeightReply) error {
+	if args.EndHeight < args.StartHeight {
+		return errBadRange
+	}
+	lastAccepted, err := s.vm.getLastAcceptedBlock()
+	if err != nil {
+		return errNoSuchBlock
+	}
+	reply.Blocks = []APIBlock{}
+	if uint64(args.StartHeight) > lastAccepted.Height() {
+		return nil
+	}
+	end := uint64(args.EndHeight)
+	if end > lastAccepted.Height() {
+		end = lastAccepted.Height()
+	}
+	if end-uint64(args.StartHeight) >= maxBlockRange {
+		end = uint64(args.StartHeight) + maxBlockRange - 1
+	}
+
+	for height := uint64(args.StartHeight); height <= end; height++ {
+		block, err := s.vm.getBlockAtHeight(height)
+		if err != nil {
+			return err
+		}
+		apiBlock, err := newAPIBlock(block, args.Decode)
+		if err != nil {
+			return err
+		}
+		reply.Blocks = append(reply.Blocks, apiBlock)
+	}
+	return nil
+}
+
 type CreateAddressArgs struct {
 }
 
@@ -135,6 +442,8 @@ type GetBlockHeightReply struct {
 	BlockHeight string `json:"blockHeight"`
 }
 
+// GetBlockHeight returns the ID of the last accepted block, not its height.
+// It's kept for older clients; use GetHeight for the actual height.
 func (s *Service) GetBlockHeight(_ *http.Request, args *GetBlockHeightArgs, reply *GetBlockHeightReply) error {
 	var err error
 	var id ids.ID
@@ -143,57 +452,570 @@ func (s *Service) GetBlockHeight(_ *http.Request, args *GetBlockHeightArgs, repl
 	return err
 }
 
+// GetHeightArgs are the arguments to GetHeight
+type GetHeightArgs struct {
+}
+
+// GetHeightReply is the reply from GetHeight
+type GetHeightReply struct {
+	Height json.Uint64 `json:"height"`
+	ID     string      `json:"id"`
+}
+
+// GetHeight returns the height and ID of the last accepted block
+func (s *Service) GetHeight(_ *http.Request, args *GetHeightArgs, reply *GetHeightReply) error {
+	block, err := s.vm.getLastAcceptedBlock()
+	if err != nil {
+		return errNoSuchBlock
+	}
+	reply.Height = json.Uint64(block.Height())
+	reply.ID = block.ID().String()
+	return nil
+}
+
+// GetStorageCostArgs are the arguments to GetStorageCost
 type GetStorageCostArgs struct {
+	// Number of bytes of data to quote for
+	Size json.Uint64 `json:"size"`
 }
 
+// GetStorageCostReply is the reply from GetStorageCost
 type GetStorageCostReply struct {
+	// Cost of uploading [Size] bytes in as few data chunks as possible.
+	// If [Size] is 0, it's the cost of one full data chunk, which older
+	// clients multiply by their number of chunks.
 	Cost int64 `json:"cost"`
+
+	// Number of data chunks [Cost] is for
+	ChunkCount json.Uint64 `json:"chunkCount"`
+
+	// Cost of referencing a chunk that's already stored instead of
+	// uploading it again
+	ReferenceCost int64 `json:"referenceCost"`
+
+	// The next block's prices. A transaction with n bytes of content costs
+	// baseFee + n * feePerByte.
+	BaseFee    json.Uint64 `json:"baseFee"`
+	FeePerByte json.Uint64 `json:"feePerByte"`
 }
 
+// GetStorageCost quotes the cost of uploading [args.Size] bytes in the
+// next blocks. The base fee can change from block to block, so the quote
+// is only exact if nothing else gets in between. See EstimateFee.
 func (s *Service) GetStorageCost(_ *http.Request, args *GetStorageCostArgs, reply *GetStorageCostReply) error {
-	var err error
-	id, err := s.vm.State.GetLastAccepted(s.vm.DB)
-	blockInterface, err := s.vm.GetBlock(id)
+	preferred, err := s.vm.getBlock(s.vm.Preferred())
 	if err != nil {
 		return errNoSuchBlock
 	}
+	size := int64(args.Size)
+	if size == 0 {
+		size = maxChunkSize
+	}
+	cost, chunks, _ := s.vm.estimateUploadFee(preferred, size, uploadHeaderLen)
+	baseFee := preferred.getNextBaseFee()
+	reply.Cost = cost
+	reply.ChunkCount = json.Uint64(chunks)
+	reply.ReferenceCost = s.vm.storageFee(baseFee, referenceLen)
+	reply.BaseFee = json.Uint64(baseFee)
+	reply.FeePerByte = json.Uint64(s.vm.params.FeePerByte)
+	return nil
+}
 
-	block, _ := blockInterface.(*Block)
-	reply.Cost = block.getCostPerUploadBlock()
-	return err
+// EstimateFeeArgs are the arguments to EstimateFee
+type EstimateFeeArgs struct {
+	// Number of bytes of data to estimate the upload fee of. Optional.
+	Size json.Uint64 `json:"size"`
+
+	// Number of days to keep the data for, which uploads with retention
+	// pay rent for. Optional.
+	Days json.Uint64 `json:"days"`
+}
+
+// EstimateFeeReply is the reply from EstimateFee
+type EstimateFeeReply struct {
+	// Base fee of the block being built on, and of the next block
+	ParentBaseFee json.Uint64 `json:"parentBaseFee"`
+	BaseFee       json.Uint64 `json:"baseFee"`
+
+	// The chain's pricing parameters. See TOKENOMICS.md.
+	MinBaseFee               json.Uint64 `json:"minBaseFee"`
+	FeePerByte               json.Uint64 `json:"feePerByte"`
+	TargetBlockSize          json.Uint64 `json:"targetBlockSize"`
+	BaseFeeChangeDenominator json.Uint64 `json:"baseFeeChangeDenominator"`
+
+	// Fee for uploading [Size] bytes in as few data chunks as possible,
+	// the number of chunks, and the base fee after them, assuming nothing
+	// else gets in between. Only set if [Size] is.
+	UploadFee          int64       `json:"uploadFee"`
+	ChunkCount         json.Uint64 `json:"chunkCount"`
+	BaseFeeAfterUpload json.Uint64 `json:"baseFeeAfterUpload"`
+
+	// Rent the uploads with retention pay, on top of the fee, for keeping
+	// [Size] bytes for [Days] days. Only set if both are.
+	Rent json.Uint64 `json:"rent"`
+}
+
+// EstimateFee returns the base fee of the next block, which the preferred
+// block sets, and estimates what uploading [args.Size] bytes would cost.
+// With [args.Days], the estimate is for uploads with retention.
+func (s *Service) EstimateFee(_ *http.Request, args *EstimateFeeArgs, reply *EstimateFeeReply) error {
+	preferred, err := s.vm.getBlock(s.vm.Preferred())
+	if err != nil {
+		return errNoSuchBlock
+	}
+	reply.ParentBaseFee = json.Uint64(preferred.getBaseFee())
+	reply.BaseFee = json.Uint64(preferred.getNextBaseFee())
+	reply.MinBaseFee = json.Uint64(s.vm.params.BaseFee)
+	reply.FeePerByte = json.Uint64(s.vm.params.FeePerByte)
+	reply.TargetBlockSize = json.Uint64(s.vm.params.TargetBlockSize)
+	reply.BaseFeeChangeDenominator = json.Uint64(s.vm.params.BaseFeeChangeDenominator)
+	if args.Size == 0 {
+		return nil
+	}
+	headerLen := int64(uploadHeaderLen)
+	if args.Days != 0 {
+		if !s.vm.rentEnabled() {
+			return errRentDisabled
+		}
+		headerLen = retentionUploadHeaderLen
+		reply.Rent = json.Uint64(s.vm.computeRent(uint64(args.Size), int64(args.Days)))
+	}
+	fee, chunks, baseFee := s.vm.estimateUploadFee(preferred, int64(args.Size), headerLen)
+	reply.UploadFee = fee
+	reply.ChunkCount = json.Uint64(chunks)
+	reply.BaseFeeAfterUpload = json.Uint64(baseFee)
+	return nil
+}
+
+// GetRentArgs are the arguments to GetRent
+type GetRentArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// Number of days to renew the file for
+	Days json.Uint64 `json:"days"`
+}
+
+// GetRentReply is the reply from GetRent
+type GetRentReply struct {
+	// Rent a renewal for [Days] days has to pay
+	Rent json.Uint64 `json:"rent"`
+
+	// Fee the renewal pays on top of the rent
+	Fee int64 `json:"fee"`
+
+	// The file's size, which the rent is for, and when it expires now
+	Size   json.Uint64 `json:"size"`
+	Expiry json.Uint64 `json:"expiry"`
+
+	RentPerKBDay json.Uint64 `json:"rentPerKBDay"`
+}
+
+// GetRent returns the rent for renewing [args.Owner]'s file [args.FileID]
+// for [args.Days] days in the next block
+func (s *Service) GetRent(_ *http.Request, args *GetRentArgs, reply *GetRentReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	if !s.vm.rentEnabled() {
+		return errRentDisabled
+	}
+	preferred, err := s.vm.getBlock(s.vm.Preferred())
+	if err != nil {
+		return errNoSuchBlock
+	}
+	creator, err := preferred.getFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return err
+	}
+	expiry, hasExpiry, err := preferred.getFileExpiry(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+	if !hasExpiry {
+		return errNoSuchFile
+	}
+	if deleted, err := preferred.isFileDeleted(creator, args.FileID); err != nil {
+		return err
+	} else if deleted {
+		return errFileDeleted
+	}
+	size, err := preferred.getFileSize(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+	reply.Rent = json.Uint64(s.vm.computeRent(size, int64(args.Days)))
+	reply.Fee = s.vm.storageFee(preferred.getNextBaseFee(), renewLen)
+	reply.Size = json.Uint64(size)
+	reply.Expiry = json.Uint64(expiry)
+	reply.RentPerKBDay = json.Uint64(s.vm.params.RentPerKBDay)
+	return nil
+}
+
+// GetUploadRentArgs are the arguments to GetUploadRent
+type GetUploadRentArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// Number of bytes of data the upload adds to the file
+	ChunkSize json.Uint64 `json:"chunkSize"`
+
+	// Number of days to keep the file for, if the upload is its first one
+	Days json.Uint64 `json:"days"`
+}
+
+// GetUploadRentReply is the reply from GetUploadRent
+type GetUploadRentReply struct {
+	// Rent the upload with retention has to pay, and the days it declares
+	Rent json.Uint64 `json:"rent"`
+	Days json.Uint64 `json:"days"`
+
+	// Whether the upload is the file's first one
+	First bool `json:"first"`
+}
+
+// GetUploadRent returns the rent an upload with retention of
+// [args.ChunkSize] bytes of data to [args.Owner]'s file [args.FileID] has
+// to pay in the next block. The file's first upload pays for [args.Days]
+// days, and the later ones for the file's days left.
+func (s *Service) GetUploadRent(_ *http.Request, args *GetUploadRentArgs, reply *GetUploadRentReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	if !s.vm.rentEnabled() {
+		return errRentDisabled
+	}
+	preferred, err := s.vm.getBlock(s.vm.Preferred())
+	if err != nil {
+		return errNoSuchBlock
+	}
+	creator, err := preferred.getFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return err
+	}
+	expiry, hasExpiry, err := preferred.getFileExpiry(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+	if !hasExpiry {
+		if args.Days == 0 {
+			return errMalformedUpload
+		}
+		reply.Rent = json.Uint64(s.vm.computeRent(uint64(args.ChunkSize), int64(args.Days)))
+		reply.Days = args.Days
+		reply.First = true
+		return nil
+	}
+	size, err := preferred.getFileSize(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+	// As of the preferred block's time, like the file's expiry
+	daysLeft := computeDaysLeft(expiry, preferred.Timestamp().Unix())
+	reply.Rent = json.Uint64(s.vm.computeAddedRent(size, uint64(args.ChunkSize), daysLeft))
+	return nil
+}
+
+// GetRefundArgs are the arguments to GetRefund
+type GetRefundArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// Block to answer as of, by ID or by height. If neither is given, it's
+	// the preferred block.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
+}
+
+// GetRefundReply is the reply from GetRefund
+type GetRefundReply struct {
+	// Most rent a deletion of the file in the next block can claim back
+	Refund json.Uint64 `json:"refund"`
+
+	// Fee the deletion pays
+	Fee int64 `json:"fee"`
+}
+
+// GetRefund returns what deleting [args.Owner]'s file [args.FileID] in the
+// block after a block would refund and cost. The refund is for the file's
+// whole days left at the block's timestamp, so it's the same unless the
+// next block is a day boundary later.
+func (s *Service) GetRefund(_ *http.Request, args *GetRefundArgs, reply *GetRefundReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(s.vm.Preferred())
+	if err != nil {
+		return errNoSuchBlock
+	}
+	if args.BlockID != "" || args.Height != nil {
+		if block, err = s.getBlockAt(args.BlockID, args.Height); err != nil {
+			return err
+		}
+	}
+	creator, err := block.getFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return err
+	}
+	if hasFile, err := block.hasFile(creator, args.FileID); err != nil {
+		return err
+	} else if !hasFile {
+		return errNoSuchFile
+	}
+	if deleted, err := block.isFileDeleted(creator, args.FileID); err != nil {
+		return err
+	} else if deleted {
+		return errFileDeleted
+	}
+	refund, err := block.getRefund(creator, args.FileID, block.Timestamp().Unix())
+	if err != nil {
+		return err
+	}
+	reply.Refund = json.Uint64(refund)
+	reply.Fee = s.vm.storageFee(block.getNextBaseFee(), deleteLen)
+	return nil
 }
 
+// GetUnallocatedFundsArgs are the arguments to GetUnallocatedFunds
 type GetUnallocatedFundsArgs struct {
+	// Block to answer as of, by ID or by height. If neither is given, it's
+	// the last accepted block.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
 }
 
+// GetUnallocatedFundsReply is the reply from GetUnallocatedFunds
 type GetUnallocatedFundsReply struct {
 	UnallocatedFunds int64 `json:"unallocatedFunds"`
+
+	// The block the funds are as of
+	BlockID string      `json:"blockID"`
+	Height  json.Uint64 `json:"height"`
 }
 
+// GetUnallocatedFunds returns the funds the faucet and staking rewards
+// haven't given out yet, as of a block
 func (s *Service) GetUnallocatedFunds(_ *http.Request, args *GetUnallocatedFundsArgs, reply *GetUnallocatedFundsReply) error {
-	var err error
-	id, _ := s.vm.State.GetLastAccepted(s.vm.DB)
-	coreBlock, _ := s.vm.GetBlock(id)
-	block, _ := coreBlock.(*Block)
+	block, err := s.getBlockAt(args.BlockID, args.Height)
+	if err != nil {
+		return err
+	}
 	reply.UnallocatedFunds = block.getUnallocatedBalance()
-	return err
+	reply.BlockID = block.ID().String()
+	reply.Height = json.Uint64(block.Height())
+	return nil
 }
 
+// GetBalanceArgs are the arguments to GetBalance
 type GetBalanceArgs struct {
 	Account string
+
+	// Block to answer as of, by ID or by height. If neither is given, it's
+	// the last accepted block. Stakes are counted as they stand at the
+	// block's timestamp, since their locks and rewards depend on the time.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
 }
 
+// GetBalanceReply is the reply from GetBalance
 type GetBalanceReply struct {
 	Balance int64 `json:"balance"`
+
+	// The block the balance is as of
+	BlockID string      `json:"blockID"`
+	Height  json.Uint64 `json:"height"`
 }
 
+// GetBalance returns [args.Account]'s balance as of a block
 func (s *Service) GetBalance(_ *http.Request, args *GetBalanceArgs, reply *GetBalanceReply) error {
-	var err error
-	id, _ := s.vm.State.GetLastAccepted(s.vm.DB)
-	coreBlock, _ := s.vm.GetBlock(id)
-	block, _ := coreBlock.(*Block)
-	reply.Balance = block.getBalance(args.Account)
-	return err
+	block, err := s.getBlockAt(args.BlockID, args.Height)
+	if err != nil {
+		return err
+	}
+	if reply.Balance, err = block.getBalance(args.Account); err != nil {
+		return err
+	}
+	reply.BlockID = block.ID().String()
+	reply.Height = json.Uint64(block.Height())
+	return nil
+}
+
+// GetStakesArgs are the arguments to GetStakes
+type GetStakesArgs struct {
+	Account string `json:"account"`
+
+	// Block to answer as of, by ID or by height. If neither is given, it's
+	// the last accepted block.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
+}
+
+// APIStake is the API representation of a stake
+type APIStake struct {
+	ID         string      `json:"id"`
+	NodeID     string      `json:"nodeID"`
+	Amount     json.Uint64 `json:"amount"`
+	StakeStart json.Uint64 `json:"stakeStart"`
+	StakeEnd   json.Uint64 `json:"stakeEnd"`
+
+	// How much of the stake is locked and the reward it's paid out. Like
+	// balances, they're as they stand at the block's timestamp.
+	Locked json.Uint64 `json:"locked"`
+	Reward json.Uint64 `json:"reward"`
+}
+
+// newAPIStake returns the API representation of the stake in [stake] at
+// unix time [at]
+func newAPIStake(stake *Block, at int64) APIStake {
+	return APIStake{
+		ID:         stake.ID().String(),
+		NodeID:     stake.getStakeNode(),
+		Amount:     json.Uint64(stake.getStakeAmount()),
+		StakeStart: json.Uint64(stake.getStakeStart()),
+		StakeEnd:   json.Uint64(stake.getStakeEnd()),
+		Locked:     json.Uint64(stake.getLockedStake(at)),
+		Reward:     json.Uint64(stake.getStakeReward(at)),
+	}
+}
+
+// GetStakesReply is the reply from GetStakes
+type GetStakesReply struct {
+	Stakes []APIStake `json:"stakes"`
+
+	// The block the stakes are as of
+	BlockID string      `json:"blockID"`
+	Height  json.Uint64 `json:"height"`
+}
+
+// GetStakes returns the stakes whose rewards go to [args.Account] as of a
+// block, oldest first
+func (s *Service) GetStakes(_ *http.Request, args *GetStakesArgs, reply *GetStakesReply) error {
+	if len(args.Account) != addressLen {
+		return errBadAddress
+	}
+	block, err := s.getBlockAt(args.BlockID, args.Height)
+	if err != nil {
+		return err
+	}
+	stakes, err := s.vm.getStakesAt(args.Account, block)
+	if err != nil {
+		return err
+	}
+	reply.Stakes = make([]APIStake, len(stakes))
+	for i, stake := range stakes {
+		reply.Stakes[i] = newAPIStake(stake, block.Timestamp().Unix())
+	}
+	reply.BlockID = block.ID().String()
+	reply.Height = json.Uint64(block.Height())
+	return nil
+}
+
+// GetAccountHistoryArgs are the arguments to GetAccountHistory
+type GetAccountHistoryArgs struct {
+	Address string `json:"address"`
+
+	// Cursor of the entry to start after. If empty, starts from the first one.
+	Cursor string `json:"cursor"`
+
+	// Max number of entries to return. Defaults to, and is capped at, [maxPageSize].
+	Limit json.Uint32 `json:"limit"`
+
+	// Entry types to return: upload, transferIn, transferOut, faucet, stake
+	// and fee. If empty, returns every type.
+	Types []string `json:"types"`
+}
+
+// APIAccountHistoryEntry is the API representation of an entry in an
+// account's history
+type APIAccountHistoryEntry struct {
+	Type      string      `json:"type"`
+	BlockID   string      `json:"blockID"`
+	Height    json.Uint64 `json:"height"`
+	Timestamp json.Uint64 `json:"timestamp"`
+
+	// How much the transaction changed the account's balance, and whether
+	// that was a credit rather than a debit. For stakes, the lock and
+	// reward are in [Stake] instead, as they stand at the last accepted
+	// block's timestamp.
+	Amount json.Uint64 `json:"amount"`
+	Credit bool        `json:"credit"`
+
+	Tx    *APITx    `json:"tx"`
+	Stake *APIStake `json:"stake,omitempty"`
+
+	// Pass as [Cursor] to get the entries after this one
+	Cursor string `json:"cursor"`
+}
+
+// GetAccountHistoryReply is the reply from GetAccountHistory
+type GetAccountHistoryReply struct {
+	Entries []APIAccountHistoryEntry `json:"entries"`
+
+	// Pass as [Cursor] to get the next page. Empty if there are no more entries.
+	NextCursor string `json:"nextCursor"`
+}
+
+// GetAccountHistory returns the accepted transactions involving
+// [args.Address], oldest first: its uploads, transfers in and out, faucet
+// drips, stakes whose rewards go to it, and the other transactions it paid
+// fees for
+func (s *Service) GetAccountHistory(_ *http.Request, args *GetAccountHistoryArgs, reply *GetAccountHistoryReply) error {
+	if len(args.Address) != addressLen {
+		return errBadAddress
+	}
+	historyTypes := map[accountHistoryType]bool{}
+	for _, name := range args.Types {
+		historyType, ok := accountHistoryTypes[name]
+		if !ok {
+			return errBadHistoryType
+		}
+		historyTypes[historyType] = true
+	}
+	limit := int(args.Limit)
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	entries, err := s.vm.listAccountHistory(args.Address, args.Cursor, limit, historyTypes)
+	if err != nil {
+		return err
+	}
+	lastAccepted, err := s.vm.getLastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+
+	reply.Entries = make([]APIAccountHistoryEntry, len(entries))
+	for i, entry := range entries {
+		apiEntry := APIAccountHistoryEntry{
+			Type:      entry.historyType.String(),
+			BlockID:   entry.block.ID().String(),
+			Height:    json.Uint64(entry.height),
+			Timestamp: json.Uint64(entry.block.Timestamp().Unix()),
+			Tx:        newAPITx(entry.block),
+			Cursor:    entry.cursor(),
+		}
+		switch entry.historyType {
+		case historyTransferOut:
+			apiEntry.Amount = json.Uint64(entry.block.getTransferAmount())
+		case historyTransferIn:
+			apiEntry.Amount, apiEntry.Credit = json.Uint64(entry.block.getTransferAmount()), true
+		case historyStake:
+			stake := newAPIStake(entry.block, lastAccepted.Timestamp().Unix())
+			apiEntry.Stake = &stake
+		default:
+			change := entry.block.getLedgerChange(args.Address)
+			if change < 0 {
+				apiEntry.Amount = json.Uint64(-change)
+			} else {
+				apiEntry.Amount, apiEntry.Credit = json.Uint64(change), true
+			}
+		}
+		reply.Entries[i] = apiEntry
+	}
+	if len(entries) == limit {
+		reply.NextCursor = entries[len(entries)-1].cursor()
+	}
+	return nil
 }
 
 type GetValidatorsAtArgs struct {
@@ -310,4 +1132,887 @@ func (s *Service) DebugPayload(_ *http.Request, args *DebugPayloadArgs, reply *D
 	return err
 }
 
+// APIFile is the API representation of a file in the file index
+type APIFile struct {
+	Owner      string      `json:"owner"`
+	FileID     string      `json:"fileID"`
+	ChunkCount json.Uint64 `json:"chunkCount"`
+
+	// Account that uploaded the file's first chunk. It's the owner unless
+	// the file was transferred.
+	Creator string `json:"creator"`
+
+	// What [Owner] can do to the file: "owner", or the role it was given
+	// if it was given access to someone else's file
+	Role string `json:"role"`
+
+	// Path of the file in its owner's directory tree, if it has one
+	Path string `json:"path,omitempty"`
+
+	Size       json.Uint64 `json:"size"`
+
+	// These are only set once a manifest for the file is accepted. They're
+	// from the manifest of the latest version, or the one asked for.
+	Version     json.Uint64 `json:"version,omitempty"`
+	ManifestID  string      `json:"manifestID,omitempty"`
+	FileName    string      `json:"fileName,omitempty"`
+	MimeType    string      `json:"mimeType,omitempty"`
+	ContentHash string      `json:"contentHash,omitempty"`
 
+	// Unix time the file expires at, if files on this chain expire, and
+	// whether it has expired
+	Expiry  json.Uint64 `json:"expiry,omitempty"`
+	Expired bool        `json:"expired"`
+
+	// True if the owner deleted the file
+	Deleted bool `json:"deleted"`
+}
+
+// APIChunk is the API representation of one of a file's chunks
+type APIChunk struct {
+	ChunkNumber json.Uint64 `json:"chunkNumber"`
+	BlockID     string      `json:"blockID"`
+	Size        json.Uint64 `json:"size"`
+
+	// Hex encoded SHA-256 of the chunk's data, which GetChunk takes
+	Hash string `json:"hash"`
+}
+
+// newAPIFile returns the API representation of [owner]'s file [fileID],
+// which [creator] created, as of the last accepted block
+func (s *Service) newAPIFile(owner string, creator string, fileID string, record *fileRecord) (APIFile, error) {
+	file := APIFile{
+		Owner:      owner,
+		FileID:     fileID,
+		ChunkCount: json.Uint64(record.ChunkCount),
+		Size:       json.Uint64(record.Size),
+		Creator:    creator,
+	}
+	role, err := s.vm.getAcceptedFileRole(owner, creator, fileID)
+	if err != nil {
+		return file, err
+	}
+	if role == roleNone {
+		role = roleOwner
+	}
+	file.Role = role.String()
+	if file.Path, err = s.vm.getAcceptedFilePath(creator, fileID); err != nil {
+		return file, err
+	}
+	expiry, hasExpiry, err := s.vm.getAcceptedFileExpiry(creator, fileID)
+	if err != nil {
+		return file, err
+	}
+	if hasExpiry {
+		lastAccepted, err := s.vm.getLastAcceptedBlock()
+		if err != nil {
+			return file, err
+		}
+		file.Expiry = json.Uint64(expiry)
+		file.Expired = lastAccepted.Timestamp().Unix() > expiry
+	}
+	if file.Deleted, err = s.vm.isAcceptedFileDeleted(creator, fileID); err != nil {
+		return file, err
+	}
+	return file, s.addManifest(&file, record.ManifestID)
+}
+
+// newAPIFileAt returns the API representation of [owner]'s file [fileID],
+// which [creator] created, as of [block]. [snapshot] is the file as of
+// [block], and [record] is its record for the version asked for.
+func (s *Service) newAPIFileAt(owner string, creator string, fileID string, snapshot *fileSnapshot, record *fileRecord, block *Block) (APIFile, error) {
+	file := APIFile{
+		Owner:      owner,
+		FileID:     fileID,
+		Role:       roleOwner.String(),
+		Path:       snapshot.path,
+		ChunkCount: json.Uint64(record.ChunkCount),
+		Size:       json.Uint64(record.Size),
+		Creator:    creator,
+		Deleted:    snapshot.deleted,
+	}
+	if role, ok := snapshot.roles[owner]; ok {
+		file.Role = role.String()
+	}
+	if snapshot.hasExpiry {
+		file.Expiry = json.Uint64(snapshot.expiry)
+		file.Expired = block.Timestamp().Unix() > snapshot.expiry
+	}
+	return file, s.addManifest(&file, record.ManifestID)
+}
+
+// addManifest fills out [file]'s fields from the manifest with ID
+// [manifestID], if there is one
+func (s *Service) addManifest(file *APIFile, manifestID ids.ID) error {
+	if manifestID == ids.Empty {
+		return nil
+	}
+	manifest, err := s.vm.getBlock(manifestID)
+	if err != nil {
+		return err
+	}
+	version, err := manifest.getManifestVersion()
+	if err != nil {
+		return err
+	}
+	file.Version = json.Uint64(version)
+	file.ChunkCount = json.Uint64(manifest.getManifestChunkCount())
+	file.Size = json.Uint64(manifest.getManifestSize())
+	file.ManifestID = manifest.ID().String()
+	file.FileName = manifest.getManifestName()
+	file.MimeType = manifest.getManifestMimeType()
+	file.ContentHash = manifest.getManifestHash()
+	return nil
+}
+
+// ListFilesArgs are the arguments to ListFiles
+type ListFilesArgs struct {
+	Owner string `json:"owner"`
+
+	// File ID to start after. If empty, starts from the first file.
+	Cursor string `json:"cursor"`
+
+	// Max number of files to return. Defaults to, and is capped at, [maxPageSize].
+	Limit json.Uint32 `json:"limit"`
+}
+
+// ListFilesReply is the reply from ListFiles
+type ListFilesReply struct {
+	Files []APIFile `json:"files"`
+
+	// Pass as [Cursor] to get the next page. Empty if there are no more files.
+	NextCursor string `json:"nextCursor"`
+}
+
+// ListFiles returns the files owned by [args.Owner], ordered by file ID
+func (s *Service) ListFiles(_ *http.Request, args *ListFilesArgs, reply *ListFilesReply) error {
+	if len(args.Owner) != addressLen {
+		return errBadAddress
+	}
+	limit := int(args.Limit)
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	fileIDs, err := s.vm.listFiles(args.Owner, args.Cursor, limit)
+	if err != nil {
+		return err
+	}
+
+	reply.Files = []APIFile{}
+	for _, fileID := range fileIDs {
+		creator, err := s.vm.getAcceptedFileCreatorOf(args.Owner, fileID)
+		if err != nil {
+			return err
+		}
+		record, err := s.vm.getFileRecord(creator, fileID)
+		if err != nil {
+			return err
+		}
+		file, err := s.newAPIFile(args.Owner, creator, fileID, record)
+		if err != nil {
+			return err
+		}
+		reply.Files = append(reply.Files, file)
+	}
+	if len(fileIDs) == limit {
+		reply.NextCursor = fileIDs[len(fileIDs)-1]
+	}
+	return nil
+}
+
+// ListDirectoryArgs are the arguments to ListDirectory
+type ListDirectoryArgs struct {
+	Owner string `json:"owner"`
+
+	// Path of the directory, like /projects/2026. Defaults to /.
+	Path string `json:"path"`
+
+	// Name of the entry to start after. If empty, starts from the first one.
+	Cursor string `json:"cursor"`
+
+	// Max number of entries to return. Defaults to, and is capped at, [maxPageSize].
+	Limit json.Uint32 `json:"limit"`
+}
+
+// APIDirectoryEntry is the API representation of a file or directory in a
+// directory
+type APIDirectoryEntry struct {
+	// Name in the directory. A directory's name ends with /.
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// True if this is a directory, which has no file ID
+	Directory bool   `json:"directory"`
+	FileID    string `json:"fileID,omitempty"`
+}
+
+// ListDirectoryReply is the reply from ListDirectory
+type ListDirectoryReply struct {
+	Entries []APIDirectoryEntry `json:"entries"`
+
+	// Pass as [Cursor] to get the next page. Empty if there are no more entries.
+	NextCursor string `json:"nextCursor"`
+}
+
+// ListDirectory returns the files and directories in the directory
+// [args.Path] of [args.Owner]'s directory tree, ordered by name. Files are
+// in the tree once a manifest or rename gives them a path.
+func (s *Service) ListDirectory(_ *http.Request, args *ListDirectoryArgs, reply *ListDirectoryReply) error {
+	if len(args.Owner) != addressLen {
+		return errBadAddress
+	}
+	dir := strings.TrimSuffix(args.Path, "/")
+	if dir != "" {
+		if err := verifyPath(dir); err != nil {
+			return err
+		}
+	}
+	limit := int(args.Limit)
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	entries, err := s.vm.listDirectory(args.Owner, dir, args.Cursor, limit)
+	if err != nil {
+		return err
+	}
+
+	reply.Entries = make([]APIDirectoryEntry, len(entries))
+	for i, entry := range entries {
+		reply.Entries[i] = APIDirectoryEntry{
+			Name:      entry.name,
+			Path:      dir + "/" + strings.TrimSuffix(entry.name, "/"),
+			Directory: entry.fileID == "",
+			FileID:    entry.fileID,
+		}
+	}
+	if len(entries) == limit {
+		reply.NextCursor = entries[len(entries)-1].name
+	}
+	return nil
+}
+
+// GetFileArgs are the arguments to GetFile
+type GetFileArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// If true, the reply includes the file's content, reassembled from its
+	// chunks and encoded with [Encoding]
+	IncludeContent bool                `json:"includeContent"`
+	Encoding       formatting.Encoding `json:"encoding"`
+
+	// Version of the file to return. If 0, returns the latest.
+	Version json.Uint64 `json:"version"`
+
+	// Accepted block to answer as of, by ID or by height. If neither is
+	// given, it's the last accepted block.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
+}
+
+// GetFileReply is the reply from GetFile
+type GetFileReply struct {
+	File     APIFile             `json:"file"`
+	Chunks   []APIChunk          `json:"chunks"`
+	Content  string              `json:"content,omitempty"`
+	Encoding formatting.Encoding `json:"encoding"`
+}
+
+// GetFile returns [args.Owner]'s file [args.FileID] as of a block, and
+// where its chunks are. If the file has a manifest, the chunks are the ones the manifest of the
+// version asked for lists. Otherwise they're all the uploaded chunks, in
+// order.
+func (s *Service) GetFile(_ *http.Request, args *GetFileArgs, reply *GetFileReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	var record *fileRecord
+	var chunks []*Block
+	var err error
+	if args.BlockID == "" && args.Height == nil {
+		record, chunks, err = s.getFile(args, reply)
+	} else {
+		record, chunks, err = s.getFileAt(args, reply)
+	}
+	if err != nil {
+		return err
+	}
+
+	reply.Chunks = make([]APIChunk, len(chunks))
+	for i, chunk := range chunks {
+		data := chunk.getUploadChunk()
+		reply.Chunks[i] = APIChunk{
+			ChunkNumber: json.Uint64(chunk.getUploadChunkNumber()),
+			BlockID:     chunk.ID().String(),
+			Size:        json.Uint64(len(data)),
+			Hash:        hashChunk(data),
+		}
+	}
+
+	reply.Encoding = args.Encoding
+	if !args.IncludeContent {
+		return nil
+	}
+	content, err := getFileContent(chunks, record.ManifestID != ids.Empty)
+	if err != nil {
+		return err
+	}
+	reply.Content, err = formatting.EncodeWithChecksum(args.Encoding, content)
+	return err
+}
+
+// getFile sets [reply.File] to the file GetFile asks for, as of the last
+// accepted block, and returns its record and chunks
+func (s *Service) getFile(args *GetFileArgs, reply *GetFileReply) (*fileRecord, []*Block, error) {
+	creator, err := s.vm.getAcceptedFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err := s.vm.getFileRecord(creator, args.FileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if args.Version != 0 {
+		if record, err = s.vm.getVersionRecord(creator, args.FileID, record, uint64(args.Version)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if reply.File, err = s.newAPIFile(args.Owner, creator, args.FileID, record); err != nil {
+		return nil, nil, err
+	}
+	if reply.File.Deleted {
+		return nil, nil, errFileDeleted
+	}
+	if reply.File.Expired {
+		return nil, nil, errFileExpired
+	}
+	chunks, err := s.vm.getFileChunks(creator, args.FileID, record)
+	return record, chunks, err
+}
+
+// getFileAt is getFile as of the accepted block GetFile asks for
+func (s *Service) getFileAt(args *GetFileArgs, reply *GetFileReply) (*fileRecord, []*Block, error) {
+	block, err := s.getAcceptedBlockAt(args.BlockID, args.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+	creator, err := s.vm.getFileCreatorAt(args.Owner, args.FileID, block.Height())
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := s.vm.getFileAt(creator, args.FileID, block.Height())
+	if err != nil {
+		return nil, nil, err
+	}
+	record := snapshot.record
+	if args.Version != 0 {
+		if uint64(args.Version) > uint64(len(snapshot.manifestIDs)) {
+			return nil, nil, errNoSuchVersion
+		}
+		record.ManifestID = snapshot.manifestIDs[args.Version-1]
+	}
+	if reply.File, err = s.newAPIFileAt(args.Owner, creator, args.FileID, snapshot, &record, block); err != nil {
+		return nil, nil, err
+	}
+	if reply.File.Deleted {
+		return nil, nil, errFileDeleted
+	}
+	if reply.File.Expired {
+		return nil, nil, errFileExpired
+	}
+	var chunks []*Block
+	if record.ManifestID != ids.Empty {
+		chunks, err = s.vm.getFileChunks(creator, args.FileID, &record)
+	} else {
+		chunks, err = s.vm.getBlocks(snapshot.getChunkIDs())
+	}
+	return &record, chunks, err
+}
+
+// ListVersionsArgs are the arguments to ListVersions
+type ListVersionsArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// Accepted block to answer as of, by ID or by height. If neither is
+	// given, it's the last accepted block.
+	BlockID string       `json:"blockID"`
+	Height  *json.Uint64 `json:"height"`
+}
+
+// APIVersion is the API representation of a version of a file
+type APIVersion struct {
+	Version    json.Uint64 `json:"version"`
+	ManifestID string      `json:"manifestID"`
+
+	// Manifest ID of the version this one replaced. Empty for version 1.
+	ParentID string `json:"parentID,omitempty"`
+
+	FileName    string      `json:"fileName"`
+	MimeType    string      `json:"mimeType"`
+	Size        json.Uint64 `json:"size"`
+	ChunkCount  json.Uint64 `json:"chunkCount"`
+	ContentHash string      `json:"contentHash"`
+
+	// Unix time of the block the version was made in
+	Timestamp json.Uint64 `json:"timestamp"`
+}
+
+// ListVersionsReply is the reply from ListVersions
+type ListVersionsReply struct {
+	Versions []APIVersion `json:"versions"`
+}
+
+// ListVersions returns every version of [args.Owner]'s file [args.FileID]
+// as of a block, oldest first. GetFile returns any of them.
+func (s *Service) ListVersions(_ *http.Request, args *ListVersionsArgs, reply *ListVersionsReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	var manifestIDs []ids.ID
+	if args.BlockID == "" && args.Height == nil {
+		creator, err := s.vm.getAcceptedFileCreatorOf(args.Owner, args.FileID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.vm.getFileRecord(creator, args.FileID); err != nil {
+			return err
+		}
+		if manifestIDs, err = s.vm.getVersionManifestIDs(creator, args.FileID); err != nil {
+			return err
+		}
+	} else {
+		block, err := s.getAcceptedBlockAt(args.BlockID, args.Height)
+		if err != nil {
+			return err
+		}
+		creator, err := s.vm.getFileCreatorAt(args.Owner, args.FileID, block.Height())
+		if err != nil {
+			return err
+		}
+		snapshot, err := s.vm.getFileAt(creator, args.FileID, block.Height())
+		if err != nil {
+			return err
+		}
+		manifestIDs = snapshot.manifestIDs
+	}
+	reply.Versions = make([]APIVersion, len(manifestIDs))
+	for i, manifestID := range manifestIDs {
+		manifest, err := s.vm.getBlock(manifestID)
+		if err != nil {
+			return err
+		}
+		reply.Versions[i] = APIVersion{
+			Version:     json.Uint64(i + 1),
+			ManifestID:  manifestID.String(),
+			FileName:    manifest.getManifestName(),
+			MimeType:    manifest.getManifestMimeType(),
+			Size:        json.Uint64(manifest.getManifestSize()),
+			ChunkCount:  json.Uint64(manifest.getManifestChunkCount()),
+			ContentHash: manifest.getManifestHash(),
+			Timestamp:   json.Uint64(manifest.Timestamp().Unix()),
+		}
+		if i > 0 {
+			reply.Versions[i].ParentID = manifestIDs[i-1].String()
+		}
+	}
+	return nil
+}
+
+// GetFileAccessArgs are the arguments to GetFileAccess
+type GetFileAccessArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+}
+
+// APIFileAccess is the API representation of an entry in a file's access
+// list
+type APIFileAccess struct {
+	Account string `json:"account"`
+
+	// "write" or "admin"
+	Role string `json:"role"`
+}
+
+// GetFileAccessReply is the reply from GetFileAccess
+type GetFileAccessReply struct {
+	Creator string          `json:"creator"`
+	Access  []APIFileAccess `json:"access"`
+}
+
+// GetFileAccess returns the accounts that were given access to
+// [args.Owner]'s file [args.FileID], ordered by account. [args.Owner] can
+// also be one of those accounts.
+func (s *Service) GetFileAccess(_ *http.Request, args *GetFileAccessArgs, reply *GetFileAccessReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	creator, err := s.vm.getAcceptedFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.vm.getFileRecord(creator, args.FileID); err != nil {
+		return err
+	}
+	list, err := s.vm.getFileAccessList(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+	reply.Creator = creator
+	reply.Access = make([]APIFileAccess, len(list))
+	for i, access := range list {
+		reply.Access[i] = APIFileAccess{
+			Account: access.account,
+			Role:    access.role.String(),
+		}
+	}
+	return nil
+}
+
+// GetInclusionProofArgs are the arguments to GetInclusionProof
+type GetInclusionProofArgs struct {
+	BlockID string `json:"blockID"`
+
+	// If true, proves the block's chunk rather than its transaction
+	Chunk bool `json:"chunk"`
+}
+
+// GetInclusionProofReply is the reply from GetInclusionProof
+type GetInclusionProofReply struct {
+	// Hex encoded bytes of the block's header. The block ID is their
+	// SHA-256, and they end with the block's tx root.
+	Header string `json:"header"`
+	TxRoot string `json:"txRoot"`
+
+	// Hex encoded leaf being proven: the transaction's ID, or the SHA-256
+	// of its chunk
+	Leaf string `json:"leaf"`
+
+	// Index of the leaf, and its hex encoded Merkle proof, bottom first.
+	// Every tx root has 2 leaves.
+	Index json.Uint32 `json:"index"`
+	Proof []string    `json:"proof"`
+}
+
+// GetInclusionProof returns a proof that the transaction in block
+// [args.BlockID], or its chunk, is in the block. A client that knows the
+// block ID can check it without trusting this node.
+func (s *Service) GetInclusionProof(_ *http.Request, args *GetInclusionProofArgs, reply *GetInclusionProofReply) error {
+	blockID, err := ids.FromString(args.BlockID)
+	if err != nil {
+		return errors.New("problem parsing ID")
+	}
+	block, err := s.vm.getBlock(blockID)
+	if err != nil {
+		return errNoSuchBlock
+	}
+	if block.rootless {
+		return errRootlessProof
+	}
+	leaf, index, proof, err := block.getInclusionProof(args.Chunk)
+	if err != nil {
+		return err
+	}
+	reply.Header = hex.EncodeToString(block.getHeaderBytes())
+	reply.TxRoot = hex.EncodeToString(block.TxRoot[:])
+	reply.Leaf = hex.EncodeToString(leaf[:])
+	reply.Index = json.Uint32(index)
+	reply.Proof = make([]string, len(proof))
+	for i, hash := range proof {
+		reply.Proof[i] = hex.EncodeToString(hash[:])
+	}
+	return nil
+}
+
+// GetAccountProofArgs are the arguments to GetAccountProof
+type GetAccountProofArgs struct {
+	Account string `json:"account"`
+
+	// ID of the block to prove the balance as of. If left blank, it's the
+	// last accepted block. Otherwise it has to be that or a block that's
+	// still being processed.
+	BlockID string `json:"blockID"`
+}
+
+// GetAccountProofReply is the reply from GetAccountProof
+type GetAccountProofReply struct {
+	// Hex encoded bytes of the block's header. The block ID is their
+	// SHA-256, and they end with the block's state root, then its tx root.
+	BlockID   string `json:"blockID"`
+	Header    string `json:"header"`
+	StateRoot string `json:"stateRoot"`
+
+	// The account's balance as of the block, not counting stakes, and the
+	// hex encoded key and value of its leaf
+	Balance json.Uint64 `json:"balance"`
+	Key     string      `json:"key"`
+	Value   string      `json:"value"`
+
+	// Index of the leaf in its bucket, the number of leaves in the bucket
+	// and the leaf's hex encoded Merkle proof to the bucket's root, bottom
+	// first
+	Index json.Uint32 `json:"index"`
+	Count json.Uint32 `json:"count"`
+	Proof []string    `json:"proof"`
+
+	// Index of the bucket, and the hex encoded Merkle proof from its root
+	// to the state root, bottom first
+	Bucket      json.Uint32 `json:"bucket"`
+	BucketProof []string    `json:"bucketProof"`
+}
+
+// GetAccountProof returns a proof of [args.Account]'s balance as of a
+// block. A client that knows the block ID can check it without trusting
+// this node.
+func (s *Service) GetAccountProof(_ *http.Request, args *GetAccountProofArgs, reply *GetAccountProofReply) error {
+	block, err := s.getBlockAt(args.BlockID, nil)
+	if err != nil {
+		return err
+	}
+	proof, err := block.getStateProof(accountLeafKey(args.Account))
+	if err != nil {
+		return err
+	}
+	balance, err := database.ParseUInt64(proof.value)
+	if err != nil {
+		return err
+	}
+	reply.BlockID = block.ID().String()
+	reply.Header = hex.EncodeToString(block.getHeaderBytes())
+	reply.StateRoot = hex.EncodeToString(block.StateRoot[:])
+	reply.Balance = json.Uint64(balance)
+	reply.Key = hex.EncodeToString([]byte(proof.key))
+	reply.Value = hex.EncodeToString(proof.value)
+	reply.Index = json.Uint32(proof.index)
+	reply.Count = json.Uint32(proof.count)
+	reply.Proof = make([]string, len(proof.proof))
+	for i, hash := range proof.proof {
+		reply.Proof[i] = hex.EncodeToString(hash[:])
+	}
+	reply.Bucket = json.Uint32(proof.bucket)
+	reply.BucketProof = make([]string, len(proof.bucketProof))
+	for i, hash := range proof.bucketProof {
+		reply.BucketProof[i] = hex.EncodeToString(hash[:])
+	}
+	return nil
+}
+
+// VerifyAnchorArgs are the arguments to VerifyAnchor
+type VerifyAnchorArgs struct {
+	// Hex encoded SHA-256 that was anchored, or that's in an anchored batch
+	Hash string `json:"hash"`
+
+	// For a hash in a batch: the number of hashes in the batch, the hash's
+	// index in it and the hex encoded Merkle proof from the hash to the
+	// batch's root. [Count] is 0 for a hash that was anchored on its own.
+	Count json.Uint32 `json:"count"`
+	Index json.Uint32 `json:"index"`
+	Proof []string    `json:"proof"`
+}
+
+// VerifyAnchorReply is the reply from VerifyAnchor
+type VerifyAnchorReply struct {
+	// Hex encoded hash that was anchored. For a hash in a batch, it's the
+	// batch's root.
+	AnchoredHash string `json:"anchoredHash"`
+
+	BlockID   string      `json:"blockID"`
+	Height    json.Uint64 `json:"height"`
+	Timestamp json.Uint64 `json:"timestamp"`
+	Signer    string      `json:"signer"`
+}
+
+// VerifyAnchor returns the first accepted anchor of [args.Hash], or of the
+// root of a batch it's in, which proves it existed at the anchor's time
+func (s *Service) VerifyAnchor(_ *http.Request, args *VerifyAnchorArgs, reply *VerifyAnchorReply) error {
+	hash, err := parseChunkHash(args.Hash)
+	if err != nil {
+		return err
+	}
+	var anchor *Block
+	root := hash
+	if args.Count == 0 {
+		anchor, err = s.vm.getAnchor(hash)
+	} else {
+		proof := make([][32]byte, len(args.Proof))
+		for i, proofHash := range args.Proof {
+			if proof[i], err = parseChunkHash(proofHash); err != nil {
+				return err
+			}
+		}
+		anchor, root, err = s.vm.getBatchAnchor(hash, int(args.Index), int(args.Count), proof)
+	}
+	if err != nil {
+		return err
+	}
+	reply.AnchoredHash = hex.EncodeToString(root[:])
+	reply.BlockID = anchor.ID().String()
+	reply.Height = json.Uint64(anchor.Height())
+	reply.Timestamp = json.Uint64(anchor.Timestamp().Unix())
+	reply.Signer = anchor.getSigner()
+	return nil
+}
+
+// GetUploadProgressArgs are the arguments to GetUploadProgress
+type GetUploadProgressArgs struct {
+	Owner  string `json:"owner"`
+	FileID string `json:"fileID"`
+
+	// Missing chunk number to start after. If empty, starts from chunk 0.
+	Cursor string `json:"cursor"`
+
+	// Max number of missing chunks to return. Defaults to, and is capped at,
+	// [maxPageSize].
+	Limit json.Uint32 `json:"limit"`
+}
+
+// GetUploadProgressReply is the reply from GetUploadProgress
+type GetUploadProgressReply struct {
+	// Numbers of the accepted chunks, in order
+	Uploaded []json.Uint64 `json:"uploaded"`
+
+	// Numbers of the chunks that still need uploading, in order. This
+	// includes every gap below the highest uploaded chunk, and the chunks
+	// after it if the file's chunk count is known. Chunk numbers and counts
+	// are chosen by the uploader, so the gaps are paged.
+	Missing []json.Uint64 `json:"missing"`
+
+	// Pass as [Cursor] to get the next page of missing chunks. Empty if
+	// there are no more.
+	NextCursor string `json:"nextCursor"`
+
+	// Number of chunks in the file, if it's known from a manifest or a
+	// session upload. Otherwise 0.
+	ChunkCount json.Uint64 `json:"chunkCount"`
+}
+
+// GetUploadProgress returns which chunks of [args.Owner]'s file
+// [args.FileID] have been accepted, so an interrupted upload can resume
+// from the gaps. Uploading a chunk that's already there is rejected, so
+// it's never paid for twice.
+func (s *Service) GetUploadProgress(_ *http.Request, args *GetUploadProgressArgs, reply *GetUploadProgressReply) error {
+	if err := verifyFileArgs(args.Owner, args.FileID); err != nil {
+		return err
+	}
+	start := uint64(0)
+	if args.Cursor != "" {
+		cursor, err := strconv.ParseUint(args.Cursor, 10, 64)
+		if err != nil {
+			return errBadChunkCursor
+		}
+		start = cursor + 1
+	}
+	limit := int(args.Limit)
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	reply.Uploaded = []json.Uint64{}
+	reply.Missing = []json.Uint64{}
+	creator, err := s.vm.getAcceptedFileCreatorOf(args.Owner, args.FileID)
+	if err != nil {
+		return err
+	}
+	record, err := s.vm.getFileRecord(creator, args.FileID)
+	if err == errNoSuchFile {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	chunkNumbers, err := s.vm.getChunkNumbers(creator, args.FileID)
+	if err != nil {
+		return err
+	}
+
+	// Chunk count from the manifest, or else from a session upload
+	if record.ManifestID != ids.Empty {
+		manifest, err := s.vm.getBlock(record.ManifestID)
+		if err != nil {
+			return err
+		}
+		reply.ChunkCount = json.Uint64(manifest.getManifestChunkCount())
+	} else if len(chunkNumbers) > 0 {
+		chunkID, err := database.GetID(s.vm.chunkDB, chunkKey(creator, args.FileID, chunkNumbers[0]))
+		if err != nil {
+			return err
+		}
+		chunk, err := s.vm.getBlock(chunkID)
+		if err != nil {
+			return err
+		}
+		if chunk.isSessionUploadBlock() {
+			reply.ChunkCount = json.Uint64(chunk.getSessionChunkCount())
+		}
+	}
+
+	end := uint64(reply.ChunkCount)
+	for _, chunkNumber := range chunkNumbers {
+		reply.Uploaded = append(reply.Uploaded, json.Uint64(chunkNumber))
+		if chunkNumber >= end {
+			end = chunkNumber + 1
+		}
+	}
+
+	// The gaps are found between the uploaded chunks, rather than by
+	// counting up to [end], which can be huge
+	chunkNumber := start
+	for _, uploaded := range append(chunkNumbers, end) {
+		for ; chunkNumber < uploaded && chunkNumber < end; chunkNumber++ {
+			if len(reply.Missing) == limit {
+				reply.NextCursor = strconv.FormatUint(uint64(reply.Missing[limit-1]), 10)
+				return nil
+			}
+			reply.Missing = append(reply.Missing, json.Uint64(chunkNumber))
+		}
+		if uploaded >= chunkNumber {
+			chunkNumber = uploaded + 1
+		}
+	}
+	return nil
+}
+
+// GetChunkArgs are the arguments to GetChunk
+type GetChunkArgs struct {
+	// Hex encoded SHA-256 of the chunk's data
+	Hash     string              `json:"hash"`
+	Encoding formatting.Encoding `json:"encoding"`
+}
+
+// GetChunkReply is the reply from GetChunk
+type GetChunkReply struct {
+	// ID of the first accepted upload that stored the chunk
+	BlockID  string              `json:"blockID"`
+	Size     json.Uint64         `json:"size"`
+	Content  string              `json:"content"`
+	Encoding formatting.Encoding `json:"encoding"`
+}
+
+// GetChunk returns the chunk whose data hashes to [args.Hash], no matter
+// which account or file uploaded it. To store data that's already on the
+// chain, upload a chunk reference to it instead of the data.
+func (s *Service) GetChunk(_ *http.Request, args *GetChunkArgs, reply *GetChunkReply) error {
+	hash, err := parseChunkHash(args.Hash)
+	if err != nil {
+		return err
+	}
+	chunk, err := s.vm.getContentBlock(hash)
+	if err != nil {
+		return err
+	}
+	lastAccepted, err := s.vm.getLastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+	live, err := lastAccepted.isContentLive(hash, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !live {
+		return errChunkExpired
+	}
+	data := chunk.getUploadChunk()
+	reply.BlockID = chunk.ID().String()
+	reply.Size = json.Uint64(len(data))
+	reply.Encoding = args.Encoding
+	reply.Content, err = formatting.EncodeWithChecksum(args.Encoding, data)
+	return err
+}
@@ -0,0 +1,72 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetBlockDecoded(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	recipient := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	block := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 25, recipient.address))
+
+	service := Service{vm}
+	reply := GetBlockReply{}
+	if err := service.GetBlock(nil, &GetBlockArgs{ID: block.ID().String()}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Tx != nil {
+		t.Fatal("expected no decoded transaction unless asked for")
+	}
+
+	if err := service.GetBlock(nil, &GetBlockArgs{ID: block.ID().String(), Decode: true}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	tx := reply.Tx
+	switch {
+	case tx == nil:
+		t.Fatal("expected a decoded transaction")
+	case tx.Type != "faucet":
+		t.Fatalf("expected a faucet transaction but got %q", tx.Type)
+	case tx.Signer != key.address:
+		t.Fatalf("expected signer %s but got %s", key.address, tx.Signer)
+	case !tx.SignatureValid:
+		t.Fatal("expected a valid signature")
+	case tx.Amount != 25:
+		t.Fatalf("expected amount 25 but got %d", tx.Amount)
+	case tx.Recipient != recipient.address:
+		t.Fatalf("expected recipient %s but got %s", recipient.address, tx.Recipient)
+	case tx.ContentLength != 66:
+		t.Fatalf("expected content length 66 but got %d", tx.ContentLength)
+	}
+}
+
+func TestGetBlockDecodedTampered(t *testing.T) {
+	vm, _, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	// Change the amount after signing
+	data := newTestFaucetPayload(t, key, 25, key.address)
+	data[158+15] = '9'
+	block, err := vm.NewBlock(vm.LastAcceptedID, 1, data, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := newAPITx(block)
+	if tx.SignatureValid {
+		t.Fatal("expected the signature to be invalid")
+	}
+	if tx.Amount != 29 {
+		t.Fatalf("expected amount 29 but got %d", tx.Amount)
+	}
+	if err := block.Verify(); err != errInvalidSignature {
+		t.Fatalf("expected %s but got %v", errInvalidSignature, err)
+	}
+}
@@ -0,0 +1,154 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// Size of the chunks a file is split into for a session upload.
+	// It's fixed so clients can compute the Merkle root before uploading.
+	sessionChunkSize = 2048
+
+	// Length of the session part of a session upload, which is what the
+	// owner signs (after the message type): file ID, chunk count, expiry
+	// and Merkle root
+	sessionLen = 16 + 8 + 10 + 64
+
+	// Length of a session upload before the proof: the session, the chunk
+	// number and the number of hashes in the proof
+	sessionUploadHeaderLen = sessionLen + 8 + 3
+
+	// Length of a hex encoded hash in a proof
+	proofHashLen = 64
+)
+
+var (
+	errMalformedSession = errors.New("session upload is malformed")
+	errSessionExpired   = errors.New("session upload is past its session's expiry")
+	errSessionProof     = errors.New("session upload's chunk isn't part of its session")
+)
+
+// A session upload is an upload whose signature covers an upload session
+// rather than the chunk itself. The session commits to the Merkle root of
+// the file's chunks, so each chunk proves it's part of the file the owner
+// signed for. This lets a node chunk a file and build the uploads for a
+// client that only signs once.
+func (b *Block) isSessionUploadBlock() bool {
+	return b.getBlockType() == "4"
+}
+
+func (b *Block) getSessionChunkCount() int64 {
+	countBytes := b.Data[contentOffset+16 : contentOffset+16+8]
+	return b.convertBytesToInt(countBytes)
+}
+
+// returns the unix time after which the session's chunks are rejected
+func (b *Block) getSessionExpiry() int64 {
+	expiryBytes := b.Data[contentOffset+16+8 : contentOffset+16+8+10]
+	return b.convertBytesToInt(expiryBytes)
+}
+
+// returns the hex encoded Merkle root of the session's chunk hashes
+func (b *Block) getSessionRoot() string {
+	return string(b.Data[contentOffset+16+8+10 : contentOffset+sessionLen])
+}
+
+// returns the message the owner signed to authorize the session
+func (b *Block) getSessionMessage() []byte {
+	message := []byte{b.Data[153]}
+	return append(message, b.Data[contentOffset:contentOffset+sessionLen]...)
+}
+
+// getSessionProof returns the Merkle proof of this upload's chunk, and
+// the offset in the content where the chunk's data starts
+func (b *Block) getSessionProof() ([][32]byte, int, error) {
+	content := b.getContent()
+	if len(content) < sessionUploadHeaderLen {
+		return nil, 0, errMalformedSession
+	}
+	proofLen, err := strconv.ParseUint(string(content[sessionLen+8:sessionUploadHeaderLen]), 10, 16)
+	if err != nil {
+		return nil, 0, errMalformedSession
+	}
+	end := sessionUploadHeaderLen + int(proofLen)*proofHashLen
+	if len(content) < end {
+		return nil, 0, errMalformedSession
+	}
+	proof := make([][32]byte, proofLen)
+	for i := range proof {
+		start := sessionUploadHeaderLen + i*proofHashLen
+		if _, err := hex.Decode(proof[i][:], content[start:start+proofHashLen]); err != nil {
+			return nil, 0, errMalformedSession
+		}
+	}
+	return proof, end, nil
+}
+
+// verifySessionUpload returns nil iff this upload's chunk is part of its
+// session and the session hasn't expired
+func (b *Block) verifySessionUpload() error {
+	if b.Timestamp().Unix() > b.getSessionExpiry() {
+		return errSessionExpired
+	}
+	proof, _, err := b.getSessionProof()
+	if err != nil {
+		return err
+	}
+	var root [32]byte
+	if _, err := hex.Decode(root[:], []byte(b.getSessionRoot())); err != nil {
+		return errMalformedSession
+	}
+	leaf := sha256.Sum256(b.getUploadChunk())
+	if !verifyMerkleProof(leaf, int(b.getUploadChunkNumber()), int(b.getSessionChunkCount()), proof, root) {
+		return errSessionProof
+	}
+	return nil
+}
+
+// splitSessionChunks splits [content] into the chunks of a session upload
+func splitSessionChunks(content []byte) [][]byte {
+	chunks := [][]byte{}
+	for start := 0; start < len(content); start += sessionChunkSize {
+		end := start + sessionChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		chunks = append(chunks, content[start:end])
+	}
+	return chunks
+}
+
+// newSessionMessage returns the message an owner signs to authorize
+// uploading [fileID], whose chunks' hashes are [leaves], until [expiry]
+func newSessionMessage(fileID string, leaves [][32]byte, expiry int64) []byte {
+	root := merkleRoot(leaves)
+	return []byte(fmt.Sprintf("4%s%08d%010d%x", fileID, len(leaves), expiry, root))
+}
+
+// newSessionUpload returns the upload of chunk [index] of [chunks], signed
+// by [owner]'s session signature [sig]. [message] is the session message.
+func newSessionUpload(owner string, sig string, message []byte, chunks [][]byte, leaves [][32]byte, index int) [dataLen]byte {
+	proof := merkleProof(leaves, index)
+	content := append([]byte{}, message[1:]...)
+	content = append(content, fmt.Sprintf("%08d%03d", index, len(proof))...)
+	for _, hash := range proof {
+		content = append(content, hex.EncodeToString(hash[:])...)
+	}
+	content = append(content, chunks[index]...)
+
+	var data [dataLen]byte
+	copy(data[0:50], owner)
+	copy(data[50:53], fmt.Sprintf("%03d", len(sig)))
+	copy(data[53:153], sig)
+	data[153] = message[0]
+	copy(data[154:158], fmt.Sprintf("%04d", len(content)))
+	copy(data[contentOffset:], content)
+	return data
+}
@@ -0,0 +1,194 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ava-labs/avalanchego/cache"
+	"github.com/ava-labs/avalanchego/cache/metercacher"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/crypto"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+)
+
+const (
+	// Default number of public keys recovered from signatures to keep,
+	// unless the config says otherwise
+	defaultSignatureCacheSize = 8192
+
+	// Number of signature checks that can wait for a worker. Blocks parsed
+	// while the queue is full have their signature checked when they're
+	// verified instead.
+	signatureQueueSize = 4096
+)
+
+// Recovering the public key from a transaction's signature is the slowest
+// part of verifying a block. While bootstrapping, the engine parses a batch
+// of blocks before verifying them one by one, so the signatures of the
+// blocks the engine parses are checked on a pool of workers in the
+// meantime, and Verify only waits for the result.
+//
+// The public keys recovered are cached by tx ID. A tx ID is the hash of the
+// block's data, signature included, so the key recovered for it can't
+// change, and a block that's parsed again doesn't have its signature
+// checked again.
+
+// signatureCheck is the check of the signature of a block's transaction
+type signatureCheck struct {
+	txID    ids.ID
+	signer  string
+	message []byte
+	sig     string
+
+	// Closed once [valid] is set
+	done  chan struct{}
+	valid bool
+}
+
+// newSignatureCheck returns the check of [b]'s signature. It only copies
+// [b]'s data, so the workers don't share anything with the caller.
+func newSignatureCheck(b *Block) *signatureCheck {
+	check := &signatureCheck{
+		txID:    b.txID(),
+		signer:  b.getSigner(),
+		message: b.getSignedMessage(),
+		done:    make(chan struct{}),
+	}
+	if sigLenNum, err := strconv.ParseUint(string(b.Data[50:53]), 10, 32); err == nil && sigLenNum <= 100 {
+		check.sig = string(b.Data[53 : 53+sigLenNum])
+	}
+	return check
+}
+
+// signatureVerifier checks signatures on a pool of workers, and caches the
+// public keys recovered from them
+type signatureVerifier struct {
+	// Public keys recovered from signatures, by tx ID. A signature no key
+	// can be recovered from is cached as nil.
+	cache cache.Cacher
+
+	// Checks waiting for a worker
+	checks chan *signatureCheck
+
+	// Held while queueing checks, so none are queued once the workers stop
+	lock    sync.Mutex
+	stopped bool
+
+	workers sync.WaitGroup
+}
+
+// Initialize creates the cache, sized [cacheSize] entries, registers its
+// metrics on [registerer] and starts [workers] workers
+func (v *signatureVerifier) Initialize(namespace string, registerer prometheus.Registerer, cacheSize int, workers int) error {
+	var err error
+	v.cache, err = metercacher.New(
+		fmt.Sprintf("%s_signature_cache", namespace),
+		registerer,
+		&cache.LRU{Size: cacheSize},
+	)
+	if err != nil {
+		return err
+	}
+	v.checks = make(chan *signatureCheck, signatureQueueSize)
+	v.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go v.work()
+	}
+	return nil
+}
+
+// work runs checks until the workers are stopped
+func (v *signatureVerifier) work() {
+	defer v.workers.Done()
+	for check := range v.checks {
+		check.valid = v.verify(check)
+		close(check.done)
+	}
+}
+
+// queue hands [b]'s signature check to the workers, unless its public key
+// has been recovered already or the queue is full
+func (v *signatureVerifier) queue(b *Block) {
+	if b.signature != nil {
+		return
+	}
+	if _, ok := v.cache.Get(b.txID()); ok {
+		return
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.stopped {
+		return
+	}
+	check := newSignatureCheck(b)
+	select {
+	case v.checks <- check:
+		b.signature = check
+	default:
+	}
+}
+
+// stop stops the workers once they've run the checks that are queued
+func (v *signatureVerifier) stop() {
+	v.lock.Lock()
+	if !v.stopped && v.checks != nil {
+		v.stopped = true
+		close(v.checks)
+	}
+	v.lock.Unlock()
+	v.workers.Wait()
+}
+
+// verify returns true iff [check]'s message was signed by its signer
+func (v *signatureVerifier) verify(check *signatureCheck) bool {
+	var recovered crypto.PublicKey
+	if cached, ok := v.cache.Get(check.txID); ok {
+		recovered, _ = cached.(crypto.PublicKey)
+	} else {
+		recovered = recoverSigner(check.message, check.sig)
+		v.cache.Put(check.txID, recovered)
+	}
+	return isSigner(recovered, check.signer)
+}
+
+// Blocks' signatures and the gateway's session signatures are checked by
+// recoverSigner and isSigner, so they follow the same rules.
+
+// recoverSigner returns the public key that signed [message] with [sig]
+// (CB58 encoded), or nil if none can be recovered from it
+func recoverSigner(message []byte, sig string) crypto.PublicKey {
+	sigDecoded, err := formatting.Decode(formatting.CB58, sig)
+	if err != nil {
+		return nil
+	}
+	factory := crypto.FactorySECP256K1R{}
+	recovered, err := factory.RecoverPublicKey(message, sigDecoded)
+	if err != nil {
+		return nil
+	}
+	return recovered
+}
+
+// isSigner returns true iff [recovered], a public key recovered from a
+// signature, is [address] (a CB58 encoded public key)
+func isSigner(recovered crypto.PublicKey, address string) bool {
+	if recovered == nil {
+		return false
+	}
+	pubkeyDecoded, err := formatting.Decode(formatting.CB58, address)
+	if err != nil {
+		return false
+	}
+	factory := crypto.FactorySECP256K1R{}
+	pubkey, err := factory.ToPublicKey(pubkeyDecoded)
+	if err != nil {
+		return false
+	}
+	return recovered.Address() == pubkey.Address()
+}
@@ -0,0 +1,177 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+)
+
+func TestSignatureWorkers(t *testing.T) {
+	vm, ctx, _ := newTestVMWithConfig(t, []byte{0, 0, 0, 0, 0}, []byte(`{"signatureWorkers": 2, "signatureCacheSize": 16}`))
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	if vm.config.getSignatureWorkers() != 2 {
+		t.Fatalf("expected 2 signature workers but got %d", vm.config.getSignatureWorkers())
+	}
+
+	// The signatures of blocks the engine parses are checked by the workers
+	block := newTestBlockAt(t, vm, newTestFaucetPayload(t, key, 1, key.address), time.Now())
+	parsedIntf, err := vm.ParseBlock(block.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := parsedIntf.(*Block)
+	if parsed.signature == nil {
+		t.Fatal("expected the parsed block's signature check to be queued")
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key recovered is cached, so parsing the block again doesn't
+	// queue another check
+	hits := getTestCacheCount(t, ctx, "_signature_cache_hit")
+	reparsedIntf, err := vm.ParseBlock(block.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed := reparsedIntf.(*Block); reparsed.signature != nil || !reparsed.hasValidSignature() {
+		t.Fatal("expected the reparsed block's signature to be checked against the cache")
+	}
+	if getTestCacheCount(t, ctx, "_signature_cache_hit") <= hits {
+		t.Fatal("expected the recovered public key to be cached")
+	}
+
+	// A transaction whose message doesn't match its signature is invalid
+	data := newTestFaucetPayload(t, key, 2, key.address)
+	data[170]++
+	forgedIntf, err := vm.ParseBlock(newTestBlockAt(t, vm, data, time.Now()).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := forgedIntf.Verify(); err != errInvalidSignature {
+		t.Fatalf("expected %s but got %v", errInvalidSignature, err)
+	}
+
+	// Once the workers stop, signatures are checked when blocks are verified
+	other := newTestBlockAt(t, vm, newTestFaucetPayload(t, key, 3, key.address), time.Now())
+	if err := vm.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	lateIntf, err := vm.ParseBlock(other.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if late := lateIntf.(*Block); late.signature != nil || !late.hasValidSignature() {
+		t.Fatal("expected the signature to be checked without the workers")
+	}
+}
+
+func TestSignatureRules(t *testing.T) {
+	vm, _, _ := newTestVM(t)
+	key, other := newTestKey(t), newTestKey(t)
+	message := []byte("hello")
+	sigBytes, err := key.sk.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := formatting.EncodeWithChecksum(formatting.CB58, sigBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks' signatures and the gateway's are checked the same way
+	for name, test := range map[string]struct {
+		signer   string
+		message  []byte
+		sig      string
+		expected bool
+	}{
+		"valid":         {key.address, message, sig, true},
+		"other signer":  {other.address, message, sig, false},
+		"other message": {key.address, []byte("howdy"), sig, false},
+		"bad signature": {key.address, message, "signature", false},
+		"bad signer":    {"signer", message, sig, false},
+	} {
+		check := &signatureCheck{txID: ids.GenerateTestID(), signer: test.signer, message: test.message, sig: test.sig}
+		if valid := vm.signatures.verify(check); valid != test.expected {
+			t.Fatalf("expected the block rule to return %v for %s but got %v", test.expected, name, valid)
+		}
+		if valid := verifySignature(test.signer, test.message, test.sig); valid != test.expected {
+			t.Fatalf("expected the gateway rule to return %v for %s but got %v", test.expected, name, valid)
+		}
+	}
+}
+
+// bootstrapTestChain parses the blocks [chain], like the engine does with a
+// batch of ancestors it fetched, then verifies and accepts them in order
+func bootstrapTestChain(b *testing.B, vm *VM, chain [][]byte) {
+	blocks := make([]*Block, len(chain))
+	for i, bytes := range chain {
+		block, err := vm.ParseBlock(bytes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		blocks[i] = block.(*Block)
+	}
+	for _, block := range blocks {
+		if err := block.Verify(); err != nil {
+			b.Fatal(err)
+		}
+		if err := block.Accept(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBootstrap measures how many blocks a node bootstraps a second,
+// with one signature worker and with one per CPU
+func BenchmarkBootstrap(b *testing.B) {
+	const numBlocks = 256
+	source, ctx, _ := newTestVM(b)
+	key := newTestKey(b)
+	chain := make([][]byte, numBlocks)
+	ctx.Lock.Lock()
+	for i := range chain {
+		chain[i] = acceptTestPayload(b, source, newTestFaucetPayload(b, key, int64(i+1), key.address)).Bytes()
+	}
+	ctx.Lock.Unlock()
+
+	counts := []int{1}
+	if runtime.NumCPU() > 1 {
+		counts = append(counts, runtime.NumCPU())
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			config := []byte(fmt.Sprintf(`{"signatureWorkers": %d}`, workers))
+			// Only bootstrapping counts towards the blocks per second
+			var elapsed time.Duration
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				vm, ctx, _ := newTestVMWithConfig(b, []byte{0, 0, 0, 0, 0}, config)
+				ctx.Lock.Lock()
+				b.StartTimer()
+				started := time.Now()
+				bootstrapTestChain(b, vm, chain)
+				elapsed += time.Since(started)
+				b.StopTimer()
+				if err := vm.Shutdown(); err != nil {
+					b.Fatal(err)
+				}
+				ctx.Lock.Unlock()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.N*numBlocks)/elapsed.Seconds(), "blocks/s")
+		})
+	}
+}
@@ -0,0 +1,487 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sort"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+const (
+	// Funds the unallocated account starts with in the genesis block
+	genesisFunds = 5000000000000000
+
+	// Number of buckets the state's leaves are split into
+	stateBucketCount = 256
+)
+
+var (
+	stateIndexPrefix       = []byte("state")
+	stateBucketIndexPrefix = []byte("stateBucket")
+
+	// Key in the state bucket index marking that the state index was
+	// backfilled. Its keys are otherwise one byte, so it can't be one.
+	stateIndexedKey = []byte("indexed")
+
+	// The first byte of a state leaf's key is its type
+	accountLeafPrefix     = byte('a')
+	unallocatedLeafPrefix = byte('u')
+	stakeLeafPrefix       = byte('s')
+	fileLeafPrefix        = byte('f')
+
+	errWrongStateRoot   = errors.New("block's state root doesn't match the state as of the block")
+	errStatelessBlock   = errors.New("block has no state root, but its parent does")
+	errStatelessProof   = errors.New("block is from before state roots, so its state can't be proven")
+	errNoAccountState   = errors.New("account has no balance in the state")
+	errStateUnavailable = errors.New("state is only kept as of the last accepted block and the blocks being processed")
+)
+
+// A block's header has the root of the state as of the block, so nodes
+// that disagree about it can't agree on the block. The state is a set of
+// leaves, each a key and a value:
+//   - each account's balance, not counting stakes, as 8 bytes
+//   - the unallocated balance, not counting staking rewards, as 8 bytes
+//   - each stake's terms, the stake's content
+//   - each file's history, the SHA-256 of its previous history and the ID
+//     of each transaction about the file, in order
+// A stake's lock and reward depend on when a balance is looked at, rather
+// than on the chain, so they're worked out from the stake's terms.
+//
+// Leaves are split into 256 buckets by the first byte of the SHA-256 of
+// their key. Each bucket's root is the SHA-256 of its number of leaves
+// followed by the Merkle root of their hashes, ordered by key, so a proof
+// can't claim the bucket has a different number of leaves. The state root
+// is the Merkle root of the buckets' roots. A block only changes a few
+// leaves, so only their buckets' roots are worked out again.
+
+// statelessBlock is how blocks were serialized after they had a tx root,
+// but before they had a state root
+type statelessBlock struct {
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
+	BaseFee     uint64        `serialize:"true"`
+	TxRoot      [32]byte      `serialize:"true"`
+}
+
+// statelessHeader is the header of a stateless block with a tx root
+type statelessHeader struct {
+	*core.Block `serialize:"true"`
+	BaseFee     uint64   `serialize:"true"`
+	TxRoot      [32]byte `serialize:"true"`
+}
+
+func accountLeafKey(account string) string {
+	return string(accountLeafPrefix) + account
+}
+
+func fileLeafKey(creator string, fileID string) string {
+	return string(fileLeafPrefix) + creator + fileID
+}
+
+// stateBucket returns the bucket of the leaf with key [key]
+func stateBucket(key string) byte {
+	return sha256.Sum256([]byte(key))[0]
+}
+
+// stateLeaf returns the hash of the leaf with key [key] and value [value]
+func stateLeaf(key string, value []byte) [32]byte {
+	return sha256.Sum256(append([]byte(key), value...))
+}
+
+// stateKey returns the state index key of the leaf with key [key]. Keys
+// start with the leaf's bucket, so a bucket's leaves can be iterated in
+// order.
+func stateKey(key string) []byte {
+	return append([]byte{stateBucket(key)}, key...)
+}
+
+// getStateChanges returns the leaves the transaction in this block
+// changes, and their values as of this block. It reads the state index,
+// so this block's parent has to be the last accepted block or one being
+// processed.
+func (b *Block) getStateChanges() (map[string][]byte, error) {
+	if changes := b.getCachedStateChanges(); changes != nil {
+		return changes, nil
+	}
+	before, err := b.getStateOverlay(false)
+	if err != nil {
+		return nil, err
+	}
+	return b.computeStateChanges(before)
+}
+
+// computeStateChanges works out the leaves the transaction in this block
+// changes, given the leaves [before] changed since the last accepted block
+// as of its parent
+func (b *Block) computeStateChanges(before map[string][]byte) (map[string][]byte, error) {
+	// The genesis block's data is the chain's parameters rather than a
+	// transaction, so all it does is fund the unallocated account
+	if b.Height() == 0 {
+		b.stateChanges = map[string][]byte{
+			string(unallocatedLeafPrefix): database.PackUInt64(genesisFunds),
+		}
+		return b.stateChanges, nil
+	}
+
+	changes := map[string][]byte{}
+	addToBalance := func(key string, change int64) error {
+		if change == 0 {
+			return nil
+		}
+		value, err := b.vm.getStateValue(before, key)
+		if err != nil {
+			return err
+		}
+		var balance uint64
+		if value != nil {
+			if balance, err = database.ParseUInt64(value); err != nil {
+				return err
+			}
+		}
+		changes[key] = database.PackUInt64(uint64(int64(balance) + change))
+		return nil
+	}
+	for _, account := range b.getLedgerAccounts() {
+		if err := addToBalance(accountLeafKey(account), b.getLedgerChange(account)); err != nil {
+			return nil, err
+		}
+	}
+	if err := addToBalance(string(unallocatedLeafPrefix), b.getUnallocatedChange()); err != nil {
+		return nil, err
+	}
+	if b.isStakeBlock() {
+		txID := b.txID()
+		changes[string(stakeLeafPrefix)+string(txID[:])] = b.getContent()
+	}
+	if fileID := b.getFileID(); fileID != "" {
+		creator, err := b.getFileCreator()
+		if err != nil {
+			return nil, err
+		}
+		key := fileLeafKey(creator, fileID)
+		history, err := b.vm.getStateValue(before, key)
+		if err != nil {
+			return nil, err
+		}
+		txID := b.txID()
+		next := sha256.Sum256(append(history, txID[:]...))
+		changes[key] = next[:]
+	}
+	b.stateChanges = changes
+	return changes, nil
+}
+
+// getStateValue returns the value of the leaf with key [key] as of the
+// last accepted block, with [overlay] applied, or nil if there's no such
+// leaf
+func (vm *VM) getStateValue(overlay map[string][]byte, key string) ([]byte, error) {
+	if value, ok := overlay[key]; ok {
+		return value, nil
+	}
+	value, err := vm.stateDB.Get(stateKey(key))
+	if err == database.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+// getStateOverlay returns the leaves changed since the last accepted
+// block, as of this block's parent, and this block's changes too if
+// [withSelf]. Each block's changes are worked out from the ones before
+// it, oldest first.
+func (b *Block) getStateOverlay(withSelf bool) (map[string][]byte, error) {
+	overlay := map[string][]byte{}
+	ancestry := []*Block{}
+	if b.Height() > 0 {
+		parent, err := b.getParent()
+		if err != nil {
+			return nil, err
+		}
+		if ancestry, err = parent.unacceptedAncestry(); err != nil {
+			return nil, err
+		}
+	}
+	if withSelf {
+		ancestry = append([]*Block{b}, ancestry...)
+	}
+	for i := len(ancestry) - 1; i >= 0; i-- {
+		changes := ancestry[i].getCachedStateChanges()
+		if changes == nil {
+			var err error
+			if changes, err = ancestry[i].computeStateChanges(overlay); err != nil {
+				return nil, err
+			}
+		}
+		for key, value := range changes {
+			overlay[key] = value
+		}
+	}
+	return overlay, nil
+}
+
+// getStateBucket returns the leaves in bucket [bucket] as of the last
+// accepted block, with [overlay] applied, ordered by key
+func (vm *VM) getStateBucket(bucket byte, overlay map[string][]byte) ([]string, map[string][]byte, error) {
+	values := map[string][]byte{}
+	iter := vm.stateDB.NewIteratorWithPrefix([]byte{bucket})
+	for iter.Next() {
+		values[string(iter.Key()[1:])] = append([]byte(nil), iter.Value()...)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, nil, err
+	}
+	for key, value := range overlay {
+		if stateBucket(key) == bucket {
+			values[key] = value
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, values, nil
+}
+
+// getBucketLeaves returns the hashes of the leaves [keys], whose values
+// are [values]
+func getBucketLeaves(keys []string, values map[string][]byte) [][32]byte {
+	leaves := make([][32]byte, len(keys))
+	for i, key := range keys {
+		leaves[i] = stateLeaf(key, values[key])
+	}
+	return leaves
+}
+
+// computeBucketRoot returns the root of the bucket whose leaves have keys
+// [keys], in order, and values [values]. An empty bucket's root is all
+// zeros.
+func computeBucketRoot(keys []string, values map[string][]byte) [32]byte {
+	if len(keys) == 0 {
+		return [32]byte{}
+	}
+	return bucketRoot(len(keys), merkleRoot(getBucketLeaves(keys, values)))
+}
+
+// bucketRoot returns the root of a bucket of [count] leaves whose Merkle
+// root is [leavesRoot]
+func bucketRoot(count int, leavesRoot [32]byte) [32]byte {
+	return sha256.Sum256(append(database.PackUInt64(uint64(count)), leavesRoot[:]...))
+}
+
+// getBucketRoots returns the root of each bucket as of the last accepted
+// block, with [overlay] applied
+func (vm *VM) getBucketRoots(overlay map[string][]byte) ([][32]byte, error) {
+	roots := make([][32]byte, stateBucketCount)
+	touched := map[byte]bool{}
+	for key := range overlay {
+		touched[stateBucket(key)] = true
+	}
+	for i := range roots {
+		bucket := byte(i)
+		if !touched[bucket] {
+			root, err := vm.stateBucketDB.Get([]byte{bucket})
+			if err == database.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			copy(roots[i][:], root)
+			continue
+		}
+		keys, values, err := vm.getStateBucket(bucket, overlay)
+		if err != nil {
+			return nil, err
+		}
+		roots[i] = computeBucketRoot(keys, values)
+	}
+	return roots, nil
+}
+
+// computeStateRoot returns the state root this block should have
+func (b *Block) computeStateRoot() ([32]byte, error) {
+	overlay, err := b.getStateOverlay(true)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	roots, err := b.vm.getBucketRoots(overlay)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return merkleRoot(roots), nil
+}
+
+// verifyStateRoot returns nil iff this block's state root matches the
+// state as of this block
+func (b *Block) verifyStateRoot(parent *Block) error {
+	if b.stateless {
+		// Only allowed until the chain's first block with a state root
+		if !parent.stateless {
+			return errStatelessBlock
+		}
+		return nil
+	}
+	root, err := b.computeStateRoot()
+	if err != nil {
+		return err
+	}
+	if b.StateRoot != root {
+		return errWrongStateRoot
+	}
+	b.vm.stateDiffCache.Put(b.ID(), b.stateChanges)
+	return nil
+}
+
+// indexState updates the state index with the accepted block [b]
+func (vm *VM) indexState(b *Block) error {
+	changes, err := b.getStateChanges()
+	if err != nil {
+		return err
+	}
+	touched := map[byte]bool{}
+	for key, value := range changes {
+		if err := vm.stateDB.Put(stateKey(key), value); err != nil {
+			return err
+		}
+		touched[stateBucket(key)] = true
+	}
+	for bucket := range touched {
+		keys, values, err := vm.getStateBucket(bucket, nil)
+		if err != nil {
+			return err
+		}
+		root := computeBucketRoot(keys, values)
+		if err := vm.stateBucketDB.Put([]byte{bucket}, root[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexState rebuilds the state index for chains accepted before it
+// existed. Blocks indexed since the node started, like the ones reindex
+// just caught up on, were applied to an empty state, so it starts over.
+func (vm *VM) reindexState() error {
+	indexed, err := vm.stateBucketDB.Has(stateIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	for _, db := range []database.Database{vm.stateDB, vm.stateBucketDB} {
+		iter := db.NewIterator()
+		for iter.Next() {
+			if err := db.Delete(iter.Key()); err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	genesis, err := vm.getBlockAtHeight(0)
+	if err != nil {
+		return err
+	}
+	blocks, err := vm.findAccepted(func(*Block) bool { return true })
+	if err != nil {
+		return err
+	}
+	for _, block := range append([]*Block{genesis}, blocks...) {
+		if err := vm.indexState(block); err != nil {
+			return err
+		}
+	}
+	if err := vm.stateBucketDB.Put(stateIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
+
+// stateProof proves the value of a leaf against a state root
+type stateProof struct {
+	key   string
+	value []byte
+
+	// The leaf's index in its bucket, the number of leaves in the bucket
+	// and the leaf's Merkle proof to the bucket's root
+	index  int
+	count  int
+	proof  [][32]byte
+	bucket byte
+
+	// The bucket root's Merkle proof to the state root
+	bucketProof [][32]byte
+}
+
+// getStateProof returns a proof of the leaf with key [key] as of this
+// block, which has to be the last accepted block or one being processed
+func (b *Block) getStateProof(key string) (*stateProof, error) {
+	if b.stateless {
+		return nil, errStatelessProof
+	}
+	if b.pruned {
+		return nil, errPrunedProof
+	}
+	if b.Status() == choices.Accepted && b.ID() != b.vm.LastAcceptedID {
+		return nil, errStateUnavailable
+	}
+	overlay, err := b.getStateOverlay(b.Status() != choices.Accepted)
+	if err != nil {
+		return nil, err
+	}
+	bucket := stateBucket(key)
+	keys, values, err := b.vm.getStateBucket(bucket, overlay)
+	if err != nil {
+		return nil, err
+	}
+	index := sort.SearchStrings(keys, key)
+	if index == len(keys) || keys[index] != key {
+		return nil, errNoAccountState
+	}
+	roots, err := b.vm.getBucketRoots(overlay)
+	if err != nil {
+		return nil, err
+	}
+	leaves := getBucketLeaves(keys, values)
+	return &stateProof{
+		key:         key,
+		value:       values[key],
+		index:       index,
+		count:       len(keys),
+		proof:       merkleProof(leaves, index),
+		bucket:      bucket,
+		bucketProof: merkleProof(roots, int(bucket)),
+	}, nil
+}
+
+// verifyStateProof returns true iff [header] is the header of the block
+// with ID [blockID], and [proof] holds against its state root. The state
+// root is the 32 bytes before the header's tx root. The number of leaves
+// in the proof's bucket is part of the bucket's root, so it doesn't have to
+// be trusted.
+func verifyStateProof(blockID ids.ID, header []byte, proof *stateProof) bool {
+	if len(header) < 64 || ids.ID(sha256.Sum256(header)) != blockID || stateBucket(proof.key) != proof.bucket {
+		return false
+	}
+	var stateRoot [32]byte
+	copy(stateRoot[:], header[len(header)-64:len(header)-32])
+	leavesRoot, ok := merkleProofRoot(stateLeaf(proof.key, proof.value), proof.index, proof.count, proof.proof)
+	return ok && verifyMerkleProof(bucketRoot(proof.count, leavesRoot), int(proof.bucket), stateBucketCount, proof.bucketProof, stateRoot)
+}
+
+// verifyAccountProof returns true iff [proof] is a proof of [account]'s
+// balance that holds against the state root of the block with ID
+// [blockID], whose header is [header]
+func verifyAccountProof(blockID ids.ID, header []byte, account string, proof *stateProof) bool {
+	return proof.key == accountLeafKey(account) && len(proof.value) == 8 && verifyStateProof(blockID, header, proof)
+}
@@ -0,0 +1,197 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/hashing"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+// getTestAccountProof returns the proof of [account]'s balance as of
+// [blockID], along with the header it's against
+func getTestAccountProof(t *testing.T, vm *VM, account string, blockID string) (*GetAccountProofReply, []byte, *stateProof) {
+	reply := &GetAccountProofReply{}
+	if err := (&Service{vm}).GetAccountProof(nil, &GetAccountProofArgs{Account: account, BlockID: blockID}, reply); err != nil {
+		t.Fatal(err)
+	}
+	header, err := hex.DecodeString(reply.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := hex.DecodeString(reply.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := hex.DecodeString(reply.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := &stateProof{
+		key:    string(key),
+		value:  value,
+		index:  int(reply.Index),
+		count:  int(reply.Count),
+		bucket: byte(reply.Bucket),
+	}
+	for _, hash := range reply.Proof {
+		parsed, err := parseChunkHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof.proof = append(proof.proof, parsed)
+	}
+	for _, hash := range reply.BucketProof {
+		parsed, err := parseChunkHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof.bucketProof = append(proof.bucketProof, parsed)
+	}
+	return reply, header, proof
+}
+
+func TestStateRoots(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, alice, 20, alice.address))
+	acceptTestPayload(t, vm, newTestPayload(t, alice, '1', fmt.Sprintf("%016d%s%s", 5, alice.address, bob.address)))
+	last := acceptTestPayload(t, vm, newTestUploadPayload(t, alice, "file000000000001", 0, "hello"))
+
+	// The proofs check out against the block ID alone
+	reply, header, proof := getTestAccountProof(t, vm, alice.address, "")
+	if reply.BlockID != last.ID().String() || int64(reply.Balance) != getTestBalance(t, last, alice.address) {
+		t.Fatalf("expected alice's balance as of %s to be %d but got %+v", last.ID(), getTestBalance(t, last, alice.address), reply)
+	}
+	if !verifyAccountProof(last.ID(), header, alice.address, proof) {
+		t.Fatal("proof of alice's balance should verify")
+	}
+	proof.value = database.PackUInt64(uint64(reply.Balance) + 1)
+	if verifyAccountProof(last.ID(), header, alice.address, proof) {
+		t.Fatal("proof of a balance alice doesn't have shouldn't verify")
+	}
+	reply, header, proof = getTestAccountProof(t, vm, alice.address, "")
+	if verifyAccountProof(last.ID(), header, bob.address, proof) {
+		t.Fatal("proof of alice's balance shouldn't verify as bob's")
+	}
+	proof.count++
+	if verifyAccountProof(last.ID(), header, alice.address, proof) {
+		t.Fatal("proof with the wrong number of leaves in the bucket shouldn't verify")
+	}
+	proof.count--
+	proof.value = append(proof.value, 0)
+	if verifyAccountProof(last.ID(), header, alice.address, proof) {
+		t.Fatal("proof of a value that isn't 8 bytes shouldn't verify")
+	}
+	if reply, _, _ := getTestAccountProof(t, vm, bob.address, ""); reply.Balance != 5 {
+		t.Fatalf("expected bob's balance to be 5 but got %d", reply.Balance)
+	}
+	if err := (&Service{vm}).GetAccountProof(nil, &GetAccountProofArgs{Account: newTestKey(t).address}, &GetAccountProofReply{}); err != errNoAccountState {
+		t.Fatalf("expected %s but got %v", errNoAccountState, err)
+	}
+
+	// Blocks that are being processed can be proven against too
+	processing := newTestBlockAt(t, vm, newTestPayload(t, bob, '1', fmt.Sprintf("%016d%s%s", 2, bob.address, alice.address)), time.Now())
+	if err := processing.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	reply, header, proof = getTestAccountProof(t, vm, bob.address, processing.ID().String())
+	if reply.Balance != 3 || !verifyAccountProof(processing.ID(), header, bob.address, proof) {
+		t.Fatalf("expected a proof of bob's balance of 3 but got %+v", reply)
+	}
+
+	// Blocks whose state root doesn't match the state are rejected
+	wrong := &Block{
+		Block:     core.NewBlock(last.ID(), last.Height()+1, time.Now().Unix()),
+		Data:      newTestFaucetPayload(t, bob, 10, bob.address),
+		BaseFee:   last.getNextBaseFee(),
+		StateRoot: last.StateRoot,
+	}
+	wrong.TxRoot = wrong.computeTxRoot()
+	bytes, err := vm.codec.Marshal(codecVersion, wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(); err != errWrongStateRoot {
+		t.Fatalf("expected %s but got %v", errWrongStateRoot, err)
+	}
+
+	// Nodes that accepted the blocks before the state index existed end up
+	// with the same state
+	for _, db := range []database.Database{vm.stateDB, vm.stateBucketDB} {
+		iter := db.NewIterator()
+		for iter.Next() {
+			if err := db.Delete(iter.Key()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		iter.Release()
+	}
+	if err := vm.reindexState(); err != nil {
+		t.Fatal(err)
+	}
+	reply, header, proof = getTestAccountProof(t, vm, alice.address, "")
+	if int64(reply.Balance) != getTestBalance(t, last, alice.address) || !verifyAccountProof(last.ID(), header, alice.address, proof) {
+		t.Fatalf("expected a proof of alice's balance after reindexing but got %+v", reply)
+	}
+}
+
+func TestStatelessBlock(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	genesis, err := vm.getBlockAtHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks serialized without a state root still parse, and keep the ID
+	// of their old header
+	stateless := &statelessBlock{
+		Block:   core.NewBlock(genesis.ID(), 1, time.Now().Unix()),
+		Data:    newTestFaucetPayload(t, key, 10, key.address),
+		BaseFee: genesis.getNextBaseFee(),
+	}
+	stateless.TxRoot = (&Block{Data: stateless.Data}).computeTxRoot()
+	bytes, err := vm.codec.Marshal(codecVersion, stateless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := vm.codec.Marshal(codecVersion, &statelessHeader{
+		Block:   stateless.Block,
+		BaseFee: stateless.BaseFee,
+		TxRoot:  stateless.TxRoot,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedIntf, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := parsedIntf.(*Block)
+	if !parsed.stateless || parsed.rootless || parsed.ID() != ids.ID(hashing.ComputeHash256Array(header)) || string(parsed.Bytes()) != string(bytes) {
+		t.Fatalf("expected a stateless block but got %+v", parsed)
+	}
+
+	// This chain's genesis block has a state root, so its blocks must too
+	if err := parsed.Verify(); err != errStatelessBlock {
+		t.Fatalf("expected %s but got %v", errStatelessBlock, err)
+	}
+}
@@ -0,0 +1,310 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/snow/choices"
+)
+
+// Length of a file transfer's content: the file ID and the recipient
+const fileTransferLen = fileIDLen + addressLen
+
+var (
+	ownerIndexPrefix       = []byte("owner")
+	transferredIndexPrefix = []byte("transferred")
+	creatorIndexPrefix     = []byte("creator")
+
+	// Key in the owner index marking that it was backfilled. Owner index
+	// keys are an address and a file ID, so it can't be one of them.
+	ownersIndexedKey = []byte("indexed")
+
+	errMalformedFileTransfer = errors.New("file transfer is malformed")
+	errFileTransferred       = errors.New("file was transferred to another account")
+	errFileExists            = errors.New("recipient already has a file with that ID")
+	errSelfTransfer          = errors.New("can't transfer a file to its owner")
+)
+
+// A file is created by the first upload to one of its creator's file IDs,
+// and the creator and file ID identify it for good: the indexes are keyed
+// by them. Its owner is the account that can add to, renew, delete or
+// transfer it, and names it by its file ID. That's the creator until a
+// file transfer gives it to another account, which then names it by the
+// same file ID.
+
+// A file transfer gives one of the signer's files to another account.
+func (b *Block) isFileTransferBlock() bool {
+	return b.getBlockType() == "8"
+}
+
+func (b *Block) getFileTransferFileID() string {
+	return string(b.Data[contentOffset : contentOffset+fileIDLen])
+}
+
+func (b *Block) getFileTransferRecipient() string {
+	return string(b.Data[contentOffset+fileIDLen : contentOffset+fileTransferLen])
+}
+
+// getFileID returns the file ID of the signer's file the transaction in
+// this block is about, or "" if it isn't about a file
+func (b *Block) getFileID() string {
+	switch {
+	case b.isUploadBlock():
+		return b.getUploadFileID()
+	case b.isManifestBlock():
+		return b.getManifestFileID()
+	case b.isRenewBlock():
+		return b.getRenewFileID()
+	case b.isDeleteBlock():
+		return b.getDeleteFileID()
+	case b.isFileTransferBlock():
+		return b.getFileTransferFileID()
+	case b.isFileAccessBlock():
+		return b.getFileAccessFileID()
+	case b.isRenameBlock():
+		return b.getRenameFileID()
+	}
+	return ""
+}
+
+// getFileCreator returns the creator of the file the transaction in this
+// block is about. It depends on the transfers before this block, so it's
+// worked out once, and recorded when the block is accepted.
+func (b *Block) getFileCreator() (string, error) {
+	if b.fileCreator != "" {
+		return b.fileCreator, nil
+	}
+	if b.Status() != choices.Accepted {
+		return b.resolveFileCreator()
+	}
+	id := b.ID()
+	creator, err := b.vm.creatorDB.Get(id[:])
+	if err == database.ErrNotFound {
+		return b.getSigner(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	b.fileCreator = string(creator)
+	return b.fileCreator, nil
+}
+
+// resolveFileCreator works out the creator of the file the transaction in
+// this block is about from the state as of its parent
+func (b *Block) resolveFileCreator() (string, error) {
+	if b.Height() == 0 {
+		return b.getSigner(), nil
+	}
+	parent, err := b.getParent()
+	if err != nil {
+		return "", err
+	}
+	creator, err := parent.getFileCreatorOf(b.getSigner(), b.getFileID())
+	if err != nil {
+		return "", err
+	}
+	b.fileCreator = creator
+	return creator, nil
+}
+
+// isOfFile returns true iff the transaction in this block is about the
+// file [fileID] of [creator]
+func (b *Block) isOfFile(creator string, fileID string) (bool, error) {
+	if b.getFileID() != fileID {
+		return false, nil
+	}
+	blockCreator, err := b.getFileCreator()
+	return blockCreator == creator, err
+}
+
+// getFileCreatorOf returns the creator of [owner]'s file [fileID] as of
+// this block. That's also the file [owner] was given access to under that
+// file ID. If [owner] doesn't have that file, it's [owner], since that's
+// who would create it. Returns errFileTransferred if [owner] gave it away.
+func (b *Block) getFileCreatorOf(owner string, fileID string) (string, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return "", err
+	}
+	// Newest first, so following a transfer back leads to the account that
+	// owned the file before it
+	for _, block := range ancestry {
+		if block.isFileAccessBlock() && block.getFileAccessFileID() == fileID && block.getFileAccessAccount() == owner {
+			// Access is only given to accounts that hadn't used the
+			// file ID, so once it's revoked they haven't again
+			if block.getFileAccessRole() == roleNone {
+				return owner, nil
+			}
+			return block.getFileCreator()
+		}
+		if !block.isFileTransferBlock() || block.getFileTransferFileID() != fileID {
+			continue
+		}
+		if block.getFileTransferRecipient() == owner {
+			owner = block.getSigner()
+		} else if block.getSigner() == owner {
+			return "", errFileTransferred
+		}
+	}
+	return b.vm.getAcceptedFileCreatorOf(owner, fileID)
+}
+
+// getAcceptedFileCreatorOf returns the creator of [owner]'s file [fileID]
+// as of the last accepted block, like getFileCreatorOf
+func (vm *VM) getAcceptedFileCreatorOf(owner string, fileID string) (string, error) {
+	creator, err := vm.ownerDB.Get(fileKey(owner, fileID))
+	if err == nil {
+		return string(creator), nil
+	}
+	if err != database.ErrNotFound {
+		return "", err
+	}
+	creator, err = vm.sharedDB.Get(fileKey(owner, fileID))
+	if err == nil {
+		return string(creator), nil
+	}
+	if err != database.ErrNotFound {
+		return "", err
+	}
+	transferred, err := vm.transferredDB.Has(fileKey(owner, fileID))
+	if err != nil {
+		return "", err
+	}
+	if transferred {
+		return "", errFileTransferred
+	}
+	return owner, nil
+}
+
+// verifyFileTransfer returns nil iff this transfer gives one of the
+// signer's files, which hasn't expired or been deleted, to an account that
+// doesn't have a file with the same ID or path
+func (b *Block) verifyFileTransfer(parent *Block) error {
+	if b.getContentLength() != fileTransferLen {
+		return errMalformedFileTransfer
+	}
+	fileID, recipient := b.getFileTransferFileID(), b.getFileTransferRecipient()
+	if recipient == b.getSigner() {
+		return errSelfTransfer
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	hasFile, err := parent.hasFile(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if !hasFile {
+		return errNoSuchFile
+	}
+	if err := b.verifyFileRole(parent, roleOwner); err != nil {
+		return err
+	}
+	if err := b.verifyNotExpired(parent, creator, fileID); err != nil {
+		return err
+	}
+	if err := b.verifyNotDeleted(parent, creator, fileID); err != nil {
+		return err
+	}
+
+	// The file keeps its path, so the recipient can't have a file there
+	path, err := parent.getFilePath(creator, fileID)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		if err := verifyPathFree(parent, recipient, path, creator, fileID); err != nil {
+			return err
+		}
+	}
+
+	// The recipient is free to take the file ID if it gave away the file it
+	// had, or if it never had one
+	recipientCreator, err := parent.getFileCreatorOf(recipient, fileID)
+	if err == errFileTransferred {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recipientCreator != recipient {
+		return errFileExists
+	}
+	recipientHasFile, err := parent.hasFile(recipient, fileID)
+	if err != nil {
+		return err
+	}
+	if recipientHasFile {
+		return errFileExists
+	}
+	return nil
+}
+
+// indexCreator records the creator of the file the accepted block [b] is
+// about, if it isn't [b]'s signer. The owner index only has the files'
+// current owners, so this has to come before the other indexes.
+func (vm *VM) indexCreator(b *Block) error {
+	if b.getFileID() == "" {
+		return nil
+	}
+	creator, err := b.resolveFileCreator()
+	if err != nil || creator == b.getSigner() {
+		return err
+	}
+	id := b.ID()
+	return vm.creatorDB.Put(id[:], []byte(creator))
+}
+
+// indexOwner updates the owner index with the accepted block [b]
+func (vm *VM) indexOwner(b *Block) error {
+	if !b.isFileTransferBlock() {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	sender := fileKey(b.getSigner(), b.getFileTransferFileID())
+	recipient := fileKey(b.getFileTransferRecipient(), b.getFileTransferFileID())
+	if err := vm.ownerDB.Delete(sender); err != nil {
+		return err
+	}
+	if err := vm.transferredDB.Put(sender, nil); err != nil {
+		return err
+	}
+	if err := vm.transferredDB.Delete(recipient); err != nil {
+		return err
+	}
+	return vm.ownerDB.Put(recipient, []byte(creator))
+}
+
+// reindexOwners adds the files created before the owner index existed to
+// it. Until there were transfers, every file was owned by its creator.
+func (vm *VM) reindexOwners() error {
+	indexed, err := vm.ownerDB.Has(ownersIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	iter := vm.fileDB.NewIterator()
+	keys := [][]byte{}
+	for iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := vm.ownerDB.Put(key, key[:addressLen]); err != nil {
+			return err
+		}
+	}
+	if err := vm.ownerDB.Put(ownersIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
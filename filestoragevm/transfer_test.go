@@ -0,0 +1,107 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+// newTestFileTransferPayload returns a signed transfer of [fileID] to
+// [recipient]
+func newTestFileTransferPayload(t *testing.T, key *testKey, fileID string, recipient string) [dataLen]byte {
+	return newTestPayload(t, key, '8', fileID+recipient)
+}
+
+func TestTransferFile(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	for _, key := range []*testKey{alice, bob, carol} {
+		acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	}
+	hello := acceptTestPayload(t, vm, newTestUploadPayload(t, alice, fileID, 0, "hello"))
+	acceptTestPayload(t, vm, newTestUploadPayload(t, carol, fileID, 0, "carol"))
+
+	for recipient, expected := range map[string]error{
+		alice.address: errSelfTransfer,
+		carol.address: errFileExists,
+	} {
+		if err := newTestBlockAt(t, vm, newTestFileTransferPayload(t, alice, fileID, recipient), time.Now()).Verify(); err != expected {
+			t.Fatalf("expected %s but got %v", expected, err)
+		}
+	}
+	if err := newTestBlockAt(t, vm, newTestFileTransferPayload(t, bob, fileID, carol.address), time.Now()).Verify(); err != errNoSuchFile {
+		t.Fatalf("expected %s but got %v", errNoSuchFile, err)
+	}
+
+	transfer := acceptTestPayload(t, vm, newTestFileTransferPayload(t, alice, fileID, bob.address))
+	if balance := getTestBalance(t, transfer, alice.address); balance != 10-2 {
+		t.Fatalf("expected a balance of %d but got %d", 10-2, balance)
+	}
+
+	// Only the new owner can add to the file. The block's state can't be
+	// worked out, so it can't even be built.
+	if _, err := vm.NewBlock(transfer.ID(), transfer.Height()+1, newTestUploadPayload(t, alice, fileID, 1, "world"), time.Now()); err != errFileTransferred {
+		t.Fatalf("expected %s but got %v", errFileTransferred, err)
+	}
+	if err := newTestBlockAt(t, vm, newTestUploadPayload(t, bob, fileID, 0, "hello"), time.Now()).Verify(); err != errDuplicateChunk {
+		t.Fatalf("expected %s but got %v", errDuplicateChunk, err)
+	}
+	world := acceptTestPayload(t, vm, newTestUploadPayload(t, bob, fileID, 1, "world"))
+	acceptTestPayload(t, vm, newTestManifestPayload(t, bob, fileID, "hello.txt", "text/plain", "helloworld", []ids.ID{hello.ID(), world.ID()}))
+
+	service := Service{vm}
+	reply := &GetFileReply{}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: bob.address, FileID: fileID}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.File.Creator != alice.address || reply.File.FileName != "hello.txt" || len(reply.Chunks) != 2 {
+		t.Fatalf("unexpected file %+v", reply.File)
+	}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: alice.address, FileID: fileID}, &GetFileReply{}); err != errFileTransferred {
+		t.Fatalf("expected %s but got %v", errFileTransferred, err)
+	}
+
+	for owner, expected := range map[string]int{alice.address: 0, bob.address: 1} {
+		listReply := &ListFilesReply{}
+		if err := service.ListFiles(nil, &ListFilesArgs{Owner: owner}, listReply); err != nil {
+			t.Fatal(err)
+		}
+		if len(listReply.Files) != expected {
+			t.Fatalf("expected %d files but got %d", expected, len(listReply.Files))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	gateway := &fileGateway{vm}
+	gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=%s", bob.address, fileID), nil))
+	if body := recorder.Body.String(); body != "helloworld" {
+		t.Fatalf("expected the file's content but got %q", body)
+	}
+
+	// Once a transfer back is verified, blocks built on it see it
+	back := newTestBlockAt(t, vm, newTestFileTransferPayload(t, bob, fileID, alice.address), time.Now())
+	if err := back.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	upload, err := vm.NewBlock(back.ID(), back.Height()+1, newTestUploadPayload(t, alice, fileID, 2, "!"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if creator, err := upload.getFileCreator(); err != nil || creator != alice.address {
+		t.Fatalf("expected creator %s but got %s (%v)", alice.address, creator, err)
+	}
+}
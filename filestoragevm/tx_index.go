@@ -0,0 +1,63 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+var (
+	txIndexPrefix = []byte("tx")
+
+	// Marks that the blocks accepted before the tx index existed were added
+	// to it. It's shorter than a tx ID, so it can't be one.
+	txsIndexedKey = []byte("indexed")
+
+	errNoSuchTx = errors.New("no accepted block has that transaction")
+)
+
+// indexTx records that the accepted block [b] holds the transaction with
+// [b]'s tx ID. The genesis block's data isn't a transaction, so it isn't
+// indexed.
+func (vm *VM) indexTx(b *Block) error {
+	if b.Height() == 0 {
+		return nil
+	}
+	txID := b.txID()
+	return database.PutID(vm.txDB, txID[:], b.ID())
+}
+
+// getBlockIDOfTx returns the ID of the accepted block holding the
+// transaction [txID]
+func (vm *VM) getBlockIDOfTx(txID ids.ID) (ids.ID, error) {
+	id, err := database.GetID(vm.txDB, txID[:])
+	if err == database.ErrNotFound {
+		return ids.Empty, errNoSuchTx
+	}
+	return id, err
+}
+
+// reindexTxs adds the blocks accepted before the tx index existed to it
+func (vm *VM) reindexTxs() error {
+	indexed, err := vm.txDB.Has(txsIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	blocks, err := vm.findAccepted(func(*Block) bool { return true })
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := vm.indexTx(block); err != nil {
+			return err
+		}
+	}
+	if err := vm.txDB.Put(txsIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
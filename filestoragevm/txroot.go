@@ -0,0 +1,159 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+var (
+	errWrongTxRoot   = errors.New("block's tx root doesn't match its transactions")
+	errRootlessBlock = errors.New("block has no tx root, but its parent does")
+	errNoChunk       = errors.New("block has no chunk")
+	errPrunedProof   = errors.New("block's data was pruned, so it can't be proven")
+	errRootlessProof = errors.New("block is from before tx roots, so it can't be proven")
+)
+
+// A block commits to its transactions through the Merkle root of their
+// leaves, which is in its header. The block's ID is the hash of its header
+// rather than of all its bytes, so a transaction or chunk can be proven to
+// be in a block from the header and a Merkle proof, without the rest of
+// the block.
+
+// rootlessBlock is how blocks were serialized after they had a base fee,
+// but before they had a tx root
+type rootlessBlock struct {
+	*core.Block `serialize:"true"`
+	Data        [dataLen]byte `serialize:"true"`
+	BaseFee     uint64        `serialize:"true"`
+}
+
+// blockHeader is the part of a block its ID is the hash of. The tx root
+// comes last, so it's in the same place in stateless blocks' headers.
+type blockHeader struct {
+	*core.Block `serialize:"true"`
+	BaseFee     uint64   `serialize:"true"`
+	StateRoot   [32]byte `serialize:"true"`
+	TxRoot      [32]byte `serialize:"true"`
+}
+
+// Number of leaves of every block's tx root. The count is fixed, so a
+// proof doesn't need it from whoever hands it over.
+const txLeafCount = 2
+
+// getTxLeaves returns the leaves of this block's tx root. The
+// transaction's ID is the first, and the SHA-256 of its chunk if it's an
+// upload the second, so a chunk can be proven without its transaction.
+// Other transactions' second leaf is all zeros.
+func (b *Block) getTxLeaves() [][32]byte {
+	leaves := [][32]byte{b.txID(), {}}
+	if b.isUploadBlock() {
+		if hash, err := b.getChunkHash(); err == nil {
+			leaves[1] = hash
+		}
+	}
+	return leaves
+}
+
+// computeTxRoot returns the tx root this block should have
+func (b *Block) computeTxRoot() [32]byte {
+	return merkleRoot(b.getTxLeaves())
+}
+
+// initializeBlock initializes [block], whose bytes are [bytes]. Blocks
+// with a tx root get their ID from their header.
+func (vm *VM) initializeBlock(block *Block, bytes []byte) error {
+	block.vm = vm
+	if block.rootless {
+		block.Initialize(bytes, &vm.SnowmanVM)
+		return nil
+	}
+	var header []byte
+	var err error
+	if block.stateless {
+		header, err = vm.codec.Marshal(codecVersion, &statelessHeader{
+			Block:   block.Block,
+			BaseFee: block.BaseFee,
+			TxRoot:  block.TxRoot,
+		})
+	} else {
+		header, err = vm.codec.Marshal(codecVersion, &blockHeader{
+			Block:     block.Block,
+			BaseFee:   block.BaseFee,
+			StateRoot: block.StateRoot,
+			TxRoot:    block.TxRoot,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	block.Initialize(header, &vm.SnowmanVM)
+	block.bytes = bytes
+	return nil
+}
+
+// Bytes returns this block's bytes. For a block with a tx root, the
+// embedded core.Block holds its header's bytes instead.
+func (b *Block) Bytes() []byte {
+	if b.bytes != nil {
+		return b.bytes
+	}
+	return b.Block.Bytes()
+}
+
+// getHeaderBytes returns the bytes of this block's header, which its ID is
+// the hash of
+func (b *Block) getHeaderBytes() []byte {
+	return b.Block.Bytes()
+}
+
+// verifyTxRoot returns nil iff this block's tx root matches its
+// transactions
+func (b *Block) verifyTxRoot(parent *Block) error {
+	if b.rootless {
+		// Only allowed until the chain's first block with a tx root
+		if !parent.rootless {
+			return errRootlessBlock
+		}
+		return nil
+	}
+	if b.TxRoot != b.computeTxRoot() {
+		return errWrongTxRoot
+	}
+	return nil
+}
+
+// getInclusionProof returns the leaf of this block's tx root for its
+// transaction, or for its chunk if [chunk], along with the leaf's index
+// and its Merkle proof
+func (b *Block) getInclusionProof(chunk bool) ([32]byte, int, [][32]byte, error) {
+	if b.pruned {
+		return [32]byte{}, 0, nil, errPrunedProof
+	}
+	leaves := b.getTxLeaves()
+	index := 0
+	if chunk {
+		if leaves[1] == [32]byte{} {
+			return [32]byte{}, 0, nil, errNoChunk
+		}
+		index = 1
+	}
+	return leaves[index], index, merkleProof(leaves, index), nil
+}
+
+// verifyInclusionProof returns true iff [header] is the header of the
+// block with ID [blockID], and [proof] shows that [leaf] is leaf [index]
+// of its tx root. The tx root is the header's last 32 bytes.
+func verifyInclusionProof(blockID ids.ID, header []byte, leaf [32]byte, index int, proof [][32]byte) bool {
+	if len(header) < 32 || ids.ID(sha256.Sum256(header)) != blockID {
+		return false
+	}
+	var root [32]byte
+	copy(root[:], header[len(header)-32:])
+	return verifyMerkleProof(leaf, index, txLeafCount, proof, root)
+}
@@ -0,0 +1,129 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/hashing"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+// decodeTestProof decodes the leaf, header and proof in [reply]
+func decodeTestProof(t *testing.T, reply *GetInclusionProofReply) ([32]byte, []byte, [][32]byte) {
+	leaf, err := parseChunkHash(reply.Leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := hex.DecodeString(reply.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := make([][32]byte, len(reply.Proof))
+	for i, hash := range reply.Proof {
+		if proof[i], err = parseChunkHash(hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return leaf, header, proof
+}
+
+func TestInclusionProofs(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	faucet := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+	upload := acceptTestPayload(t, vm, newTestUploadPayload(t, key, "file000000000001", 0, "hello"))
+
+	// The proofs check out against the block ID alone
+	service := Service{vm}
+	for _, chunk := range []bool{false, true} {
+		reply := &GetInclusionProofReply{}
+		if err := service.GetInclusionProof(nil, &GetInclusionProofArgs{BlockID: upload.ID().String(), Chunk: chunk}, reply); err != nil {
+			t.Fatal(err)
+		}
+		leaf, header, proof := decodeTestProof(t, reply)
+		expected := [32]byte(upload.txID())
+		if chunk {
+			expected = sha256.Sum256([]byte("hello"))
+		}
+		if leaf != expected {
+			t.Fatalf("expected leaf %x but got %x", expected, leaf)
+		}
+		if !verifyInclusionProof(upload.ID(), header, leaf, int(reply.Index), proof) {
+			t.Fatalf("proof of the upload's %s should verify", map[bool]string{false: "tx", true: "chunk"}[chunk])
+		}
+		if verifyInclusionProof(faucet.ID(), header, leaf, int(reply.Index), proof) {
+			t.Fatal("proof shouldn't verify against another block")
+		}
+		if verifyInclusionProof(upload.ID(), header, leaf, 1-int(reply.Index), proof) {
+			t.Fatal("proof shouldn't verify at the other index")
+		}
+	}
+	if err := service.GetInclusionProof(nil, &GetInclusionProofArgs{BlockID: faucet.ID().String(), Chunk: true}, &GetInclusionProofReply{}); err != errNoChunk {
+		t.Fatalf("expected %s but got %v", errNoChunk, err)
+	}
+
+	// Blocks whose tx root doesn't match their transaction are rejected
+	wrong := &Block{
+		Block:   core.NewBlock(upload.ID(), upload.Height()+1, time.Now().Unix()),
+		Data:    newTestUploadPayload(t, key, "file000000000001", 1, "world"),
+		BaseFee: upload.getNextBaseFee(),
+		TxRoot:  upload.TxRoot,
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(); err != errWrongTxRoot {
+		t.Fatalf("expected %s but got %v", errWrongTxRoot, err)
+	}
+}
+
+func TestRootlessBlock(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	genesis, err := vm.getBlockAtHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks serialized without a tx root still parse, and their ID is the
+	// hash of their bytes
+	rootless := &rootlessBlock{
+		Block:   core.NewBlock(genesis.ID(), 1, time.Now().Unix()),
+		Data:    newTestFaucetPayload(t, key, 10, key.address),
+		BaseFee: genesis.getNextBaseFee(),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, rootless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedIntf, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := parsedIntf.(*Block)
+	if !parsed.rootless || parsed.ID() != ids.ID(hashing.ComputeHash256Array(bytes)) || string(parsed.Bytes()) != string(bytes) {
+		t.Fatalf("expected a rootless block but got %+v", parsed)
+	}
+
+	// This chain's genesis block has a tx root, so its blocks must too
+	if err := parsed.Verify(); err != errRootlessBlock {
+		t.Fatalf("expected %s but got %v", errRootlessBlock, err)
+	}
+}
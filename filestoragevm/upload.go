@@ -0,0 +1,155 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Largest file the upload gateway accepts
+	maxUploadSize = 16 * 1024 * 1024
+
+	// Most of a multipart upload that's kept in memory while parsing
+	maxUploadMemory = 1024 * 1024
+)
+
+var _ http.Handler = &uploadGateway{}
+
+// uploadGateway stores files for clients that don't build transactions:
+//   POST /upload
+// The request is either a multipart form with a "file" part, or the raw
+// file as the body with the other fields as query parameters. The fields are:
+//   owner:     the address paying for and owning the file
+//   fileID:    the 16 character file ID
+//   expiry:    unix time after which the uploads are rejected
+//   signature: the owner's CB58 signature of the session message (see
+//              TRANSACTION.md), which commits to all of the above and the
+//              Merkle root of the file's chunks
+// The gateway chunks the file, builds a session upload for each chunk and
+// adds them to the mempool. It replies with the file ID and the tx IDs. The
+// blocks aren't built yet, so getBlockByTxID finds each one once it's
+// accepted, e.g. for the chunk IDs of the file's manifest.
+type uploadGateway struct{ vm *VM }
+
+// UploadReply is the reply from the upload gateway
+type UploadReply struct {
+	FileID     string   `json:"fileID"`
+	ChunkCount int      `json:"chunkCount"`
+	TxIDs      []string `json:"txIDs"`
+}
+
+func (g *uploadGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	var (
+		fields  func(string) string
+		content []byte
+		err     error
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err = ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields = r.FormValue
+	} else {
+		if content, err = ioutil.ReadAll(r.Body); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields = r.URL.Query().Get
+	}
+
+	owner, fileID, sig := fields("owner"), fields("fileID"), fields("signature")
+	if err := verifyFileArgs(owner, fileID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiry, err := strconv.ParseInt(fields("expiry"), 10, 64)
+	if err != nil || expiry < time.Now().Unix() || expiry > 9999999999 {
+		http.Error(w, "expiry must be a unix time in the future", http.StatusBadRequest)
+		return
+	}
+	if len(content) == 0 {
+		http.Error(w, "file is empty", http.StatusBadRequest)
+		return
+	}
+	if len(sig) > 100 {
+		http.Error(w, errInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+
+	chunks := splitSessionChunks(content)
+	leaves := make([][32]byte, len(chunks))
+	for i, chunk := range chunks {
+		leaves[i] = sha256.Sum256(chunk)
+	}
+	message := newSessionMessage(fileID, leaves, expiry)
+	if !verifySignature(owner, message, sig) {
+		http.Error(w, errInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+
+	uploads := make([][dataLen]byte, len(chunks))
+	contentLengths := make([]int64, len(chunks))
+	for i := range chunks {
+		uploads[i] = newSessionUpload(owner, sig, message, chunks, leaves, i)
+		contentLengths[i] = computeContentLength(uploads[i])
+	}
+
+	// Don't fill the mempool with uploads that can't be paid for
+	lastAccepted, err := g.vm.getLastAcceptedBlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fee, _ := g.vm.estimateFees(lastAccepted, contentLengths)
+	if err := lastAccepted.verifyBalance(owner, fee); err == errInsufficientBalance {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reply := UploadReply{
+		FileID:     fileID,
+		ChunkCount: len(chunks),
+		TxIDs:      make([]string, len(chunks)),
+	}
+	for i, data := range uploads {
+		g.vm.proposeBlock(data)
+		reply.TxIDs[i] = computeTxID(data).String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		g.vm.Ctx.Log.Debug("couldn't write upload reply: %s", err)
+	}
+}
@@ -0,0 +1,218 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+)
+
+// newTestSession returns [key]'s session signature for uploading [content]
+// as [fileID] until [expiry]
+func newTestSession(t *testing.T, key *testKey, fileID string, content []byte, expiry int64) string {
+	chunks := splitSessionChunks(content)
+	leaves := make([][32]byte, len(chunks))
+	for i, chunk := range chunks {
+		leaves[i] = sha256.Sum256(chunk)
+	}
+	sigBytes, err := key.sk.Sign(newSessionMessage(fileID, leaves, expiry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := formatting.EncodeWithChecksum(formatting.CB58, sigBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestUploadGateway(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+	content := bytes.Repeat([]byte("0123456789"), 500)
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+
+	// Upload as a multipart form
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for field, value := range map[string]string{
+		"owner":     key.address,
+		"fileID":    fileID,
+		"expiry":    fmt.Sprint(expiry),
+		"signature": newTestSession(t, key, fileID, content, expiry),
+	} {
+		if err := form.WriteField(field, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := form.CreateFormFile("file", "numbers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/upload", body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	(&uploadGateway{vm}).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	reply := UploadReply{}
+	if err := json.NewDecoder(recorder.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.FileID != fileID || reply.ChunkCount != 3 || len(reply.TxIDs) != 3 {
+		t.Fatalf("expected 3 chunks of %s but got %+v", fileID, reply)
+	}
+
+	service := Service{vm}
+	blockReply := GetBlockByTxIDReply{}
+	if err := service.GetBlockByTxID(nil, &GetBlockByTxIDArgs{TxID: reply.TxIDs[0]}, &blockReply); err != errNoSuchTx {
+		t.Fatalf("expected %q for a tx in the mempool but got %v", errNoSuchTx, err)
+	}
+
+	// The tx IDs resolve to the blocks' IDs, which manifests list
+	chunkIDs := []ids.ID{}
+	for i := 0; len(vm.mempool) > 0; i++ {
+		block := acceptNextTestBlock(t, vm)
+		if block.txID().String() != reply.TxIDs[i] {
+			t.Fatalf("expected tx %d to be %s but got %s", i, reply.TxIDs[i], block.txID())
+		}
+		if err := service.GetBlockByTxID(nil, &GetBlockByTxIDArgs{TxID: reply.TxIDs[i]}, &blockReply); err != nil {
+			t.Fatal(err)
+		}
+		if blockReply.ID != block.ID().String() {
+			t.Fatalf("expected tx %d to be in block %s but got %s", i, block.ID(), blockReply.ID)
+		}
+		chunkIDs = append(chunkIDs, block.ID())
+	}
+	acceptTestPayload(t, vm, newTestManifestPayload(t, key, fileID, "numbers.txt", "text/plain", string(content), chunkIDs))
+
+	fileReply := GetFileReply{}
+	args := &GetFileArgs{Owner: key.address, FileID: fileID, IncludeContent: true, Encoding: formatting.Hex}
+	if err := service.GetFile(nil, args, &fileReply); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := formatting.Decode(formatting.Hex, fileReply.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, content) {
+		t.Fatal("expected the stored file to match the uploaded one")
+	}
+	if fileReply.File.FileName != "numbers.txt" {
+		t.Fatalf("expected the manifest's file name but got %q", fileReply.File.FileName)
+	}
+}
+
+func TestUploadGatewayErrors(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	poor := newTestKey(t)
+	fileID := "file000000000001"
+	content := []byte("hello world")
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+
+	tests := []struct {
+		name           string
+		owner          string
+		expiry         int64
+		signature      string
+		expectedStatus int
+	}{
+		{"signed by someone else", key.address, expiry, newTestSession(t, poor, fileID, content, expiry), http.StatusForbidden},
+		{"signed other content", key.address, expiry, newTestSession(t, key, fileID, []byte("other"), expiry), http.StatusForbidden},
+		{"expired", key.address, 1, newTestSession(t, key, fileID, content, 1), http.StatusBadRequest},
+		{"can't pay", poor.address, expiry, newTestSession(t, poor, fileID, content, expiry), http.StatusPaymentRequired},
+	}
+	for _, test := range tests {
+		query := url.Values{}
+		query.Set("owner", test.owner)
+		query.Set("fileID", fileID)
+		query.Set("expiry", fmt.Sprint(test.expiry))
+		query.Set("signature", test.signature)
+		request := httptest.NewRequest(http.MethodPost, "/upload?"+query.Encode(), bytes.NewReader(content))
+		recorder := httptest.NewRecorder()
+		(&uploadGateway{vm}).ServeHTTP(recorder, request)
+		if recorder.Code != test.expectedStatus {
+			t.Fatalf("%s: expected status %d but got %d: %s", test.name, test.expectedStatus, recorder.Code, recorder.Body)
+		}
+	}
+	if len(vm.mempool) != 0 {
+		t.Fatal("expected nothing to be added to the mempool")
+	}
+}
+
+func TestSessionUploadTampered(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+	content := bytes.Repeat([]byte("abc"), 1000)
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	funded := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 10, key.address))
+
+	chunks := splitSessionChunks(content)
+	leaves := make([][32]byte, len(chunks))
+	for i, chunk := range chunks {
+		leaves[i] = sha256.Sum256(chunk)
+	}
+	message := newSessionMessage(fileID, leaves, expiry)
+	sig := newTestSession(t, key, fileID, content, expiry)
+
+	// Change the chunk's data after the proof was built
+	chunks[1] = append(chunks[1], 'x')
+	data := newSessionUpload(key.address, sig, message, chunks, leaves, 1)
+	block, err := vm.NewBlock(funded.ID(), funded.Height()+1, data, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Verify(); err != errSessionProof {
+		t.Fatalf("expected %s but got %v", errSessionProof, err)
+	}
+	if !block.hasValidSignature() {
+		t.Fatal("expected the session signature to still be valid")
+	}
+
+	// The session expires before the block's timestamp
+	expiry = time.Now().Add(time.Minute).Unix()
+	message = newSessionMessage(fileID, leaves, expiry)
+	sig = newTestSession(t, key, fileID, content, expiry)
+	data = newSessionUpload(key.address, sig, message, splitSessionChunks(content), leaves, 1)
+	block, err = vm.NewBlock(funded.ID(), funded.Height()+1, data, time.Unix(expiry+1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Verify(); err != errSessionExpired {
+		t.Fatalf("expected %s but got %v", errSessionExpired, err)
+	}
+}
@@ -0,0 +1,271 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/ava-labs/avalanchego/database"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/choices"
+)
+
+// Length of the hex encoded ID of the parent version in a version manifest
+const versionParentLen = 64
+
+var (
+	versionIndexPrefix         = []byte("version")
+	manifestVersionIndexPrefix = []byte("manifestVersion")
+
+	// Key in the manifest version index marking that it was backfilled.
+	// Its keys are otherwise block IDs, so it can't be one of them.
+	versionsIndexedKey = []byte("indexed")
+
+	errVersionParent = errors.New("version manifest's parent isn't the file's latest version")
+	errVersionNeeded = errors.New("file already has a manifest, so a new one has to be a version manifest")
+	errNoSuchVersion = errors.New("there is no such version of the file")
+)
+
+// Every manifest of a file is a version of it, numbered from 1 in the
+// order they're accepted. A version manifest names the version it
+// replaces, so a new version can't be made from an outdated one. Only a
+// file's first manifest can be a plain one.
+
+// A version manifest is a manifest with the ID of its parent version
+// after the file ID.
+func (b *Block) isVersionBlock() bool {
+	return b.getBlockType() == "b"
+}
+
+// returns the ID of the manifest of the version this one replaces
+func (b *Block) getVersionParentID() (ids.ID, error) {
+	idBytes, err := hex.DecodeString(string(b.Data[contentOffset+fileIDLen : contentOffset+fileIDLen+versionParentLen]))
+	if err != nil {
+		return ids.Empty, errMalformedManifest
+	}
+	id, err := ids.ToID(idBytes)
+	if err != nil {
+		return ids.Empty, errMalformedManifest
+	}
+	return id, nil
+}
+
+// versionKey returns the version index key of version [version] of
+// [creator]'s file [fileID]. Keys start with the file key, and the version
+// is big endian, so a file's versions can be iterated in order.
+func versionKey(creator string, fileID string, version uint64) []byte {
+	return append(fileKey(creator, fileID), database.PackUInt64(version)...)
+}
+
+// getLatestManifestID returns the ID of the latest manifest of [creator]'s
+// file [fileID] as of this block, or ids.Empty if it has none
+func (b *Block) getLatestManifestID(creator string, fileID string) (ids.ID, error) {
+	ancestry, err := b.unacceptedAncestry()
+	if err != nil {
+		return ids.Empty, err
+	}
+	for _, block := range ancestry {
+		if !block.isManifestBlock() {
+			continue
+		}
+		if isOfFile, err := block.isOfFile(creator, fileID); err != nil {
+			return ids.Empty, err
+		} else if isOfFile {
+			return block.ID(), nil
+		}
+	}
+	record, err := b.vm.getFileRecord(creator, fileID)
+	if err == errNoSuchFile {
+		return ids.Empty, nil
+	}
+	if err != nil {
+		return ids.Empty, err
+	}
+	return record.ManifestID, nil
+}
+
+// getManifestVersion returns the version number of the manifest in this
+// block. It's one more than the version it replaced, which is recorded
+// when the manifest is accepted.
+func (b *Block) getManifestVersion() (uint64, error) {
+	if b.Status() == choices.Accepted {
+		id := b.ID()
+		version, err := database.GetUInt64(b.vm.manifestVersionDB, id[:])
+		if err == database.ErrNotFound {
+			return 0, errNoSuchVersion
+		}
+		return version, err
+	}
+	return b.resolveManifestVersion()
+}
+
+// resolveManifestVersion works out the version number of the manifest in
+// this block from the state as of its parent
+func (b *Block) resolveManifestVersion() (uint64, error) {
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return 0, err
+	}
+	parent, err := b.getParent()
+	if err != nil {
+		return 0, err
+	}
+	latestID, err := parent.getLatestManifestID(creator, b.getManifestFileID())
+	if err != nil || latestID == ids.Empty {
+		return 1, err
+	}
+	latest, err := b.vm.getBlock(latestID)
+	if err != nil {
+		return 0, err
+	}
+	version, err := latest.getManifestVersion()
+	return version + 1, err
+}
+
+// verifyVersion returns nil iff this version manifest replaces the latest
+// version of its file, as of this block's parent
+func (b *Block) verifyVersion(parent *Block) error {
+	if b.getContentLength() < fileIDLen+versionParentLen {
+		return errMalformedManifest
+	}
+	parentID, err := b.getVersionParentID()
+	if err != nil {
+		return err
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	latestID, err := parent.getLatestManifestID(creator, b.getManifestFileID())
+	if err != nil {
+		return err
+	}
+	if latestID == ids.Empty || latestID != parentID {
+		return errVersionParent
+	}
+	return nil
+}
+
+// verifyFirstManifest returns nil iff this plain manifest is the first of
+// its file, as of this block's parent. Blocks without a tx root are
+// exempt, since plain manifests could replace a file's before them.
+func (b *Block) verifyFirstManifest(parent *Block) error {
+	if b.rootless {
+		return nil
+	}
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	latestID, err := parent.getLatestManifestID(creator, b.getManifestFileID())
+	if err != nil {
+		return err
+	}
+	if latestID != ids.Empty {
+		return errVersionNeeded
+	}
+	return nil
+}
+
+// indexVersion updates the version index with the accepted block [b]. It
+// works out the manifest's version from the file record, so it has to come
+// before the file index.
+func (vm *VM) indexVersion(b *Block) error {
+	if !b.isManifestBlock() {
+		return nil
+	}
+	id := b.ID()
+	if indexed, err := vm.manifestVersionDB.Has(id[:]); err != nil || indexed {
+		return err
+	}
+	version, err := b.resolveManifestVersion()
+	if err != nil {
+		return err
+	}
+	return vm.putManifestVersion(b, version)
+}
+
+// putManifestVersion records that the accepted manifest [b] is version
+// [version] of its file
+func (vm *VM) putManifestVersion(b *Block, version uint64) error {
+	creator, err := b.getFileCreator()
+	if err != nil {
+		return err
+	}
+	id := b.ID()
+	if err := database.PutUInt64(vm.manifestVersionDB, id[:], version); err != nil {
+		return err
+	}
+	return database.PutID(vm.versionDB, versionKey(creator, b.getManifestFileID(), version), id)
+}
+
+// getVersionManifestID returns the ID of the manifest of version [version]
+// of [creator]'s file [fileID], as of the last accepted block
+func (vm *VM) getVersionManifestID(creator string, fileID string, version uint64) (ids.ID, error) {
+	id, err := database.GetID(vm.versionDB, versionKey(creator, fileID, version))
+	if err == database.ErrNotFound {
+		return ids.Empty, errNoSuchVersion
+	}
+	return id, err
+}
+
+// getVersionRecord returns [record], the record of [creator]'s file
+// [fileID], as it was when version [version] was the latest
+func (vm *VM) getVersionRecord(creator string, fileID string, record *fileRecord, version uint64) (*fileRecord, error) {
+	manifestID, err := vm.getVersionManifestID(creator, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+	versionRecord := *record
+	versionRecord.ManifestID = manifestID
+	return &versionRecord, nil
+}
+
+// getVersionManifestIDs returns the IDs of the manifests of every version
+// of [creator]'s file [fileID], oldest first, as of the last accepted block
+func (vm *VM) getVersionManifestIDs(creator string, fileID string) ([]ids.ID, error) {
+	iter := vm.versionDB.NewIteratorWithPrefix(fileKey(creator, fileID))
+	defer iter.Release()
+
+	manifestIDs := []ids.ID{}
+	for iter.Next() {
+		id, err := ids.ToID(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		manifestIDs = append(manifestIDs, id)
+	}
+	return manifestIDs, iter.Error()
+}
+
+// reindexVersions adds the manifests accepted before the version index
+// existed to it, numbering each file's manifests in the order they were
+// accepted
+func (vm *VM) reindexVersions() error {
+	indexed, err := vm.manifestVersionDB.Has(versionsIndexedKey)
+	if err != nil || indexed {
+		return err
+	}
+	manifests, err := vm.findAccepted((*Block).isManifestBlock)
+	if err != nil {
+		return err
+	}
+	versions := map[string]uint64{}
+	for _, manifest := range manifests {
+		creator, err := manifest.getFileCreator()
+		if err != nil {
+			return err
+		}
+		key := string(fileKey(creator, manifest.getManifestFileID()))
+		versions[key]++
+		if err := vm.putManifestVersion(manifest, versions[key]); err != nil {
+			return err
+		}
+	}
+	if err := vm.manifestVersionDB.Put(versionsIndexedKey, nil); err != nil {
+		return err
+	}
+	return vm.DB.Commit()
+}
@@ -0,0 +1,128 @@
+// (c) 2019-2020, Ava Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package filestoragevm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/formatting"
+	"github.com/ava-labs/avalanchego/utils/json"
+	"github.com/ava-labs/avalanchego/vms/components/core"
+)
+
+// newTestVersionPayload returns a signed version manifest for [fileID]
+// replacing the version whose manifest is [parentID], whose content is
+// [content], stored in the upload blocks [chunkIDs]
+func newTestVersionPayload(t *testing.T, key *testKey, fileID string, parentID ids.ID, content string, chunkIDs []ids.ID) [dataLen]byte {
+	hash := sha256.Sum256([]byte(content))
+	body := fmt.Sprintf("%s%s%016d%08d%x%03d%03d", fileID, parentID.Hex(), len(content), len(chunkIDs), hash, 0, 0)
+	for _, chunkID := range chunkIDs {
+		body += chunkID.Hex()
+	}
+	return newTestPayload(t, key, 'b', body)
+}
+
+func TestFileVersions(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+	fileID := "file000000000001"
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 20, key.address))
+	hello := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 0, "hello"))
+	world := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 1, " world"))
+	if err := newTestBlockAt(t, vm, newTestVersionPayload(t, key, fileID, ids.Empty, "hello world", []ids.ID{hello.ID(), world.ID()}), time.Now()).Verify(); err != errVersionParent {
+		t.Fatalf("expected %s but got %v", errVersionParent, err)
+	}
+	v1 := acceptTestPayload(t, vm, newTestManifestPayload(t, key, fileID, "hello.txt", "text/plain", "hello world", []ids.ID{hello.ID(), world.ID()}))
+
+	// Version 2 appends a chunk, so it's the only one uploaded
+	bang := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 2, "!"))
+	v2 := acceptTestPayload(t, vm, newTestVersionPayload(t, key, fileID, v1.ID(), "hello world!", []ids.ID{hello.ID(), world.ID(), bang.ID()}))
+
+	// Version 3 replaces the middle chunk
+	there := acceptTestPayload(t, vm, newTestUploadPayload(t, key, fileID, 3, " there"))
+	if err := newTestBlockAt(t, vm, newTestVersionPayload(t, key, fileID, v1.ID(), "hello there!", []ids.ID{hello.ID(), there.ID(), bang.ID()}), time.Now()).Verify(); err != errVersionParent {
+		t.Fatalf("expected %s but got %v", errVersionParent, err)
+	}
+	v3 := acceptTestPayload(t, vm, newTestVersionPayload(t, key, fileID, v2.ID(), "hello there!", []ids.ID{hello.ID(), there.ID(), bang.ID()}))
+	// A plain manifest can't replace the file's versions
+	plain := newTestManifestPayload(t, key, fileID, "hello.txt", "text/plain", "hello", []ids.ID{hello.ID()})
+	if err := newTestBlockAt(t, vm, plain, time.Now()).Verify(); err != errVersionNeeded {
+		t.Fatalf("expected %s but got %v", errVersionNeeded, err)
+	}
+	// Unless it's from before blocks had a tx root
+	rootless := &rootlessBlock{
+		Block:   core.NewBlock(v3.ID(), v3.Height()+1, time.Now().Unix()),
+		Data:    plain,
+		BaseFee: v3.getNextBaseFee(),
+	}
+	bytes, err := vm.codec.Marshal(codecVersion, rootless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := vm.ParseBlock(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.(*Block).verifyFirstManifest(v3); err != nil {
+		t.Fatalf("expected a rootless block to be able to replace the manifest but got %v", err)
+	}
+	if version, err := v3.getManifestVersion(); err != nil || version != 3 {
+		t.Fatalf("expected version 3 but got %d (%v)", version, err)
+	}
+
+	service := Service{vm}
+	versionsReply := &ListVersionsReply{}
+	if err := service.ListVersions(nil, &ListVersionsArgs{Owner: key.address, FileID: fileID}, versionsReply); err != nil {
+		t.Fatal(err)
+	}
+	if len(versionsReply.Versions) != 3 || versionsReply.Versions[2].ParentID != v2.ID().String() {
+		t.Fatalf("unexpected versions %+v", versionsReply.Versions)
+	}
+
+	for version, expected := range map[uint64]string{0: "hello there!", 1: "hello world", 2: "hello world!"} {
+		reply := &GetFileReply{}
+		args := &GetFileArgs{Owner: key.address, FileID: fileID, IncludeContent: true, Encoding: formatting.Hex, Version: json.Uint64(version)}
+		if err := service.GetFile(nil, args, reply); err != nil {
+			t.Fatal(err)
+		}
+		content, err := formatting.Decode(formatting.Hex, reply.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("expected %q for version %d but got %q", expected, version, content)
+		}
+	}
+	if err := service.GetFile(nil, &GetFileArgs{Owner: key.address, FileID: fileID, Version: 4}, &GetFileReply{}); err != errNoSuchVersion {
+		t.Fatalf("expected %s but got %v", errNoSuchVersion, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	gateway := &fileGateway{vm}
+	gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/file?owner=%s&fileID=%s&version=2", key.address, fileID), nil))
+	if body := recorder.Body.String(); body != "hello world!" {
+		t.Fatalf("expected version 2's content but got %q", body)
+	}
+
+	// Nodes that accepted the manifests before the version index existed
+	// number them the same way
+	if err := vm.manifestVersionDB.Delete(versionsIndexedKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.reindexVersions(); err != nil {
+		t.Fatal(err)
+	}
+	if manifestID, err := vm.getVersionManifestID(key.address, fileID, 3); err != nil || manifestID != v3.ID() {
+		t.Fatalf("expected version 3's manifest %s but got %s (%v)", v3.ID(), manifestID, err)
+	}
+}
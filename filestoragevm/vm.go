@@ -11,9 +11,12 @@ import (
 	"github.com/gorilla/rpc/v2"
 	log "github.com/inconshreveable/log15"
 
+	"github.com/ava-labs/avalanchego/cache"
 	"github.com/ava-labs/avalanchego/codec"
 	"github.com/ava-labs/avalanchego/codec/linearcodec"
+	"github.com/ava-labs/avalanchego/database"
 	"github.com/ava-labs/avalanchego/database/manager"
+	"github.com/ava-labs/avalanchego/database/prefixdb"
 	"github.com/ava-labs/avalanchego/ids"
 	"github.com/ava-labs/avalanchego/snow"
 	"github.com/ava-labs/avalanchego/snow/consensus/snowman"
@@ -46,8 +49,125 @@ type VM struct {
 	codec codec.Manager
 	db manager.VersionedDatabase
 
+	// Maps the height of each accepted block to its ID
+	heightDB database.Database
+
+	// Maps the ID of each accepted transaction to its block's ID
+	txDB database.Database
+
+	// Maps (creator, file ID) to what's known about the file, and
+	// (creator, file ID, chunk number) to the block holding the chunk and
+	// the size of its data
+	fileDB      database.Database
+	chunkDB     database.Database
+	chunkSizeDB database.Database
+
+	// Maps the SHA-256 of a chunk's data to the first accepted upload
+	// holding that data
+	contentDB database.Database
+
+	// Maps (creator, file ID) to when the file expires. The expiry queue has
+	// the same entries keyed by expiry first, so they're in the order they
+	// expire in.
+	expiryDB      database.Database
+	expiryQueueDB database.Database
+
+	// Maps (SHA-256 of a chunk's data, creator, file ID, chunk number) to
+	// the accepted upload, or chunk reference, of that data in that file
+	contentHolderDB database.Database
+
+	// Maps (creator, file ID) to the total rent the file's renewals and
+	// uploads with retention paid
+	rentPaidDB database.Database
+
+	// Has (creator, file ID) of each file created by an upload with
+	// retention
+	retentionDB database.Database
+
+	// Maps (creator, file ID) of each deleted file to the deletion's block ID
+	deletedDB database.Database
+
+	// Maps the ID of each pruned upload to what's left of it
+	prunedDB database.Database
+
+	// Maps (owner, file ID) of each file an account owns to the file's
+	// creator, which the other indexes are keyed by
+	ownerDB database.Database
+
+	// Has the (owner, file ID) of each file an account gave away, and
+	// hasn't been given back
+	transferredDB database.Database
+
+	// Maps the ID of each accepted block about a file that its signer
+	// didn't create to the file's creator
+	creatorDB database.Database
+
+	// Maps (creator, file ID, account) to the account's role on the file,
+	// and the (account, file ID) it names the file by to the file's creator
+	fileAccessDB database.Database
+	sharedDB     database.Database
+
+	// Maps (creator, file ID, version) to the ID of the version's manifest,
+	// and the ID of each accepted manifest to its version
+	versionDB         database.Database
+	manifestVersionDB database.Database
+
+	// Maps (creator, file ID) to the file's path, and (owner, path) to
+	// the file ID of the owner's file there
+	pathDB      database.Database
+	directoryDB database.Database
+
+	// Maps (creator, file ID) of each file that was transferred to its
+	// owner
+	fileOwnerDB database.Database
+
+	// Maps each anchored hash to the ID of its first accepted anchor
+	anchorDB database.Database
+
+	// Maps each state leaf's key, after its bucket, to its value as of the
+	// last accepted block, and each bucket to its root. See state.go.
+	stateDB       database.Database
+	stateBucketDB database.Database
+
+	// Map (creator, file ID, height) to the accepted block there about the
+	// file, (account, file ID, height) to the creator of the account's file
+	// from there, and (reward address, height) to the accepted stake there.
+	// See history.go.
+	fileHistoryDB        database.Database
+	fileCreatorHistoryDB database.Database
+	stakeHistoryDB       database.Database
+
+	// Maps (account, height, entry type) to the accepted block there that
+	// involves the account. See account_history.go.
+	accountHistoryDB database.Database
+
+	// The chain's parameters, from the genesis block
+	params genesisParams
+
+	// This node's configuration
+	config vmConfig
+
 	// Proposed pieces of data that haven't been put into a block and proposed yet
 	mempool [][dataLen]byte
+
+	// When the mempool last went from empty to having data in it
+	pendingSince time.Time
+
+	// This chain's Prometheus metrics
+	metrics metrics
+
+	// Caches of decided blocks, blocks' state changes and balances as of
+	// blocks. See cache.go.
+	blockCache     cache.Cacher
+	stateDiffCache cache.Cacher
+	balanceCache   cache.Cacher
+
+	// Checks the signatures of the blocks the engine parses. See
+	// signatures.go.
+	signatures signatureVerifier
+
+	// Checks the P-chain for the health check. See health.go.
+	validatorProbe validatorProbe
 }
 
 // Initialize this vm
@@ -72,16 +192,58 @@ func (vm *VM) Initialize(
 		return err
 	}
 	log.Info("Initializing Timestamp VM", "Version", version)
-	if err := vm.SnowmanVM.Initialize(ctx, dbManager.Current().Database, vm.ParseBlock, toEngine); err != nil {
+	if err := vm.SnowmanVM.Initialize(ctx, dbManager.Current().Database, vm.parseBlock, toEngine); err != nil {
 		log.Error("error initializing SnowmanVM: %v", err)
 		return err
 	}
+	if err := vm.metrics.Initialize(ctx.Namespace, ctx.Metrics); err != nil {
+		return fmt.Errorf("error while registering metrics: %w", err)
+	}
 	c := linearcodec.NewDefault()
 	manager := codec.NewDefaultManager()
 	if err := manager.RegisterCodec(codecVersion, c); err != nil {
 		return err
 	}
 	vm.codec = manager
+	vm.heightDB = prefixdb.New(heightIndexPrefix, vm.DB)
+	vm.txDB = prefixdb.New(txIndexPrefix, vm.DB)
+	vm.fileDB = prefixdb.New(fileIndexPrefix, vm.DB)
+	vm.chunkDB = prefixdb.New(chunkIndexPrefix, vm.DB)
+	vm.chunkSizeDB = prefixdb.New(chunkSizeIndexPrefix, vm.DB)
+	vm.contentDB = prefixdb.New(contentIndexPrefix, vm.DB)
+	vm.expiryDB = prefixdb.New(expiryIndexPrefix, vm.DB)
+	vm.expiryQueueDB = prefixdb.New(expiryQueuePrefix, vm.DB)
+	vm.contentHolderDB = prefixdb.New(contentHolderIndexPrefix, vm.DB)
+	vm.rentPaidDB = prefixdb.New(rentPaidIndexPrefix, vm.DB)
+	vm.retentionDB = prefixdb.New(retentionIndexPrefix, vm.DB)
+	vm.deletedDB = prefixdb.New(deletedIndexPrefix, vm.DB)
+	vm.prunedDB = prefixdb.New(prunedIndexPrefix, vm.DB)
+	vm.ownerDB = prefixdb.New(ownerIndexPrefix, vm.DB)
+	vm.transferredDB = prefixdb.New(transferredIndexPrefix, vm.DB)
+	vm.creatorDB = prefixdb.New(creatorIndexPrefix, vm.DB)
+	vm.fileAccessDB = prefixdb.New(fileAccessIndexPrefix, vm.DB)
+	vm.sharedDB = prefixdb.New(sharedIndexPrefix, vm.DB)
+	vm.versionDB = prefixdb.New(versionIndexPrefix, vm.DB)
+	vm.manifestVersionDB = prefixdb.New(manifestVersionIndexPrefix, vm.DB)
+	vm.pathDB = prefixdb.New(pathIndexPrefix, vm.DB)
+	vm.directoryDB = prefixdb.New(directoryIndexPrefix, vm.DB)
+	vm.fileOwnerDB = prefixdb.New(fileOwnerIndexPrefix, vm.DB)
+	vm.anchorDB = prefixdb.New(anchorIndexPrefix, vm.DB)
+	vm.stateDB = prefixdb.New(stateIndexPrefix, vm.DB)
+	vm.stateBucketDB = prefixdb.New(stateBucketIndexPrefix, vm.DB)
+	vm.fileHistoryDB = prefixdb.New(fileHistoryIndexPrefix, vm.DB)
+	vm.fileCreatorHistoryDB = prefixdb.New(fileCreatorHistoryIndexPrefix, vm.DB)
+	vm.stakeHistoryDB = prefixdb.New(stakeHistoryIndexPrefix, vm.DB)
+	vm.accountHistoryDB = prefixdb.New(accountHistoryIndexPrefix, vm.DB)
+	if vm.config, err = parseConfig(configData); err != nil {
+		return err
+	}
+	if err := vm.initializeCaches(ctx.Namespace, ctx.Metrics); err != nil {
+		return fmt.Errorf("error while creating caches: %w", err)
+	}
+	if err := vm.signatures.Initialize(ctx.Namespace, ctx.Metrics, cacheSize(vm.config.SignatureCacheSize, defaultSignatureCacheSize), vm.config.getSignatureWorkers()); err != nil {
+		return fmt.Errorf("error while starting signature workers: %w", err)
+	}
 
 	// If database is empty, create it using the provided genesis data
 	if !vm.DBInitialized() {
@@ -123,16 +285,74 @@ func (vm *VM) Initialize(
 			return err
 		}
 	}
+
+	// Read the parameters from the genesis block rather than [genesisData],
+	// so they're the ones the chain was created with. Indexing depends on
+	// them, so this comes first.
+	genesisBlock, err := vm.getGenesisBlock()
+	if err != nil {
+		return fmt.Errorf("error while getting genesis block: %w", err)
+	}
+	vm.params = parseGenesisParams(genesisBlock.Data[:])
+
+	// Chains accepted before the indexes existed need them backfilled
+	if err := vm.reindexOwners(); err != nil {
+		return fmt.Errorf("error while indexing file owners: %w", err)
+	}
+	if err := vm.reindexVersions(); err != nil {
+		return fmt.Errorf("error while indexing file versions: %w", err)
+	}
+	if err := vm.reindexPaths(); err != nil {
+		return fmt.Errorf("error while indexing file paths: %w", err)
+	}
+	if err := vm.reindex(); err != nil {
+		return fmt.Errorf("error while indexing accepted blocks: %w", err)
+	}
+	// The state and history indexes depend on the creator index, so
+	// they're backfilled after the blocks missing from it are indexed
+	if err := vm.reindexState(); err != nil {
+		return fmt.Errorf("error while indexing the state: %w", err)
+	}
+	if err := vm.reindexHistory(); err != nil {
+		return fmt.Errorf("error while indexing history: %w", err)
+	}
+	if err := vm.reindexAccountHistory(); err != nil {
+		return fmt.Errorf("error while indexing account history: %w", err)
+	}
+	if err := vm.reindexTxs(); err != nil {
+		return fmt.Errorf("error while indexing transactions: %w", err)
+	}
+	if err := vm.loadUnpaidStakes(); err != nil {
+		return fmt.Errorf("error while finding unpaid stakes: %w", err)
+	}
 	return nil
 }
 
+// Shutdown stops the signature workers and waits for the P-chain check,
+// then closes the database
+func (vm *VM) Shutdown() error {
+	vm.signatures.stop()
+	vm.validatorProbe.wait()
+	return vm.SnowmanVM.Shutdown()
+}
+
 // CreateHandlers returns a map where:
-// Keys: The path extension for this VM's API (empty in this case)
+// Keys: The path extension for this VM's API
+//   "" is the JSON-RPC API, "/file" is the HTTP file download gateway
+//   and "/upload" is the HTTP file upload gateway
 // Values: The handler for the API
 func (vm *VM) CreateHandlers() (map[string]*common.HTTPHandler, error) {
-	handler, err := vm.NewHandler(Name, &Service{vm})
+	server := rpc.NewServer()
+	codec := cjson.NewCodec()
+	server.RegisterCodec(codec, "application/json")
+	server.RegisterCodec(codec, "application/json;charset=UTF-8")
+	server.RegisterInterceptFunc(vm.metrics.apiRequestMetric.InterceptRequest)
+	server.RegisterAfterFunc(vm.metrics.apiRequestMetric.AfterRequest)
+	err := server.RegisterService(&Service{vm}, Name)
 	return map[string]*common.HTTPHandler{
-		"": handler,
+		"":        {LockOptions: common.WriteLock, Handler: server},
+		"/file":   {LockOptions: common.ReadLock, Handler: &fileGateway{vm}},
+		"/upload": {LockOptions: common.WriteLock, Handler: &uploadGateway{vm}},
 	}, err
 }
 
@@ -154,9 +374,6 @@ func (vm *VM) CreateStaticHandlers() (map[string]*common.HTTPHandler, error) {
 	}, newServer.RegisterService(staticService, Name)
 }
 
-// Health implements the common.VM interface
-func (vm *VM) HealthCheck() (interface{}, error) { return nil, nil }
-
 // BuildBlock returns a block that this vm wants to add to consensus
 func (vm *VM) BuildBlock() (snowman.Block, error) {
 	if len(vm.mempool) == 0 { // There is no block to be built
@@ -166,6 +383,7 @@ func (vm *VM) BuildBlock() (snowman.Block, error) {
 	// Get the value to put in the new block
 	value := vm.mempool[0]
 	vm.mempool = vm.mempool[1:]
+	vm.metrics.mempoolDepth.Set(float64(len(vm.mempool)))
 
 	// Notify consensus engine that there are more pending data for blocks
 	// (if that is the case) when done building this block
@@ -190,6 +408,7 @@ func (vm *VM) BuildBlock() (snowman.Block, error) {
 	if err := block.Verify(); err != nil {
 		return nil, err
 	}
+	vm.metrics.blocksBuilt.Inc()
 	return block, nil
 }
 
@@ -198,43 +417,111 @@ func (vm *VM) BuildBlock() (snowman.Block, error) {
 // that a new block is ready to be added to consensus
 // (namely, a block with data [data])
 func (vm *VM) proposeBlock(data [dataLen]byte) {
+	if len(vm.mempool) == 0 {
+		vm.pendingSince = time.Now()
+	}
 	vm.mempool = append(vm.mempool, data)
+	vm.metrics.mempoolDepth.Set(float64(len(vm.mempool)))
 	vm.NotifyBlockReady()
 }
 
 // ParseBlock parses [bytes] to a snowman.Block
-// This function is used by the vm's state to unmarshal blocks saved in state
-// and by the consensus layer when it receives the byte representation of a block
-// from another node
+// This function is used by the consensus layer when it receives the byte
+// representation of a block from another node. The block's signature is
+// checked in the background until it's verified.
 func (vm *VM) ParseBlock(bytes []byte) (snowman.Block, error) {
+	block, err := vm.parseBlock(bytes)
+	if err != nil {
+		return nil, err
+	}
+	vm.signatures.queue(block.(*Block))
+	return block, nil
+}
+
+// parseBlock parses [bytes] to a snowman.Block
+// This function is used by the vm's state to unmarshal blocks saved in state
+func (vm *VM) parseBlock(bytes []byte) (snowman.Block, error) {
 	// A new empty block
 	block := &Block{}
 
 	// Unmarshal the byte repr. of the block into our empty block
 	_, err := vm.codec.Unmarshal(bytes, block)
 	if err != nil {
-		return nil, err
+		// Blocks from before state roots, tx roots, or base fees, were in
+		// the header don't have them
+		stateless := &statelessBlock{}
+		rootless := &rootlessBlock{}
+		legacy := &legacyBlock{}
+		if _, statelessErr := vm.codec.Unmarshal(bytes, stateless); statelessErr == nil {
+			block = &Block{Block: stateless.Block, Data: stateless.Data, BaseFee: stateless.BaseFee, TxRoot: stateless.TxRoot, stateless: true}
+		} else if _, rootlessErr := vm.codec.Unmarshal(bytes, rootless); rootlessErr == nil {
+			block = &Block{Block: rootless.Block, Data: rootless.Data, BaseFee: rootless.BaseFee, rootless: true, stateless: true}
+		} else if _, legacyErr := vm.codec.Unmarshal(bytes, legacy); legacyErr == nil {
+			block = &Block{Block: legacy.Block, Data: legacy.Data, legacy: true, rootless: true, stateless: true}
+		} else {
+			return nil, err
+		}
 	}
 
 	// Initialize the block
 	// (Block inherits Initialize from its embedded *core.Block)
-	block.Initialize(bytes, &vm.SnowmanVM)
+	if err := vm.initializeBlock(block, bytes); err != nil {
+		return nil, err
+	}
 
 	// Return the block
 	return block, nil
 }
 
+// getBlock returns the block with ID [id] as a *Block
+func (vm *VM) getBlock(id ids.ID) (*Block, error) {
+	blockIntf, err := vm.GetBlock(id)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blockIntf.(*Block)
+	if !ok {
+		return nil, errBlockType
+	}
+	return block, nil
+}
+
 // NewBlock returns a new Block where:
 // - the block's parent is [parentID]
 // - the block's data is [data]
 // - the block's timestamp is [timestamp]
+// - the block's base fee is the one its parent sets
+// - the block's tx root commits to [data]
+// - the block's state root commits to the state as of the block
 // The block is persisted in storage
 func (vm *VM) NewBlock(parentID ids.ID, height uint64, data [dataLen]byte, timestamp time.Time) (*Block, error) {
+	// The genesis block has no parent, and nothing in it pays a fee
+	var baseFee uint64
+	if parentID != ids.Empty {
+		parent, err := vm.getBlock(parentID)
+		if err != nil {
+			return nil, err
+		}
+		baseFee = parent.getNextBaseFee()
+	}
+
 	// Create our new block
 	block := &Block{
 		Block:     core.NewBlock(parentID, height, timestamp.Unix()),
 		Data:      data,
+		BaseFee:   baseFee,
+	}
+	block.TxRoot = block.computeTxRoot()
+
+	// The state as of the block depends on its parent, so the block has to
+	// be able to find it before it has its ID
+	block.vm = vm
+	block.Initialize(nil, &vm.SnowmanVM)
+	stateRoot, err := block.computeStateRoot()
+	if err != nil {
+		return nil, err
 	}
+	block.StateRoot = stateRoot
 
 	// Get the byte representation of the block
 	blockBytes, err := vm.codec.Marshal(codecVersion, block)
@@ -244,7 +531,9 @@ func (vm *VM) NewBlock(parentID ids.ID, height uint64, data [dataLen]byte, times
 
 	// Initialize the block by providing it with its byte representation
 	// and a reference to SnowmanVM
-	block.Initialize(blockBytes, &vm.SnowmanVM)
+	if err := vm.initializeBlock(block, blockBytes); err != nil {
+		return nil, err
+	}
 	return block, nil
 }
 
@@ -4,6 +4,7 @@
 package filestoragevm
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"testing"
 
@@ -11,11 +12,156 @@ import (
 	"github.com/ava-labs/avalanchego/ids"
 	"github.com/ava-labs/avalanchego/snow"
 	"github.com/ava-labs/avalanchego/snow/engine/common"
+	"github.com/ava-labs/avalanchego/utils/crypto"
+	"github.com/ava-labs/avalanchego/utils/formatting"
 	"github.com/ava-labs/avalanchego/version"
 )
 
 var blockchainID = ids.ID{1, 2, 3}
 
+// testKey is an account used to sign test transactions
+type testKey struct {
+	sk      *crypto.PrivateKeySECP256K1R
+	address string // CB58 public key, as it appears in transactions
+}
+
+// newTestKey returns a new account whose address fits the 50 byte
+// address fields of the transaction layout
+func newTestKey(t testing.TB) *testKey {
+	factory := crypto.FactorySECP256K1R{}
+	for {
+		skIntf, err := factory.NewPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk := skIntf.(*crypto.PrivateKeySECP256K1R)
+		address, err := formatting.EncodeWithChecksum(formatting.CB58, sk.PublicKey().Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(address) == 50 {
+			return &testKey{sk: sk, address: address}
+		}
+	}
+}
+
+// newTestPayload packs [content] into a message of type [txType] and signs
+// it with [key], the same way cli.py's pack_block does
+func newTestPayload(t testing.TB, key *testKey, txType byte, content string) [dataLen]byte {
+	return newTestPayloadWithLength(t, key, txType, content, len(content))
+}
+
+// newTestPayloadWithLength is like newTestPayload, but declares that the
+// content is [length] bytes long
+func newTestPayloadWithLength(t testing.TB, key *testKey, txType byte, content string, length int) [dataLen]byte {
+	message := make([]byte, dataLen-153)
+	copy(message, fmt.Sprintf("%c%04d%s", txType, length, content))
+	sigBytes, err := key.sk.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := formatting.EncodeWithChecksum(formatting.CB58, sigBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data [dataLen]byte
+	copy(data[0:50], key.address)
+	copy(data[50:53], fmt.Sprintf("%03d", len(sig)))
+	copy(data[53:153], sig)
+	copy(data[153:], message)
+	return data
+}
+
+// newTestFaucetPayload returns a signed faucet transaction paying
+// [amount] to [recipient]
+func newTestFaucetPayload(t testing.TB, key *testKey, amount int64, recipient string) [dataLen]byte {
+	return newTestPayload(t, key, '9', fmt.Sprintf("%016d%s", amount, recipient))
+}
+
+// newTestUploadPayload returns a signed upload transaction for chunk
+// [chunkNumber] of [fileID]
+func newTestUploadPayload(t *testing.T, key *testKey, fileID string, chunkNumber int, chunk string) [dataLen]byte {
+	return newTestPayload(t, key, '0', fmt.Sprintf("%s%08d%s", fileID, chunkNumber, chunk))
+}
+
+// newTestReferencePayload returns a signed chunk reference to the data
+// whose hex encoded SHA-256 is [hash], as chunk [chunkNumber] of [fileID]
+func newTestReferencePayload(t *testing.T, key *testKey, fileID string, chunkNumber int, hash string) [dataLen]byte {
+	return newTestPayload(t, key, '5', fmt.Sprintf("%s%08d%s", fileID, chunkNumber, hash))
+}
+
+// newTestManifestPayload returns a signed manifest for [fileID] whose
+// content is [content], stored in the upload blocks [chunkIDs]
+func newTestManifestPayload(t *testing.T, key *testKey, fileID string, name string, mimeType string, content string, chunkIDs []ids.ID) [dataLen]byte {
+	hash := sha256.Sum256([]byte(content))
+	body := fmt.Sprintf("%s%016d%08d%x%03d%s%03d%s", fileID, len(content), len(chunkIDs), hash, len(name), name, len(mimeType), mimeType)
+	for _, chunkID := range chunkIDs {
+		body += chunkID.Hex()
+	}
+	return newTestPayload(t, key, '3', body)
+}
+
+// newTestVM returns an initialized VM whose preference is its genesis block
+func newTestVM(t testing.TB) (*VM, *snow.Context, chan common.Message) {
+	return newTestVMWithGenesis(t, []byte{0, 0, 0, 0, 0})
+}
+
+func newTestVMWithGenesis(t testing.TB, genesisData []byte) (*VM, *snow.Context, chan common.Message) {
+	return newTestVMWithConfig(t, genesisData, nil)
+}
+
+// newTestVMWithConfig returns an initialized VM whose genesis data is
+// [genesisData] and whose config is [configData]
+func newTestVMWithConfig(t testing.TB, genesisData []byte, configData []byte) (*VM, *snow.Context, chan common.Message) {
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	msgChan := make(chan common.Message, 1)
+	vm := &VM{}
+	ctx := snow.DefaultContextTest()
+	ctx.ChainID = blockchainID
+	if err := vm.Initialize(ctx, dbManager, genesisData, nil, configData, msgChan, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.SetPreference(vm.LastAcceptedID); err != nil {
+		t.Fatal(err)
+	}
+	return vm, ctx, msgChan
+}
+
+// getTestBalance returns [account]'s balance as of [block]
+func getTestBalance(t testing.TB, block *Block, account string) int64 {
+	balance, err := block.getBalance(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+// acceptTestPayload proposes [data], then builds, verifies and accepts the
+// resulting block
+func acceptTestPayload(t testing.TB, vm *VM, data [dataLen]byte) *Block {
+	vm.proposeBlock(data)
+	return acceptNextTestBlock(t, vm)
+}
+
+// acceptNextTestBlock builds, verifies and accepts a block from the
+// mempool
+func acceptNextTestBlock(t testing.TB, vm *VM) *Block {
+	snowmanBlock, err := vm.BuildBlock()
+	if err != nil {
+		t.Fatalf("problem building block: %s", err)
+	}
+	if err := snowmanBlock.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := snowmanBlock.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.SetPreference(snowmanBlock.ID()); err != nil {
+		t.Fatal(err)
+	}
+	return snowmanBlock.(*Block)
+}
+
 // Utility function to assert that [block] has:
 // * Parent with ID [parentID]
 // * Data [expectedData]
@@ -45,7 +191,7 @@ func TestGenesis(t *testing.T) {
 	ctx := snow.DefaultContextTest()
 	ctx.ChainID = blockchainID
 
-	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil); err != nil {
+	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -75,7 +221,7 @@ func TestGenesis(t *testing.T) {
 	}
 
 	// Verify that the genesis block has the data we expect
-	if err := assertBlock(genesisBlock, ids.Empty, ids.ID{0, 0, 0, 0, 0}, true); err != nil {
+	if err := assertBlock(genesisBlock, ids.Empty, [dataLen]byte{0, 0, 0, 0, 0}, true); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -87,9 +233,12 @@ func TestHappyPath(t *testing.T) {
 	vm := &VM{}
 	ctx := snow.DefaultContextTest()
 	ctx.ChainID = blockchainID
-	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil); err != nil {
+	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil, nil); err != nil {
 		t.Fatal(err)
 	}
+	key := newTestKey(t)
+	firstData := newTestFaucetPayload(t, key, 1, key.address)
+	secondData := newTestFaucetPayload(t, key, 2, key.address)
 
 	lastAcceptedID, err := vm.LastAccepted()
 	if err != nil {
@@ -105,7 +254,7 @@ func TestHappyPath(t *testing.T) {
 	}
 
 	ctx.Lock.Lock()
-	vm.proposeBlock([dataLen]byte{0, 0, 0, 0, 1}) // propose a value
+	vm.proposeBlock(firstData) // propose a value
 	ctx.Lock.Unlock()
 
 	select { // assert there is a pending tx message to the engine
@@ -147,11 +296,11 @@ func TestHappyPath(t *testing.T) {
 		t.Fatal("genesis block should be type *Block")
 	}
 	// Assert the block we accepted has the data we expect
-	if err := assertBlock(block2, genesisBlock.ID(), [dataLen]byte{0, 0, 0, 0, 1}, true); err != nil {
+	if err := assertBlock(block2, genesisBlock.ID(), firstData, true); err != nil {
 		t.Fatal(err)
 	}
 
-	vm.proposeBlock([dataLen]byte{0, 0, 0, 0, 2}) // propose a block
+	vm.proposeBlock(secondData) // propose a block
 	ctx.Lock.Unlock()
 
 	select { // verify there is a pending tx message to the engine
@@ -194,7 +343,7 @@ func TestHappyPath(t *testing.T) {
 		t.Fatal("genesis block should be type *Block")
 	}
 	// Assert the block we accepted has the data we expect
-	if err := assertBlock(block3, snowmanBlock2.ID(), [dataLen]byte{0, 0, 0, 0, 2}, true); err != nil {
+	if err := assertBlock(block3, snowmanBlock2.ID(), secondData, true); err != nil {
 		t.Fatal(err)
 	}
 
@@ -220,7 +369,7 @@ func TestService(t *testing.T) {
 	vm := &VM{}
 	ctx := snow.DefaultContextTest()
 	ctx.ChainID = blockchainID
-	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil); err != nil {
+	if err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -229,3 +378,50 @@ func TestService(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHeightIndex(t *testing.T) {
+	vm, ctx, _ := newTestVM(t)
+	key := newTestKey(t)
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	block1 := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 1, key.address))
+	block2 := acceptTestPayload(t, vm, newTestFaucetPayload(t, key, 2, key.address))
+
+	service := Service{vm}
+	heightReply := GetHeightReply{}
+	if err := service.GetHeight(nil, &GetHeightArgs{}, &heightReply); err != nil {
+		t.Fatal(err)
+	}
+	if heightReply.Height != 2 || heightReply.ID != block2.ID().String() {
+		t.Fatalf("expected height 2 at %s but got %d at %s", block2.ID(), heightReply.Height, heightReply.ID)
+	}
+
+	blockReply := GetBlockByHeightReply{}
+	if err := service.GetBlockByHeight(nil, &GetBlockByHeightArgs{Height: 1}, &blockReply); err != nil {
+		t.Fatal(err)
+	}
+	if blockReply.ID != block1.ID().String() {
+		t.Fatalf("expected block %s at height 1 but got %s", block1.ID(), blockReply.ID)
+	}
+	if err := service.GetBlockByHeight(nil, &GetBlockByHeightArgs{Height: 3}, &blockReply); err != errNoBlockAtHeight {
+		t.Fatalf("expected %s but got %v", errNoBlockAtHeight, err)
+	}
+
+	rangeReply := GetBlocksByHeightReply{}
+	if err := service.GetBlocksByHeight(nil, &GetBlocksByHeightArgs{StartHeight: 1, EndHeight: 10}, &rangeReply); err != nil {
+		t.Fatal(err)
+	}
+	if len(rangeReply.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks but got %d", len(rangeReply.Blocks))
+	}
+	if rangeReply.Blocks[0].ID != block1.ID().String() || rangeReply.Blocks[1].ID != block2.ID().String() {
+		t.Fatal("expected blocks in height order")
+	}
+	if err := service.GetBlocksByHeight(nil, &GetBlocksByHeightArgs{StartHeight: 5, EndHeight: 10}, &rangeReply); err != nil {
+		t.Fatal(err)
+	}
+	if len(rangeReply.Blocks) != 0 {
+		t.Fatalf("expected no blocks past the last accepted one but got %d", len(rangeReply.Blocks))
+	}
+}
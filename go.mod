@@ -7,6 +7,7 @@ require (
 	github.com/gorilla/rpc v1.2.0
 	github.com/hashicorp/go-plugin v1.4.2
 	github.com/inconshreveable/log15 v0.0.0-20201112154412-8562bdadbbac
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 )